@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/cluster"
+)
+
+// Cluster command actions.
+const (
+	ClusterPublish = "publish"
+	ClusterStatus  = "status"
+	ClusterCheck   = "check"
+	ClusterPlan    = "plan"
+)
+
+// ClusterOptions are the arguments of the cluster command.
+type ClusterOptions struct {
+	Action   string
+	Dir      string
+	Node     string
+	VMID     int
+	Cores    int
+	Pool     string
+	Physical bool
+	ReserveOptions
+}
+
+// ParseCluster parses "cluster <publish|status|check|plan> [flags]".
+func ParseCluster(args []string) (*ClusterOptions, error) {
+	usage := fmt.Errorf("%w: usage: cluster <%s|%s|%s|%s> [flags]", ErrInvalidArguments,
+		ClusterPublish, ClusterStatus, ClusterCheck, ClusterPlan)
+	if len(args) == 0 {
+		return nil, usage
+	}
+	opts := &ClusterOptions{Action: args[0]}
+	switch opts.Action {
+	case ClusterPublish, ClusterStatus, ClusterCheck, ClusterPlan:
+	default:
+		return nil, usage
+	}
+
+	fs := flag.NewFlagSet("cluster "+opts.Action, flag.ContinueOnError)
+	fs.StringVar(&opts.Dir, "pve-dir", cluster.Dir, "Cluster file system")
+	switch opts.Action {
+	case ClusterCheck:
+		fs.StringVar(&opts.Node, "node", "", "Only check VMs on this node")
+	case ClusterPlan:
+		fs.IntVar(&opts.VMID, "vmid", 0, "VM to plan for, on whichever node it is")
+		fs.IntVar(&opts.Cores, "cores", 0, "Number of cores/vCPUs to allocate")
+		fs.StringVar(&opts.Pool, "pool", "", "CPU pool: exclude-isolated (default), isolated-only, all")
+		fs.BoolVar(&opts.Physical, "physical", false, "Use physical cores only (no SMT siblings)")
+		opts.ReserveOptions.register(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", ErrInvalidArguments, fs.Arg(0))
+	}
+
+	if opts.Action == ClusterPlan {
+		if opts.VMID <= 0 || opts.Cores <= 0 {
+			return nil, fmt.Errorf("%w: cluster plan requires --vmid and --cores", ErrInvalidArguments)
+		}
+		pool, err := normalizePool(opts.Pool)
+		if err != nil {
+			return nil, err
+		}
+		opts.Pool = pool
+		if err := opts.ReserveOptions.resolve(); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// normalizePool validates a --pool value; empty selects the default pool.
+func normalizePool(pool string) (string, error) {
+	if pool == "" {
+		return "", nil
+	}
+	normalized := strings.ToLower(strings.TrimSpace(pool))
+	switch normalized {
+	case string(affinity.PoolExcludeIsolated), string(affinity.PoolIsolatedOnly), string(affinity.PoolAll):
+		return normalized, nil
+	default:
+		return "", fmt.Errorf("%w: invalid pool %q (valid: exclude-isolated, isolated-only, all)",
+			ErrInvalidArguments, pool)
+	}
+}
+
+// MigrationOptions are the arguments of the check-migration command.
+type MigrationOptions struct {
+	VMID   int
+	Target string
+	Dir    string
+	// Pool and the reservation limit the affinity proposed for the target.
+	Pool string
+	ReserveOptions
+}
+
+// ParseCheckMigration parses "check-migration <vmid> <target-node>".
+func ParseCheckMigration(args []string) (*MigrationOptions, error) {
+	opts := &MigrationOptions{}
+	fs := flag.NewFlagSet("check-migration", flag.ContinueOnError)
+	fs.StringVar(&opts.Dir, "pve-dir", cluster.Dir, "Cluster file system")
+	fs.StringVar(&opts.Pool, "pool", "", "CPU pool for the proposal: exclude-isolated (default), isolated-only, all")
+	opts.ReserveOptions.register(fs)
+
+	// Flags may sit between and after the positional arguments.
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 2 {
+		return nil, fmt.Errorf("%w: usage: check-migration <vmid> <target-node>", ErrInvalidArguments)
+	}
+	vmid, err := strconv.Atoi(positional[0])
+	if err != nil || vmid <= 0 {
+		return nil, fmt.Errorf("%w: invalid VM ID %q", ErrInvalidArguments, positional[0])
+	}
+	opts.VMID = vmid
+	opts.Target = positional[1]
+	if opts.Pool, err = normalizePool(opts.Pool); err != nil {
+		return nil, err
+	}
+	if err := opts.ReserveOptions.resolve(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
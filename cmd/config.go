@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/topology"
+)
+
+// DefaultConfigPath is read when present; --config names another file.
+const DefaultConfigPath = "/etc/proxmox-affinity.conf"
+
+var ErrInvalidConfig = errors.New("invalid config file")
+
+// ReserveOptions select the CPUs kept for the host. Values from the config
+// file apply unless the matching flag is given.
+type ReserveOptions struct {
+	Config         string
+	Reserve        string
+	ReservePerNode int
+	// Reservation is filled by resolve.
+	Reservation affinity.Reservation
+}
+
+func (r *ReserveOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&r.Config, "config", DefaultConfigPath, "Config file")
+	fs.StringVar(&r.Reserve, "reserve", "", `Host CPUs no strategy may use, e.g. "0-1,64-65" ("none" ignores the config file)`)
+	fs.IntVar(&r.ReservePerNode, "reserve-per-node", 0, "Reserve the first N cores of each NUMA node for the host")
+}
+
+// resolve reads the config file and parses the reservation.
+func (r *ReserveOptions) resolve() error {
+	if r.ReservePerNode < 0 {
+		return fmt.Errorf("%w: --reserve-per-node must not be negative", ErrInvalidArguments)
+	}
+	if strings.EqualFold(strings.TrimSpace(r.Reserve), "none") {
+		r.Reservation = affinity.Reservation{CoresPerNode: r.ReservePerNode}
+		return nil
+	}
+
+	cfg, err := readConfig(r.Config)
+	if errors.Is(err, os.ErrNotExist) && r.Config == DefaultConfigPath {
+		cfg = map[string]string{}
+	} else if err != nil {
+		return err
+	}
+	if r.Reserve == "" {
+		r.Reserve = cfg["reserve"]
+	}
+	if r.ReservePerNode == 0 && cfg["reserve-per-node"] != "" {
+		n, err := strconv.Atoi(cfg["reserve-per-node"])
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: %s: reserve-per-node %q", ErrInvalidConfig, r.Config, cfg["reserve-per-node"])
+		}
+		r.ReservePerNode = n
+	}
+
+	r.Reservation = affinity.Reservation{CoresPerNode: r.ReservePerNode}
+	if r.Reserve != "" {
+		cpus, err := topology.ParseList(r.Reserve)
+		if err != nil {
+			return fmt.Errorf("%w: invalid reserved CPUs %q", ErrInvalidArguments, r.Reserve)
+		}
+		r.Reservation.CPUs = cpus
+	}
+	return nil
+}
+
+// readConfig parses "key: value" lines; "#" starts a comment.
+func readConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: %s line %d: expected \"key: value\"", ErrInvalidConfig, path, lineNo)
+		case key != "reserve" && key != "reserve-per-node":
+			return nil, fmt.Errorf("%w: %s line %d: unknown key %q", ErrInvalidConfig, path, lineNo, key)
+		}
+		cfg[key] = strings.TrimSpace(value)
+	}
+	return cfg, scanner.Err()
+}
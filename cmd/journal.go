@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"epyc-pve/internal/journal"
+)
+
+// HistoryOptions are the flags of the history command.
+type HistoryOptions struct {
+	VMID    int
+	Journal string
+}
+
+// RollbackOptions are the arguments of the rollback command.
+type RollbackOptions struct {
+	VMID    int
+	To      int
+	DryRun  bool
+	Journal string
+	APIOptions
+}
+
+// ParseHistory parses "history [--vmid N]".
+func ParseHistory(args []string) (*HistoryOptions, error) {
+	opts := &HistoryOptions{}
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.IntVar(&opts.VMID, "vmid", 0, "Only show changes of this VM")
+	fs.StringVar(&opts.Journal, "journal", journal.DefaultPath, "Journal file")
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", ErrInvalidArguments, fs.Arg(0))
+	}
+	return opts, nil
+}
+
+// ParseRollback parses "rollback <vmid> [--to N] [--dry-run]". Flags may
+// come before or after the VM ID.
+func ParseRollback(args []string) (*RollbackOptions, error) {
+	opts := &RollbackOptions{}
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	fs.IntVar(&opts.To, "to", 0, "Restore the affinity journal entry N applied (default: undo the latest change)")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Show command without executing")
+	fs.StringVar(&opts.Journal, "journal", journal.DefaultPath, "Journal file")
+	opts.APIOptions.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("%w: usage: rollback <vmid> [--to N] [--dry-run]", ErrInvalidArguments)
+	}
+	vmid, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || vmid <= 0 {
+		return nil, fmt.Errorf("%w: invalid VM ID %q", ErrInvalidArguments, fs.Arg(0))
+	}
+	opts.VMID = vmid
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", ErrInvalidArguments, fs.Arg(0))
+	}
+	if opts.To < 0 {
+		return nil, fmt.Errorf("%w: --to must be a journal entry number", ErrInvalidArguments)
+	}
+	if err := opts.APIOptions.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+)
+
+// KernelOptions are the flags of the kernel-cmdline command.
+type KernelOptions struct {
+	Write   bool
+	DryRun  bool
+	Sysroot string
+	ReserveOptions
+}
+
+// ParseKernelCmdline parses "kernel-cmdline [--write [--dry-run]]".
+func ParseKernelCmdline(args []string) (*KernelOptions, error) {
+	opts := &KernelOptions{}
+	fs := flag.NewFlagSet("kernel-cmdline", flag.ContinueOnError)
+	fs.BoolVar(&opts.Write, "write", false, "Update /etc/kernel/cmdline or /etc/default/grub and refresh the boot entries")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "With --write, show the new command line without writing it")
+	fs.StringVar(&opts.Sysroot, "sysroot", "", "Read sysfs, procfs and /etc from this root instead of /")
+	opts.ReserveOptions.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", ErrInvalidArguments, fs.Arg(0))
+	}
+	if opts.DryRun && !opts.Write {
+		return nil, fmt.Errorf("%w: --dry-run requires --write", ErrInvalidArguments)
+	}
+	if opts.Sysroot != "" && opts.Write && !opts.DryRun {
+		return nil, fmt.Errorf("%w: --sysroot describes another host, use it with --dry-run when writing", ErrInvalidArguments)
+	}
+	if err := opts.ReserveOptions.resolve(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
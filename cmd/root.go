@@ -4,21 +4,51 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
 
 	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/journal"
+	"epyc-pve/internal/pve"
 	"epyc-pve/internal/topology"
 )
 
 type Options struct {
 	ShowTopology bool
 	Cores        int
+	MemoryMB     int
 	VMID         int
 	Strategy     string
+	Pool         string
 	Apply        bool
+	Reset        bool
 	DryRun       bool
+	Force        bool
+	FixVCPUs     bool
+	Live         bool
+	LiveOnly     bool
+	PinVCPUs     bool
+	EmulatorCPUs int
+	EmulatorAt   string
+	Hookscript   string
+	EmitHook     string
 	Physical     bool
 	JSON         bool
+	Sysroot      string
+	Capture      string
+	FromSnapshot string
+	Journal      string
+	EmitSlices   string
+	APIOptions
+	ReserveOptions
+}
+
+// APIOptions select the REST API backend instead of qm.
+type APIOptions struct {
+	APIURL      string
+	APIToken    string
+	APINode     string
+	APIInsecure bool
 }
 
 var ErrInvalidArguments = errors.New("invalid arguments")
@@ -27,16 +57,58 @@ func ParseFlags() *Options {
 	opts := &Options{}
 	flag.BoolVar(&opts.ShowTopology, "topology", false, "Show CPU topology and exit")
 	flag.IntVar(&opts.Cores, "cores", 0, "Number of cores/vCPUs to allocate")
+	flag.IntVar(&opts.MemoryMB, "memory", 0, "VM memory in MiB, split across guest NUMA nodes (numa-local)")
 	flag.IntVar(&opts.VMID, "vmid", 0, "Target VM ID")
-	flag.StringVar(&opts.Strategy, "strategy", "", "Strategy: single-ccd, distributed, sequential, random")
+	flag.StringVar(&opts.Strategy, "strategy", "", "Strategy: single-ccd, numa-local, cache-ccd, frequency-ccd, distributed, sequential, random")
+	flag.StringVar(&opts.Pool, "pool", "", "CPU pool: exclude-isolated (default), isolated-only, all")
 	flag.BoolVar(&opts.Apply, "apply", false, "Apply affinity in CLI mode (non-interactive)")
+	flag.BoolVar(&opts.Reset, "reset", false, "Remove the affinity of --vmid (with --live, also unpin its running threads)")
 	flag.BoolVar(&opts.DryRun, "dry-run", false, "Show command without executing")
+	flag.BoolVar(&opts.Force, "force", false, "Apply even if the pinned CPU count differs from the VM's vCPUs")
+	flag.BoolVar(&opts.FixVCPUs, "fix-vcpus", false, "Set the VM's sockets/cores to match the pinned CPU count")
+	flag.BoolVar(&opts.Live, "live", false, "Also pin the running VM's threads now, without a restart")
+	flag.BoolVar(&opts.LiveOnly, "live-only", false, "Only pin the running VM's threads; leave the VM config unchanged")
+	flag.BoolVar(&opts.PinVCPUs, "pin-vcpus", false, "With --live/--live-only or a hookscript, pin each vCPU thread to its own host CPU")
+	flag.IntVar(&opts.EmulatorCPUs, "emulator-cpus", 0, "Reserve this many host CPUs for QEMU emulator/IO threads")
+	flag.StringVar(&opts.EmulatorAt, "emulator-placement", "", "Where emulator CPUs come from: same-ccd (default), housekeeping")
+	flag.StringVar(&opts.Hookscript, "hookscript", "", "Install a post-start hookscript into this storage's snippets and attach it")
+	flag.StringVar(&opts.EmitHook, "emit-hookscript", "", "Write the hookscript and its plan into this directory")
 	flag.BoolVar(&opts.Physical, "physical", false, "Use physical cores only (no SMT siblings)")
 	flag.BoolVar(&opts.JSON, "json", false, "Output in JSON format (with --topology)")
+	flag.StringVar(&opts.Sysroot, "sysroot", "", "Read sysfs/procfs from this root instead of / (e.g. a copy of another host)")
+	flag.StringVar(&opts.Capture, "capture", "", "Write a topology snapshot to this file and exit")
+	flag.StringVar(&opts.FromSnapshot, "from-snapshot", "", "Build the topology from a snapshot written by --capture")
+	flag.StringVar(&opts.Journal, "journal", journal.DefaultPath, "Record applied changes in this journal")
+	flag.StringVar(&opts.EmitSlices, "emit-slices", "", "Write systemd drop-ins limiting system.slice and user.slice to the reserved CPUs under this directory (e.g. /etc/systemd/system)")
+	opts.APIOptions.register(flag.CommandLine)
+	opts.ReserveOptions.register(flag.CommandLine)
 	flag.Parse()
 	return opts
 }
 
+func (a *APIOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.APIURL, "api-url", "", "Use the Proxmox REST API at this URL (e.g. https://pve1:8006) instead of qm")
+	fs.StringVar(&a.APIToken, "api-token", "", "API token USER@REALM!TOKENID=SECRET (default: $"+pve.APITokenEnv+")")
+	fs.StringVar(&a.APINode, "api-node", "", "Only reach VMs on this cluster node")
+	fs.BoolVar(&a.APIInsecure, "api-insecure", false, "Do not verify the API's TLS certificate")
+}
+
+func (a *APIOptions) validate() error {
+	if a.APIURL == "" {
+		if a.APINode != "" || a.APIInsecure {
+			return fmt.Errorf("%w: --api-node and --api-insecure require --api-url", ErrInvalidArguments)
+		}
+		return nil
+	}
+	if a.APIToken == "" {
+		a.APIToken = os.Getenv(pve.APITokenEnv)
+	}
+	if a.APIToken == "" {
+		return fmt.Errorf("%w: --api-url requires --api-token or $%s", ErrInvalidArguments, pve.APITokenEnv)
+	}
+	return nil
+}
+
 func Validate(opts *Options, topo *topology.CPUTopology) error {
 	if opts == nil {
 		return fmt.Errorf("%w: options are required", ErrInvalidArguments)
@@ -45,11 +117,110 @@ func Validate(opts *Options, topo *topology.CPUTopology) error {
 	if opts.ShowTopology && opts.Apply {
 		return fmt.Errorf("%w: --topology cannot be used with --apply", ErrInvalidArguments)
 	}
+	if opts.Reset && (opts.Apply || opts.ShowTopology) {
+		return fmt.Errorf("%w: --reset cannot be used with --apply or --topology", ErrInvalidArguments)
+	}
 	if opts.JSON && !opts.ShowTopology {
 		return fmt.Errorf("%w: --json requires --topology", ErrInvalidArguments)
 	}
-	if opts.DryRun && !opts.Apply {
-		return fmt.Errorf("%w: --dry-run requires --apply", ErrInvalidArguments)
+	if opts.DryRun && !opts.Apply && !opts.Reset && opts.EmitSlices == "" {
+		return fmt.Errorf("%w: --dry-run requires --apply, --reset or --emit-slices", ErrInvalidArguments)
+	}
+	if (opts.Force || opts.FixVCPUs) && !opts.Apply {
+		return fmt.Errorf("%w: --force and --fix-vcpus require --apply", ErrInvalidArguments)
+	}
+	if opts.Force && opts.FixVCPUs {
+		return fmt.Errorf("%w: --force cannot be used with --fix-vcpus", ErrInvalidArguments)
+	}
+	if (opts.Live || opts.LiveOnly) && !opts.Apply && !opts.Reset {
+		return fmt.Errorf("%w: --live and --live-only require --apply or --reset", ErrInvalidArguments)
+	}
+	if opts.Live && opts.LiveOnly {
+		return fmt.Errorf("%w: --live cannot be used with --live-only", ErrInvalidArguments)
+	}
+	if (opts.Hookscript != "" || opts.EmitHook != "") && !opts.Apply {
+		return fmt.Errorf("%w: --hookscript and --emit-hookscript require --apply", ErrInvalidArguments)
+	}
+	if opts.Hookscript != "" && opts.LiveOnly {
+		return fmt.Errorf("%w: --hookscript changes the VM config, which --live-only leaves alone", ErrInvalidArguments)
+	}
+	if opts.PinVCPUs && !opts.Live && !opts.LiveOnly && opts.Hookscript == "" && opts.EmitHook == "" {
+		return fmt.Errorf("%w: --pin-vcpus requires --live, --live-only or a hookscript", ErrInvalidArguments)
+	}
+	if opts.LiveOnly && opts.FixVCPUs {
+		return fmt.Errorf("%w: --fix-vcpus changes the VM config, which --live-only leaves alone", ErrInvalidArguments)
+	}
+	if opts.Pool != "" {
+		normalized := strings.ToLower(strings.TrimSpace(opts.Pool))
+		switch normalized {
+		case string(affinity.PoolExcludeIsolated), string(affinity.PoolIsolatedOnly), string(affinity.PoolAll):
+			opts.Pool = normalized
+		default:
+			return fmt.Errorf("%w: invalid pool %q (valid: exclude-isolated, isolated-only, all)",
+				ErrInvalidArguments, opts.Pool)
+		}
+	}
+	if opts.EmulatorCPUs < 0 {
+		return fmt.Errorf("%w: --emulator-cpus must not be negative", ErrInvalidArguments)
+	}
+	if opts.EmulatorAt != "" {
+		normalized := strings.ToLower(strings.TrimSpace(opts.EmulatorAt))
+		switch normalized {
+		case string(affinity.EmulatorSameCCD), string(affinity.EmulatorHousekeeping):
+			opts.EmulatorAt = normalized
+		default:
+			return fmt.Errorf("%w: invalid emulator placement %q (valid: same-ccd, housekeeping)",
+				ErrInvalidArguments, opts.EmulatorAt)
+		}
+	}
+	if opts.Sysroot != "" && opts.FromSnapshot != "" {
+		return fmt.Errorf("%w: --sysroot cannot be used with --from-snapshot", ErrInvalidArguments)
+	}
+	if opts.Capture != "" && (opts.ShowTopology || opts.Apply || opts.Reset || opts.FromSnapshot != "") {
+		return fmt.Errorf("%w: --capture cannot be used with --topology, --apply, --reset or --from-snapshot", ErrInvalidArguments)
+	}
+	if err := opts.APIOptions.validate(); err != nil {
+		return err
+	}
+	if err := opts.ReserveOptions.resolve(); err != nil {
+		return err
+	}
+	if opts.APIURL != "" && (opts.Live || opts.LiveOnly || opts.Hookscript != "") {
+		return fmt.Errorf("%w: --live, --live-only and --hookscript act on the local node and cannot be used with --api-url", ErrInvalidArguments)
+	}
+	// Through the API the VM runs elsewhere, so planning against this
+	// machine's CPUs would pin it to the wrong layout.
+	if opts.APIURL != "" && opts.Apply && opts.Sysroot == "" && opts.FromSnapshot == "" {
+		return fmt.Errorf("%w: --apply with --api-url needs the VM node's topology: --from-snapshot, --sysroot or a snapshot published with \"cluster publish\"", ErrInvalidArguments)
+	}
+	// Through the API the VM lives on the host the sysroot or snapshot
+	// describes, so applying is fine there.
+	if opts.Sysroot != "" && (opts.Apply || opts.Reset) && !opts.DryRun && opts.APIURL == "" {
+		return fmt.Errorf("%w: --sysroot describes another host, use it with --dry-run when applying", ErrInvalidArguments)
+	}
+	if opts.FromSnapshot != "" && (opts.Apply || opts.Reset) && !opts.DryRun && opts.APIURL == "" {
+		return fmt.Errorf("%w: --from-snapshot describes another host, use it with --dry-run when applying", ErrInvalidArguments)
+	}
+
+	if opts.EmitSlices != "" {
+		if opts.Apply || opts.Reset || opts.ShowTopology || opts.Capture != "" {
+			return fmt.Errorf("%w: --emit-slices cannot be used with --apply, --reset, --topology or --capture", ErrInvalidArguments)
+		}
+		if opts.Reservation.IsZero() {
+			return fmt.Errorf("%w: --emit-slices requires --reserve, --reserve-per-node or a reservation in %s", ErrInvalidArguments, opts.Config)
+		}
+		return nil
+	}
+
+	if opts.Reset {
+		if opts.VMID <= 0 {
+			return fmt.Errorf("%w: --vmid is required for --reset", ErrInvalidArguments)
+		}
+		if opts.Cores != 0 || opts.Strategy != "" || opts.Physical || opts.MemoryMB != 0 ||
+			opts.FixVCPUs || opts.Force || opts.PinVCPUs || opts.Hookscript != "" || opts.EmitHook != "" {
+			return fmt.Errorf("%w: --reset only takes --vmid, --live/--live-only and --dry-run", ErrInvalidArguments)
+		}
+		return nil
 	}
 
 	if opts.Apply {
@@ -59,10 +230,20 @@ func Validate(opts *Options, topo *topology.CPUTopology) error {
 		if opts.VMID <= 0 {
 			return fmt.Errorf("%w: --vmid is required for --apply mode", ErrInvalidArguments)
 		}
+		if opts.MemoryMB < 0 {
+			return fmt.Errorf("%w: --memory must not be negative", ErrInvalidArguments)
+		}
 
-		maxCores := topo.TotalCores
+		// Only the CPUs left by the pool and the host reservation can be
+		// handed out.
+		usable := (&affinity.Request{
+			Pool:     affinity.CPUPool(opts.Pool),
+			Reserved: opts.Reservation,
+			Topology: topo,
+		}).UsableTopology()
+		maxCores := usable.TotalCores
 		if !opts.Physical {
-			maxCores = topo.TotalCPUs
+			maxCores = usable.TotalCPUs
 		}
 		if opts.Cores > maxCores {
 			coreType := "vCPUs"
@@ -76,18 +257,20 @@ func Validate(opts *Options, topo *topology.CPUTopology) error {
 		if opts.Strategy != "" {
 			normalized := strings.ToLower(strings.TrimSpace(opts.Strategy))
 			switch normalized {
-			case string(affinity.StrategySingleCCD), string(affinity.StrategyDistributed),
-				string(affinity.StrategySequential), string(affinity.StrategyRandom):
+			case string(affinity.StrategySingleCCD), string(affinity.StrategyNUMALocal),
+				string(affinity.StrategyCacheCCD), string(affinity.StrategyFreqCCD),
+				string(affinity.StrategyDistributed), string(affinity.StrategySequential),
+				string(affinity.StrategyRandom):
 				opts.Strategy = normalized
 			default:
-				return fmt.Errorf("%w: invalid strategy %q (valid: single-ccd, distributed, sequential, random)",
+				return fmt.Errorf("%w: invalid strategy %q (valid: single-ccd, numa-local, cache-ccd, frequency-ccd, distributed, sequential, random)",
 					ErrInvalidArguments, opts.Strategy)
 			}
 		}
 		return nil
 	}
 
-	if opts.Cores != 0 || opts.VMID != 0 || opts.Strategy != "" || opts.Physical || opts.DryRun {
+	if opts.Cores != 0 || opts.VMID != 0 || opts.Strategy != "" || opts.Physical || opts.DryRun || opts.MemoryMB != 0 {
 		return fmt.Errorf("%w: use --apply for CLI mode, or run without flags for interactive mode", ErrInvalidArguments)
 	}
 
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+)
+
+// TranslateOptions are the arguments of the translate command. From and To
+// are topology snapshots or sysroot directories; empty means this host.
+type TranslateOptions struct {
+	Affinity string
+	From     string
+	To       string
+}
+
+// ParseTranslate parses "translate <affinity> [--from SRC] [--to DST]".
+// Flags may come before or after the affinity.
+func ParseTranslate(args []string) (*TranslateOptions, error) {
+	opts := &TranslateOptions{}
+	fs := flag.NewFlagSet("translate", flag.ContinueOnError)
+	fs.StringVar(&opts.From, "from", "", "Topology snapshot or sysroot the affinity was made for (default: this host)")
+	fs.StringVar(&opts.To, "to", "", "Topology snapshot or sysroot to translate to (default: this host)")
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("%w: usage: translate <affinity> [--from SRC] [--to DST]", ErrInvalidArguments)
+	}
+	opts.Affinity = fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", ErrInvalidArguments, fs.Arg(0))
+	}
+	if opts.From == "" && opts.To == "" {
+		return nil, fmt.Errorf("%w: translate needs --from or --to", ErrInvalidArguments)
+	}
+	return opts, nil
+}
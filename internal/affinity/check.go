@@ -0,0 +1,133 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+
+	"epyc-pve/internal/topology"
+)
+
+// Severity says whether a problem keeps an affinity from working.
+type Severity string
+
+const (
+	// SeverityError marks affinities QEMU cannot apply, e.g. CPUs the host
+	// does not have.
+	SeverityError Severity = "error"
+	// SeverityWarning marks affinities that work but perform worse than
+	// they could.
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is one finding of Check.
+type Problem struct {
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) String() string {
+	return string(p.Severity) + ": " + p.Message
+}
+
+// Check validates an affinity string against a host topology: every CPU
+// must exist and be online, and the set should hold the VM's vcpus, avoid
+// isolated CPUs and other VMs' CPUs in occupancy, and span no more core
+// groups than its cores need. vcpus 0 skips the size check.
+func Check(topo *topology.CPUTopology, affinityStr string, vcpus int, occupancy Occupancy) []Problem {
+	cpus, err := topology.ParseList(affinityStr)
+	if err != nil || len(cpus) == 0 {
+		return []Problem{{SeverityError, fmt.Sprintf("invalid affinity %q", affinityStr)}}
+	}
+
+	online := make(map[int]bool)
+	for _, cpu := range topo.OnlineCPUs() {
+		online[cpu] = true
+	}
+	offline := make(map[int]bool)
+	for _, cpu := range topo.OfflineCPUs {
+		offline[cpu] = true
+	}
+
+	var problems []Problem
+	var present, missing, down, isolated []int
+	for _, cpu := range cpus {
+		if online[cpu] {
+			present = append(present, cpu)
+		}
+		switch {
+		case offline[cpu]:
+			down = append(down, cpu)
+		case !online[cpu]:
+			missing = append(missing, cpu)
+		case topo.IsIsolated(cpu):
+			isolated = append(isolated, cpu)
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, Problem{SeverityError,
+			fmt.Sprintf("CPUs %s do not exist on this host (it has %d CPUs)", FormatCPUs(missing), topo.TotalCPUs+len(topo.OfflineCPUs))})
+	}
+	if len(down) > 0 {
+		problems = append(problems, Problem{SeverityError, fmt.Sprintf("CPUs %s are offline", FormatCPUs(down))})
+	}
+	if len(isolated) > 0 {
+		problems = append(problems, Problem{SeverityWarning, fmt.Sprintf("CPUs %s are isolated (isolcpus)", FormatCPUs(isolated))})
+	}
+	if vcpus > 0 && len(cpus) < vcpus {
+		problems = append(problems, Problem{SeverityWarning,
+			fmt.Sprintf("%d vCPUs share %d host CPUs", vcpus, len(cpus))})
+	}
+
+	if used, needed := groupsSpanned(topo, cpus); used > needed {
+		problems = append(problems, Problem{SeverityWarning,
+			fmt.Sprintf("spans %d core groups where %d would hold its cores", used, needed)})
+	}
+
+	if count, vmids := occupancy.Overlap(present); count > 0 {
+		problems = append(problems, Problem{SeverityWarning,
+			fmt.Sprintf("%d CPUs are also pinned by VM %s", count, formatVMIDs(vmids))})
+	}
+	return problems
+}
+
+// HasErrors reports whether any problem is an error.
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsSpanned returns how many core groups the online CPUs of cpus touch
+// and how few could hold their physical cores.
+func groupsSpanned(topo *topology.CPUTopology, cpus []int) (int, int) {
+	groups := make(map[int]bool)
+	cores := make(map[int]bool)
+	for _, cpu := range cpus {
+		for i, cg := range topo.CoreGroups {
+			if containsCPU(cg.AllCPUs, cpu) {
+				groups[i] = true
+				cores[topo.Threads(cpu)[0]] = true
+			}
+		}
+	}
+	if len(cores) == 0 {
+		return 0, 0
+	}
+	return len(groups), (&Request{CoresNeeded: len(cores), Topology: topo}).MinCCDsNeeded()
+}
+
+func formatVMIDs(vmids []int) string {
+	sorted := append([]int(nil), vmids...)
+	sort.Ints(sorted)
+	s := ""
+	for i, vmid := range sorted {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprint(vmid)
+	}
+	return s
+}
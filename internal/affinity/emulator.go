@@ -0,0 +1,160 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+
+	"epyc-pve/internal/topology"
+)
+
+// EmulatorPlacement selects where CPUs for QEMU's emulator and IO threads
+// (main loop, iothreads, vhost workers) come from.
+type EmulatorPlacement string
+
+const (
+	// EmulatorSameCCD takes spare cores from the CCDs the vCPUs use, so
+	// IO completions stay in the same L3; it falls back to housekeeping.
+	EmulatorSameCCD EmulatorPlacement = "same-ccd"
+	// EmulatorHousekeeping takes the lowest-numbered spare cores of the
+	// vCPUs' package, where host services usually run.
+	EmulatorHousekeeping EmulatorPlacement = "housekeeping"
+)
+
+func (r *Request) emulatorPlacement() EmulatorPlacement {
+	if r.EmulatorPlacement == "" {
+		return EmulatorSameCCD
+	}
+	return r.EmulatorPlacement
+}
+
+// assignEmulatorCPUs reserves req.EmulatorCPUs host CPUs outside the
+// option's vCPU set for emulator and IO threads. Whole cores are taken, so
+// emulator threads do not share a core with a vCPU, and cores other VMs are
+// pinned to are only used when no free core is left.
+func assignEmulatorCPUs(req *Request, option *Option) {
+	if req.EmulatorCPUs <= 0 || len(option.CPUs) == 0 {
+		return
+	}
+	topo := req.Topology
+
+	used := make(map[int]bool, len(option.CPUs))
+	for _, cpu := range option.CPUs {
+		used[cpu] = true
+	}
+	spare := func(core int) bool {
+		for _, cpu := range topo.Threads(core) {
+			if used[cpu] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var candidates []int
+	if req.emulatorPlacement() == EmulatorSameCCD {
+		for _, cg := range topo.CoreGroups {
+			if !groupContainsAny(cg, used) {
+				continue
+			}
+			for _, core := range cg.PhysicalCPUs {
+				if spare(core) {
+					candidates = append(candidates, core)
+				}
+			}
+		}
+	}
+	candidates = append(candidates, housekeepingCores(topo, option.CPUs[0], spare)...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return !req.Occupancy.coreBusy(topo, candidates[i]) && req.Occupancy.coreBusy(topo, candidates[j])
+	})
+
+	seen := make(map[int]bool)
+	var emulator []int
+	for _, core := range candidates {
+		if len(emulator) >= req.EmulatorCPUs {
+			break
+		}
+		if seen[core] {
+			continue
+		}
+		seen[core] = true
+		threads := []int{core}
+		if req.IncludeSMT {
+			threads = topo.Threads(core)
+		}
+		for _, cpu := range threads {
+			if len(emulator) < req.EmulatorCPUs {
+				emulator = append(emulator, cpu)
+			}
+		}
+	}
+
+	sort.Ints(emulator)
+	option.EmulatorCPUs = emulator
+	if len(emulator) < req.EmulatorCPUs {
+		option.Description += fmt.Sprintf(" (only %d of %d emulator CPUs free)", len(emulator), req.EmulatorCPUs)
+	}
+}
+
+// housekeepingCores lists spare cores lowest-numbered first, those in the
+// package of cpu ahead of the rest.
+func housekeepingCores(topo *topology.CPUTopology, cpu int, spare func(int) bool) []int {
+	pkg := -1
+	for _, cg := range topo.CoreGroups {
+		if containsCPU(cg.AllCPUs, cpu) {
+			pkg = cg.PackageID
+			break
+		}
+	}
+
+	var local, remote []int
+	for _, cg := range topo.CoreGroups {
+		for _, core := range cg.PhysicalCPUs {
+			if !spare(core) {
+				continue
+			}
+			if cg.PackageID == pkg {
+				local = append(local, core)
+			} else {
+				remote = append(remote, core)
+			}
+		}
+	}
+	sort.Ints(local)
+	sort.Ints(remote)
+	return append(local, remote...)
+}
+
+func groupContainsAny(cg topology.CoreGroup, cpus map[int]bool) bool {
+	for _, cpu := range cg.AllCPUs {
+		if cpus[cpu] {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCPU(cpus []int, cpu int) bool {
+	for _, c := range cpus {
+		if c == cpu {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessCPUs returns every host CPU the QEMU process may use: the vCPU set
+// plus any emulator CPUs. This is what the VM's affinity setting must hold.
+func (o *Option) ProcessCPUs() []int {
+	if len(o.EmulatorCPUs) == 0 {
+		return o.CPUs
+	}
+	cpus := append(append([]int(nil), o.CPUs...), o.EmulatorCPUs...)
+	sort.Ints(cpus)
+	return dedupeSorted(cpus)
+}
+
+// ProcessAffinity formats ProcessCPUs for the VM's affinity setting.
+func (o *Option) ProcessAffinity() string {
+	return FormatCPUs(o.ProcessCPUs())
+}
@@ -0,0 +1,53 @@
+package affinity
+
+import "testing"
+
+func TestEmulatorCPUsAvoidBusyCores(t *testing.T) {
+	// One CCD of 4 SMT2 cores; VM 300 is pinned to core 1 (CPUs 1 and 5).
+	topo := testTopology([]int{0}, smtCores(0, 4, 2, 4))
+	req := &Request{
+		CoresNeeded:  2,
+		IncludeSMT:   true,
+		Occupancy:    Occupancy{1: {300}, 5: {300}},
+		EmulatorCPUs: 2,
+		Topology:     topo,
+	}
+
+	options, err := Generate(req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, opt := range options {
+		if opt.Strategy != StrategySingleCCD {
+			continue
+		}
+		if got, want := FormatCPUs(opt.CPUs), "0,4"; got != want {
+			t.Errorf("vCPUs: got %s, want %s", got, want)
+		}
+		if got, want := FormatCPUs(opt.EmulatorCPUs), "2,6"; got != want {
+			t.Errorf("emulator CPUs: got %s, want %s", got, want)
+		}
+		if opt.Overlap != 0 {
+			t.Errorf("overlap: got %d with VMs %v, want none", opt.Overlap, opt.OverlapVMs)
+		}
+	}
+
+	// With every other core taken the emulator has to share core 1, and
+	// the overlap has to say so.
+	req.Occupancy = Occupancy{1: {300}, 5: {300}, 2: {301}, 6: {301}, 3: {302}, 7: {302}}
+	options, err = Generate(req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, opt := range options {
+		if opt.Strategy != StrategySingleCCD {
+			continue
+		}
+		if got, want := FormatCPUs(opt.EmulatorCPUs), "1,5"; got != want {
+			t.Errorf("emulator CPUs: got %s, want %s", got, want)
+		}
+		if opt.Overlap != 2 || len(opt.OverlapVMs) != 1 || opt.OverlapVMs[0] != 300 {
+			t.Errorf("overlap: got %d with VMs %v, want 2 with VM 300", opt.Overlap, opt.OverlapVMs)
+		}
+	}
+}
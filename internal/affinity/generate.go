@@ -20,15 +20,21 @@ func Generate(req *Request) ([]Option, error) {
 		return nil, errors.New("cores needed must be greater than zero")
 	}
 
-	physicalCoresNeeded := req.CoresNeeded
-	if req.IncludeSMT && req.Topology.HasSMT {
-		physicalCoresNeeded = (req.CoresNeeded + 1) / 2
+	req = req.usable()
+	if req.Topology.TotalCPUs == 0 {
+		return nil, fmt.Errorf("no CPUs available in pool %q", req.pool())
 	}
+	req = req.preferFree()
+
+	physicalCoresNeeded := PhysicalCoresNeeded(req.Topology, req.CoresNeeded, req.IncludeSMT)
 
 	if physicalCoresNeeded > req.Topology.TotalCores {
 		return nil, fmt.Errorf("not enough cores. need %d physical cores for %d vCPUs, but only %d available",
 			physicalCoresNeeded, req.CoresNeeded, req.Topology.TotalCores)
 	}
+	if available := req.GroupCPUs(req.Topology.CoreGroups...); available < req.CoresNeeded {
+		return nil, fmt.Errorf("not enough CPUs. need %d, but only %d available", req.CoresNeeded, available)
+	}
 
 	switch req.Topology.Architecture {
 	case topology.ArchIntelHybrid:
@@ -41,6 +47,9 @@ func Generate(req *Request) ([]Option, error) {
 func generateAMDOptions(req *Request, physicalCoresNeeded int) ([]Option, error) {
 	options := []Option{
 		*generateSingleCCD(req, physicalCoresNeeded),
+		*generateNUMALocal(req, physicalCoresNeeded),
+		*generateCacheCCD(req, physicalCoresNeeded),
+		*generateFrequencyCCD(req, physicalCoresNeeded),
 		*generateDistributed(req, physicalCoresNeeded),
 		*generateSequential(req, physicalCoresNeeded),
 		*generateRandom(req, physicalCoresNeeded),
@@ -49,6 +58,8 @@ func generateAMDOptions(req *Request, physicalCoresNeeded int) ([]Option, error)
 
 	for i := range options {
 		options[i].AffinityStr = FormatCPUs(options[i].CPUs)
+		assignEmulatorCPUs(req, &options[i])
+		options[i].setOverlap(req.Occupancy)
 	}
 
 	return options, nil
@@ -65,6 +76,8 @@ func generateIntelOptions(req *Request, physicalCoresNeeded int) ([]Option, erro
 
 	for i := range options {
 		options[i].AffinityStr = FormatCPUs(options[i].CPUs)
+		assignEmulatorCPUs(req, &options[i])
+		options[i].setOverlap(req.Occupancy)
 	}
 
 	return options, nil
@@ -78,12 +91,12 @@ func generatePCoresOnly(req *Request, physicalCoresNeeded int) *Option {
 	}
 
 	pCores := req.Topology.GetPCoresCPUs()
-	if len(pCores) < physicalCoresNeeded {
-		option.Description = fmt.Sprintf("Unavailable: only %d P-cores, need %d", len(pCores), physicalCoresNeeded)
+	selectedPhysical, ok := takeCores(pCores, req, req.CoresNeeded)
+	if !ok {
+		option.Description = fmt.Sprintf("Unavailable: P-cores hold only %d CPUs, need %d", req.countCPUs(pCores), req.CoresNeeded)
 		return option
 	}
 
-	selectedPhysical := pCores[:physicalCoresNeeded]
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
 	option.CCDsUsed = 1
 	return option
@@ -97,12 +110,12 @@ func generateECoresOnly(req *Request, physicalCoresNeeded int) *Option {
 	}
 
 	eCores := req.Topology.GetECoresCPUs()
-	if len(eCores) < physicalCoresNeeded {
-		option.Description = fmt.Sprintf("Unavailable: only %d E-cores, need %d", len(eCores), physicalCoresNeeded)
+	selectedPhysical, ok := takeCores(eCores, req, req.CoresNeeded)
+	if !ok {
+		option.Description = fmt.Sprintf("Unavailable: E-cores hold only %d CPUs, need %d", req.countCPUs(eCores), req.CoresNeeded)
 		return option
 	}
 
-	selectedPhysical := eCores[:physicalCoresNeeded]
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
 	option.CCDsUsed = 1
 	return option
@@ -117,14 +130,20 @@ func generateAllCores(req *Request, physicalCoresNeeded int) *Option {
 
 	pCores := req.Topology.GetPCoresCPUs()
 	eCores := req.Topology.GetECoresCPUs()
-
-	selectedPhysical := make([]int, 0, physicalCoresNeeded)
-	selectedPhysical = append(selectedPhysical, pCores...)
-	selectedPhysical = append(selectedPhysical, eCores...)
-	sort.Ints(selectedPhysical)
-
-	if len(selectedPhysical) > physicalCoresNeeded {
-		selectedPhysical = selectedPhysical[:physicalCoresNeeded]
+	lpeCores := req.Topology.GetLPECoresCPUs()
+
+	allPhysical := make([]int, 0, len(pCores)+len(eCores)+len(lpeCores))
+	allPhysical = append(allPhysical, pCores...)
+	allPhysical = append(allPhysical, eCores...)
+	sort.Ints(allPhysical)
+	// LP E-cores sit outside the shared L3; only use them when the
+	// compute tile runs out.
+	allPhysical = append(allPhysical, lpeCores...)
+
+	selectedPhysical, ok := takeCores(allPhysical, req, req.CoresNeeded)
+	if !ok {
+		option.Description = fmt.Sprintf("Unavailable: cores hold only %d CPUs, need %d", req.GroupCPUs(req.Topology.CoreGroups...), req.CoresNeeded)
+		return option
 	}
 
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
@@ -139,18 +158,110 @@ func generateSingleCCD(req *Request, physicalCoresNeeded int) *Option {
 		Description: "All cores from one CCD (best cache locality)",
 	}
 
-	for _, cg := range req.Topology.CoreGroups {
-		if len(cg.PhysicalCPUs) >= physicalCoresNeeded {
-			physicalCores := make([]int, physicalCoresNeeded)
-			copy(physicalCores, cg.PhysicalCPUs[:physicalCoresNeeded])
-
+	for _, cg := range req.byOccupancy(req.Topology.CoreGroups) {
+		if physicalCores, ok := takeCores(cg.PhysicalCPUs, req, req.CoresNeeded); ok {
 			option.CPUs = expandToVCPUs(physicalCores, req.IncludeSMT, req.Topology)
 			option.CCDsUsed = 1
 			return option
 		}
 	}
 
-	option.Description = fmt.Sprintf("Unavailable: no single CCD has %d cores", physicalCoresNeeded)
+	// No single L3 domain fits. On parts with several CCXs per CCD the
+	// next tightest domain is the die, so fill one die's CCXs instead.
+	for _, die := range groupsByDie(req.byOccupancy(req.Topology.CoreGroups)) {
+		if len(die) < 2 || req.GroupCPUs(die...) < req.CoresNeeded {
+			continue
+		}
+		physicalCores, _ := fillGroups(req, die, req.CoresNeeded)
+		option.CPUs = expandToVCPUs(physicalCores, req.IncludeSMT, req.Topology)
+		option.CCDsUsed = countCCDsUsedByPhysical(physicalCores, req.Topology)
+		option.Description = fmt.Sprintf("All cores from one CCD across %d CCXs", option.CCDsUsed)
+		return option
+	}
+
+	option.Description = fmt.Sprintf("Unavailable: no single CCD has %d CPUs", req.CoresNeeded)
+	return option
+}
+
+func generateCacheCCD(req *Request, physicalCoresNeeded int) *Option {
+	option := &Option{
+		Strategy:    StrategyCacheCCD,
+		Name:        "Largest Cache CCD",
+		Description: "Cores from the CCD with the most L3 (X3D V-Cache die)",
+	}
+	return generatePreferredCCD(req, physicalCoresNeeded, option, "L3 size", func(cg topology.CoreGroup) int {
+		return cg.L3SizeKB
+	}, nil)
+}
+
+func generateFrequencyCCD(req *Request, physicalCoresNeeded int) *Option {
+	option := &Option{
+		Strategy:    StrategyFreqCCD,
+		Name:        "Highest Frequency CCD",
+		Description: "Cores from the CCD with the highest boost clock",
+	}
+	// Ties on frequency go to the smaller cache: on X3D parts the die
+	// without stacked cache clocks higher even when cpufreq reports the same.
+	return generatePreferredCCD(req, physicalCoresNeeded, option, "max frequency", func(cg topology.CoreGroup) int {
+		return cg.MaxFreqKHz
+	}, func(cg topology.CoreGroup) int {
+		return -cg.L3SizeKB
+	})
+}
+
+// generatePreferredCCD ranks CCDs by score (then tiebreak), highest first.
+// It uses the best CCD that fits the request, and otherwise fills CCDs in
+// rank order. It is unavailable when no CCD ranks above another.
+func generatePreferredCCD(req *Request, physicalCoresNeeded int, option *Option, property string,
+	score func(topology.CoreGroup) int, tiebreak func(topology.CoreGroup) int) *Option {
+	groups := req.byOccupancy(nonEmptyGroups(sortedCoreGroups(req.Topology.CoreGroups)))
+	rank := func(cg topology.CoreGroup) [2]int {
+		r := [2]int{score(cg), 0}
+		if tiebreak != nil {
+			r[1] = tiebreak(cg)
+		}
+		return r
+	}
+
+	distinct := make(map[[2]int]bool)
+	for _, cg := range groups {
+		distinct[rank(cg)] = true
+	}
+	if len(distinct) < 2 {
+		option.Description = fmt.Sprintf("Unavailable: all CCDs have the same %s", property)
+		return option
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		ri, rj := rank(groups[i]), rank(groups[j])
+		if ri[0] != rj[0] {
+			return ri[0] > rj[0]
+		}
+		return ri[1] > rj[1]
+	})
+
+	var selectedPhysical []int
+	for _, cg := range groups {
+		if cores, ok := takeCores(cg.PhysicalCPUs, req, req.CoresNeeded); ok {
+			selectedPhysical = cores
+			break
+		}
+	}
+	if selectedPhysical == nil {
+		var ranked []int
+		for _, cg := range groups {
+			ranked = append(ranked, cg.PhysicalCPUs...)
+		}
+		cores, ok := takeCores(ranked, req, req.CoresNeeded)
+		if !ok {
+			option.Description = fmt.Sprintf("Unavailable: CCDs hold only %d CPUs, need %d", req.GroupCPUs(groups...), req.CoresNeeded)
+			return option
+		}
+		selectedPhysical = cores
+	}
+
+	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
+	option.CCDsUsed = countCCDsUsedByPhysical(selectedPhysical, req.Topology)
 	return option
 }
 
@@ -166,16 +277,19 @@ func generateDistributed(req *Request, physicalCoresNeeded int) *Option {
 	usedCCDs := make(map[int]struct{})
 	positions := make([]int, len(coreGroups))
 
-	for len(selectedPhysical) < physicalCoresNeeded {
+	cpus := 0
+	for cpus < req.CoresNeeded {
 		progress := false
 		for i, cg := range coreGroups {
-			if len(selectedPhysical) >= physicalCoresNeeded {
+			if cpus >= req.CoresNeeded {
 				break
 			}
 			if positions[i] >= len(cg.PhysicalCPUs) {
 				continue
 			}
-			selectedPhysical = append(selectedPhysical, cg.PhysicalCPUs[positions[i]])
+			core := cg.PhysicalCPUs[positions[i]]
+			selectedPhysical = append(selectedPhysical, core)
+			cpus += req.coreCPUs(core)
 			positions[i]++
 			usedCCDs[i] = struct{}{}
 			progress = true
@@ -184,6 +298,10 @@ func generateDistributed(req *Request, physicalCoresNeeded int) *Option {
 			break
 		}
 	}
+	if cpus < req.CoresNeeded {
+		option.Description = fmt.Sprintf("Unavailable: CCDs hold only %d CPUs, need %d", cpus, req.CoresNeeded)
+		return option
+	}
 
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
 	option.CCDsUsed = len(usedCCDs)
@@ -198,9 +316,10 @@ func generateSequential(req *Request, physicalCoresNeeded int) *Option {
 	}
 
 	allPhysical := allPhysicalCPUsSorted(req.Topology)
-	selectedPhysical := allPhysical
-	if len(selectedPhysical) > physicalCoresNeeded {
-		selectedPhysical = selectedPhysical[:physicalCoresNeeded]
+	selectedPhysical, ok := takeCores(allPhysical, req, req.CoresNeeded)
+	if !ok {
+		option.Description = fmt.Sprintf("Unavailable: cores hold only %d CPUs, need %d", req.GroupCPUs(req.Topology.CoreGroups...), req.CoresNeeded)
+		return option
 	}
 
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
@@ -215,55 +334,108 @@ func generateRandom(req *Request, physicalCoresNeeded int) *Option {
 		Description: "Randomly select from minimum CCDs needed",
 	}
 
-	coreGroups := req.Topology.CoreGroups
+	coreGroups := nonEmptyGroups(req.Topology.CoreGroups)
 	if len(coreGroups) == 0 {
 		return option
 	}
 
-	coresPerCCD := len(coreGroups[0].PhysicalCPUs)
-	minCCDsNeeded := (physicalCoresNeeded + coresPerCCD - 1) / coresPerCCD
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	selected := pickRandomGroups(req, coreGroups, req.MinCCDsNeeded(), rng)
 
-	if minCCDsNeeded > len(coreGroups) {
-		minCCDsNeeded = len(coreGroups)
+	selectedPhysical, ok := fillGroups(req, req.byOccupancy(selected), req.CoresNeeded)
+	if !ok {
+		option.Description = fmt.Sprintf("Unavailable: %d CCDs hold only %d CPUs, need %d",
+			len(selected), req.GroupCPUs(selected...), req.CoresNeeded)
+		return option
 	}
 
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ccdIndices := make([]int, len(coreGroups))
-	for i := range ccdIndices {
-		ccdIndices[i] = i
+	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
+	option.CCDsUsed = len(selected)
+	return option
+}
+
+// pickRandomGroups draws count groups at random, restricting each draw to
+// groups that still let the remaining draws reach the requested CPUs and,
+// among those, to the ones with the fewest cores pinned by other VMs. With
+// count from MinCCDsNeeded such a choice always exists when the groups hold
+// enough CPUs in total. The result keeps topology order.
+func pickRandomGroups(req *Request, coreGroups []topology.CoreGroup, count int, rng *rand.Rand) []topology.CoreGroup {
+	remaining := make([]int, len(coreGroups))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	var chosen []int
+	have := 0
+	for len(chosen) < count && len(remaining) > 0 {
+		var candidates []int
+		leastBusy := -1
+		for pos, idx := range remaining {
+			rest := largestSizes(req, coreGroups, remaining, pos, count-len(chosen)-1)
+			if have+req.GroupCPUs(coreGroups[idx])+rest < req.CoresNeeded {
+				continue
+			}
+			busy := req.Occupancy.busyCores(req.Topology, coreGroups[idx])
+			if leastBusy < 0 || busy < leastBusy {
+				leastBusy = busy
+				candidates = candidates[:0]
+			}
+			if busy == leastBusy {
+				candidates = append(candidates, pos)
+			}
+		}
+		if len(candidates) == 0 {
+			// Not reachable: fall back to the largest group.
+			candidates = []int{largestGroupPos(req, coreGroups, remaining)}
+		}
+
+		pos := candidates[rng.Intn(len(candidates))]
+		idx := remaining[pos]
+		chosen = append(chosen, idx)
+		have += req.GroupCPUs(coreGroups[idx])
+		remaining = append(remaining[:pos], remaining[pos+1:]...)
 	}
-	rng.Shuffle(len(ccdIndices), func(i, j int) {
-		ccdIndices[i], ccdIndices[j] = ccdIndices[j], ccdIndices[i]
-	})
 
-	selectedCCDs := ccdIndices[:minCCDsNeeded]
-	sort.Ints(selectedCCDs)
+	sort.Ints(chosen)
+	result := make([]topology.CoreGroup, 0, len(chosen))
+	for _, idx := range chosen {
+		result = append(result, coreGroups[idx])
+	}
+	return result
+}
 
-	selectedPhysical := make([]int, 0, physicalCoresNeeded)
-	for _, ccdIdx := range selectedCCDs {
-		cg := coreGroups[ccdIdx]
-		for _, phys := range cg.PhysicalCPUs {
-			if len(selectedPhysical) >= physicalCoresNeeded {
-				break
-			}
-			selectedPhysical = append(selectedPhysical, phys)
+// largestSizes sums the CPU counts of the n largest groups in remaining,
+// leaving out the one at position skip.
+func largestSizes(req *Request, coreGroups []topology.CoreGroup, remaining []int, skip, n int) int {
+	sizes := make([]int, 0, len(remaining))
+	for pos, idx := range remaining {
+		if pos != skip {
+			sizes = append(sizes, req.GroupCPUs(coreGroups[idx]))
 		}
 	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
 
-	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
-	option.CCDsUsed = minCCDsNeeded
-	return option
+	total := 0
+	for i := 0; i < n && i < len(sizes); i++ {
+		total += sizes[i]
+	}
+	return total
 }
 
-func generateManualPlaceholder(req *Request, physicalCoresNeeded int) *Option {
-	coreGroups := req.Topology.CoreGroups
-	coresPerCCD := 0
-	if len(coreGroups) > 0 {
-		coresPerCCD = len(coreGroups[0].PhysicalCPUs)
+func largestGroupPos(req *Request, coreGroups []topology.CoreGroup, remaining []int) int {
+	best := 0
+	for pos, idx := range remaining {
+		if req.GroupCPUs(coreGroups[idx]) > req.GroupCPUs(coreGroups[remaining[best]]) {
+			best = pos
+		}
 	}
-	minCCDsNeeded := 1
-	if coresPerCCD > 0 {
-		minCCDsNeeded = (physicalCoresNeeded + coresPerCCD - 1) / coresPerCCD
+	return best
+}
+
+func generateManualPlaceholder(req *Request, physicalCoresNeeded int) *Option {
+	minCCDsNeeded := req.MinCCDsNeeded()
+	if minCCDsNeeded == 0 {
+		minCCDsNeeded = 1
 	}
 
 	return &Option{
@@ -282,30 +454,24 @@ func GenerateManual(req *Request, selectedCCDIndices []int) (*Option, error) {
 		return nil, errors.New("no CCDs selected")
 	}
 
-	physicalCoresNeeded := req.CoresNeeded
-	if req.IncludeSMT && req.Topology.HasSMT {
-		physicalCoresNeeded = (req.CoresNeeded + 1) / 2
-	}
+	req = req.usable().preferFree()
 
 	coreGroups := req.Topology.CoreGroups
 	sort.Ints(selectedCCDIndices)
 
-	selectedPhysical := make([]int, 0, physicalCoresNeeded)
+	var selectedGroups []topology.CoreGroup
+	var candidates []int
 	for _, ccdIdx := range selectedCCDIndices {
 		if ccdIdx < 0 || ccdIdx >= len(coreGroups) {
 			continue
 		}
-		cg := coreGroups[ccdIdx]
-		for _, phys := range cg.PhysicalCPUs {
-			if len(selectedPhysical) >= physicalCoresNeeded {
-				break
-			}
-			selectedPhysical = append(selectedPhysical, phys)
-		}
+		selectedGroups = append(selectedGroups, coreGroups[ccdIdx])
+		candidates = append(candidates, coreGroups[ccdIdx].PhysicalCPUs...)
 	}
 
-	if len(selectedPhysical) < physicalCoresNeeded {
-		return nil, fmt.Errorf("selected CCDs only have %d cores, need %d", len(selectedPhysical), physicalCoresNeeded)
+	selectedPhysical, ok := takeCores(candidates, req, req.CoresNeeded)
+	if !ok {
+		return nil, fmt.Errorf("selected CCDs only have %d CPUs, need %d", req.GroupCPUs(selectedGroups...), req.CoresNeeded)
 	}
 
 	option := &Option{
@@ -316,18 +482,123 @@ func GenerateManual(req *Request, selectedCCDIndices []int) (*Option, error) {
 	}
 	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, req.Topology)
 	option.AffinityStr = FormatCPUs(option.CPUs)
+	assignEmulatorCPUs(req, option)
+	option.setOverlap(req.Occupancy)
 	return option, nil
 }
 
-func MinCCDsNeeded(topo *topology.CPUTopology, physicalCoresNeeded int) int {
-	if len(topo.CoreGroups) == 0 {
-		return 0
+// UsableTopology returns the part of the request's topology that strategies
+// may draw from: online CPUs in the requested pool that are not reserved.
+func (r *Request) UsableTopology() *topology.CPUTopology {
+	topo := r.Topology
+	reserved := make(map[int]bool)
+	for _, cpu := range r.Reserved.Resolve(topo) {
+		reserved[cpu] = true
+	}
+	switch r.pool() {
+	case PoolIsolatedOnly:
+		return topo.Subset(func(cpu int) bool { return topo.IsIsolated(cpu) && !reserved[cpu] })
+	case PoolAll:
+		if len(reserved) == 0 {
+			return topo
+		}
+		return topo.Subset(func(cpu int) bool { return !reserved[cpu] })
+	default:
+		return topo.Subset(func(cpu int) bool { return !topo.IsIsolated(cpu) && !reserved[cpu] })
 	}
-	coresPerCCD := len(topo.CoreGroups[0].PhysicalCPUs)
-	if coresPerCCD == 0 {
-		return 0
+}
+
+func (r *Request) pool() CPUPool {
+	if r.Pool == "" {
+		return PoolExcludeIsolated
 	}
-	return (physicalCoresNeeded + coresPerCCD - 1) / coresPerCCD
+	return r.Pool
+}
+
+// usable returns a copy of the request restricted to its usable topology.
+func (r *Request) usable() *Request {
+	restricted := *r
+	restricted.Topology = r.UsableTopology()
+	return &restricted
+}
+
+// PhysicalCoresNeeded converts a requested CPU count into physical cores.
+// With SMT it divides by the widest core on the host, so SMT4 parts need a
+// quarter as many cores as vCPUs.
+func PhysicalCoresNeeded(topo *topology.CPUTopology, coresNeeded int, includeSMT bool) int {
+	if !includeSMT || !topo.HasSMT {
+		return coresNeeded
+	}
+	width := topo.ThreadsPerCore()
+	return (coresNeeded + width - 1) / width
+}
+
+// takeCores returns the leading cores of an ordered list that add up to
+// need CPUs. With SMT it counts each core's real threads, so single-threaded
+// cores (E-cores, cores with an offline or reserved sibling) are not
+// mistaken for two. It reports false when the list cannot cover need.
+func takeCores(cores []int, req *Request, need int) ([]int, bool) {
+	if need <= 0 {
+		return nil, true
+	}
+	cpus := 0
+	for i, core := range cores {
+		cpus += req.coreCPUs(core)
+		if cpus >= need {
+			return cores[:i+1], true
+		}
+	}
+	return cores, false
+}
+
+// coreCPUs returns how many CPUs core adds to an option: its threads when
+// the request includes SMT, otherwise one.
+func (r *Request) coreCPUs(core int) int {
+	if !r.IncludeSMT || !r.Topology.HasSMT {
+		return 1
+	}
+	return len(r.Topology.Threads(core))
+}
+
+// GroupCPUs returns how many CPUs the request can take from groups, counting
+// each core's threads when it includes SMT.
+func (r *Request) GroupCPUs(groups ...topology.CoreGroup) int {
+	total := 0
+	for _, cg := range groups {
+		total += r.countCPUs(cg.PhysicalCPUs)
+	}
+	return total
+}
+
+// countCPUs returns how many CPUs the request can take from cores.
+func (r *Request) countCPUs(cores []int) int {
+	total := 0
+	for _, core := range cores {
+		total += r.coreCPUs(core)
+	}
+	return total
+}
+
+// MinCCDsNeeded returns the fewest core groups that together hold the
+// requested CPUs, taking the largest groups first so CCDs with disabled
+// cores, offline siblings and mixed-size groups are counted correctly. If
+// all groups together are too small it returns the number of groups.
+func (r *Request) MinCCDsNeeded() int {
+	coreGroups := nonEmptyGroups(r.Topology.CoreGroups)
+	sizes := make([]int, 0, len(coreGroups))
+	for _, cg := range coreGroups {
+		sizes = append(sizes, r.GroupCPUs(cg))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	total := 0
+	for i, size := range sizes {
+		total += size
+		if total >= r.CoresNeeded {
+			return i + 1
+		}
+	}
+	return len(sizes)
 }
 
 func expandToVCPUs(physicalCores []int, includeSMT bool, topo *topology.CPUTopology) []int {
@@ -338,25 +609,9 @@ func expandToVCPUs(physicalCores []int, includeSMT bool, topo *topology.CPUTopol
 		return result
 	}
 
-	physicalToSiblings := make(map[int][]int)
-	for _, cg := range topo.CoreGroups {
-		numPhysical := len(cg.PhysicalCPUs)
-		for i, phys := range cg.PhysicalCPUs {
-			siblings := []int{phys}
-			if i+numPhysical < len(cg.AllCPUs) {
-				siblings = append(siblings, cg.AllCPUs[i+numPhysical])
-			}
-			physicalToSiblings[phys] = siblings
-		}
-	}
-
-	result := make([]int, 0, len(physicalCores)*2)
+	result := make([]int, 0, len(physicalCores)*topo.ThreadsPerCore())
 	for _, phys := range physicalCores {
-		if siblings, ok := physicalToSiblings[phys]; ok {
-			result = append(result, siblings...)
-		} else {
-			result = append(result, phys)
-		}
+		result = append(result, topo.Threads(phys)...)
 	}
 
 	sort.Ints(result)
@@ -418,6 +673,73 @@ func sortedCoreGroups(coreGroups []topology.CoreGroup) []topology.CoreGroup {
 	return list
 }
 
+// pickFromGroups takes cores adding up to need CPUs from groups, tightest
+// domain first: a single L3 group if one is large enough, then a single die,
+// and otherwise the largest groups so the fewest CCDs are touched.
+func pickFromGroups(req *Request, groups []topology.CoreGroup, need int) ([]int, bool) {
+	if need <= 0 {
+		return nil, true
+	}
+	for _, cg := range groups {
+		if picked, ok := takeCores(cg.PhysicalCPUs, req, need); ok {
+			return picked, true
+		}
+	}
+	for _, die := range groupsByDie(groups) {
+		if req.GroupCPUs(die...) >= need {
+			return fillGroups(req, die, need)
+		}
+	}
+	return fillGroups(req, groups, need)
+}
+
+// fillGroups takes cores adding up to need CPUs from groups, largest group
+// first. It reports false when the groups hold fewer.
+func fillGroups(req *Request, groups []topology.CoreGroup, need int) ([]int, bool) {
+	bySize := make([]topology.CoreGroup, len(groups))
+	copy(bySize, groups)
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return req.GroupCPUs(bySize[i]) > req.GroupCPUs(bySize[j])
+	})
+
+	var cores []int
+	for _, cg := range bySize {
+		cores = append(cores, cg.PhysicalCPUs...)
+	}
+	return takeCores(cores, req, need)
+}
+
+// groupsByDie splits groups by package and die, keeping their order.
+func groupsByDie(groups []topology.CoreGroup) [][]topology.CoreGroup {
+	type key struct{ pkg, die int }
+	var order []key
+	byDie := make(map[key][]topology.CoreGroup)
+	for _, cg := range groups {
+		k := key{cg.PackageID, cg.DieID}
+		if _, ok := byDie[k]; !ok {
+			order = append(order, k)
+		}
+		byDie[k] = append(byDie[k], cg)
+	}
+
+	dies := make([][]topology.CoreGroup, 0, len(order))
+	for _, k := range order {
+		dies = append(dies, byDie[k])
+	}
+	return dies
+}
+
+// nonEmptyGroups drops groups left without CPUs by pool filtering.
+func nonEmptyGroups(coreGroups []topology.CoreGroup) []topology.CoreGroup {
+	list := make([]topology.CoreGroup, 0, len(coreGroups))
+	for _, cg := range coreGroups {
+		if len(cg.PhysicalCPUs) > 0 {
+			list = append(list, cg)
+		}
+	}
+	return list
+}
+
 func countCCDsUsedByPhysical(physicalCores []int, topo *topology.CPUTopology) int {
 	physicalSet := make(map[int]struct{})
 	for _, p := range physicalCores {
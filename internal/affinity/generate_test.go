@@ -0,0 +1,259 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"epyc-pve/internal/topology"
+)
+
+// testTopology builds an AMD topology with one CCD per entry of groups.
+// groups[g][c] lists the hardware threads of core c in CCD g and nodes[g]
+// is the NUMA node that CCD belongs to. Later CCDs get more L3 and a lower
+// max frequency, so the cache and frequency strategies prefer opposite ends.
+func testTopology(nodes []int, groups ...[][]int) *topology.CPUTopology {
+	topo := &topology.CPUTopology{
+		Architecture:   topology.ArchAMD,
+		ThreadSiblings: make(map[int][]int),
+	}
+	nodeCPUs := make(map[int][]int)
+	for g, cores := range groups {
+		cg := topology.CoreGroup{
+			ID:         g,
+			Type:       topology.CoreTypeUnknown,
+			Name:       fmt.Sprintf("CCD %d", g),
+			L3CacheID:  g,
+			L3SizeKB:   32768 * (g + 1),
+			MaxFreqKHz: 4000000 - 100000*g,
+			DieID:      g,
+			NUMANodeID: nodes[g],
+		}
+		for _, threads := range cores {
+			cg.PhysicalCPUs = append(cg.PhysicalCPUs, threads[0])
+			cg.AllCPUs = append(cg.AllCPUs, threads...)
+			topo.ThreadSiblings[threads[0]] = threads
+			if len(threads) > 1 {
+				topo.HasSMT = true
+			}
+			topo.TotalCores++
+			topo.TotalCPUs += len(threads)
+		}
+		sort.Ints(cg.AllCPUs)
+		nodeCPUs[nodes[g]] = append(nodeCPUs[nodes[g]], cg.AllCPUs...)
+		topo.CoreGroups = append(topo.CoreGroups, cg)
+	}
+
+	var ids []int
+	for id := range nodeCPUs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		cpus := nodeCPUs[id]
+		sort.Ints(cpus)
+		distances := make([]int, len(ids))
+		for i := range distances {
+			distances[i] = 20
+		}
+		distances[id] = 10
+		topo.NUMANodes = append(topo.NUMANodes, topology.NUMANode{ID: id, CPUs: cpus, Distances: distances})
+	}
+	return topo
+}
+
+// smtCores returns n cores starting at first with width threads each,
+// numbered the way Linux interleaves them: core i has threads i, i+stride,
+// i+2*stride and so on.
+func smtCores(first, n, width, stride int) [][]int {
+	cores := make([][]int, n)
+	for i := range cores {
+		for t := 0; t < width; t++ {
+			cores[i] = append(cores[i], first+i+t*stride)
+		}
+	}
+	return cores
+}
+
+func TestGenerateCountsThreads(t *testing.T) {
+	// 2 CCDs of 4 SMT2 cores: CPUs 0-7 are the first threads, 8-15 the
+	// siblings.
+	interleaved := testTopology([]int{0, 1}, smtCores(0, 4, 2, 8), smtCores(4, 4, 2, 8))
+
+	// The same host with the siblings of CCD 0 offline.
+	offline := testTopology([]int{0, 1},
+		[][]int{{0}, {1}, {2}, {3}},
+		[][]int{{4, 12}, {5, 13}, {6, 14}, {7, 15}})
+
+	// 2 CCDs of 2 SMT4 cores.
+	smt4 := testTopology([]int{0, 1}, smtCores(0, 2, 4, 4), smtCores(2, 2, 4, 4))
+
+	tests := []struct {
+		name     string
+		topo     *topology.CPUTopology
+		cores    int
+		reserved Reservation
+		// unavailable lists strategies that cannot fit the request.
+		unavailable []Strategy
+	}{
+		{
+			name:  "interleaved",
+			topo:  interleaved,
+			cores: 8,
+		},
+		{
+			name:     "reserved siblings",
+			topo:     interleaved,
+			cores:    8,
+			reserved: Reservation{CPUs: []int{8, 9, 10, 11}},
+		},
+		{
+			name:  "offline siblings",
+			topo:  offline,
+			cores: 8,
+		},
+		{
+			name:        "offline siblings, no single CCD fits",
+			topo:        offline,
+			cores:       10,
+			unavailable: []Strategy{StrategySingleCCD},
+		},
+		{
+			name:  "smt4",
+			topo:  smt4,
+			cores: 8,
+		},
+		{
+			name:        "smt4 with reserved threads",
+			topo:        smt4,
+			cores:       12,
+			reserved:    Reservation{CPUs: []int{4, 8}},
+			unavailable: []Strategy{StrategySingleCCD},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := Generate(&Request{
+				CoresNeeded: tt.cores,
+				IncludeSMT:  true,
+				Pool:        PoolAll,
+				Reserved:    tt.reserved,
+				Topology:    tt.topo,
+			})
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			reserved := make(map[int]bool)
+			for _, cpu := range tt.reserved.CPUs {
+				reserved[cpu] = true
+			}
+			for _, opt := range options {
+				if opt.Strategy == StrategyManual || opt.Strategy == StrategyRandom {
+					continue
+				}
+				if containsStrategy(tt.unavailable, opt.Strategy) {
+					if len(opt.CPUs) != 0 {
+						t.Errorf("%s: got %s, want unavailable", opt.Strategy, FormatCPUs(opt.CPUs))
+					}
+					continue
+				}
+				if len(opt.CPUs) < tt.cores {
+					t.Errorf("%s: got %d CPUs (%s), want at least %d: %s",
+						opt.Strategy, len(opt.CPUs), FormatCPUs(opt.CPUs), tt.cores, opt.Description)
+				}
+				for _, cpu := range opt.CPUs {
+					if reserved[cpu] {
+						t.Errorf("%s: uses reserved CPU %d", opt.Strategy, cpu)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateManualCountsThreads(t *testing.T) {
+	topo := testTopology([]int{0, 0}, smtCores(0, 4, 2, 8), smtCores(4, 4, 2, 8))
+	req := &Request{
+		CoresNeeded: 8,
+		IncludeSMT:  true,
+		Pool:        PoolAll,
+		Reserved:    Reservation{CPUs: []int{8, 9, 10, 11}},
+		Topology:    topo,
+	}
+
+	if _, err := GenerateManual(req, []int{0}); err == nil {
+		t.Error("CCD 0 holds 4 usable CPUs, want an error for 8")
+	}
+	opt, err := GenerateManual(req, []int{1})
+	if err != nil {
+		t.Fatalf("GenerateManual: %v", err)
+	}
+	if got, want := FormatCPUs(opt.CPUs), "4-7,12-15"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func containsStrategy(list []Strategy, s Strategy) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGenerateRandomCountsThreads(t *testing.T) {
+	// CCD 0 keeps one thread per core once 8-11 are reserved, so only
+	// CCD 1 can hold 8 CPUs on its own.
+	topo := testTopology([]int{0, 1}, smtCores(0, 4, 2, 8), smtCores(4, 4, 2, 8))
+	req := &Request{
+		CoresNeeded: 8,
+		IncludeSMT:  true,
+		Pool:        PoolAll,
+		Reserved:    Reservation{CPUs: []int{8, 9, 10, 11}},
+		Topology:    topo,
+	}
+
+	for i := 0; i < 50; i++ {
+		options, err := Generate(req)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		for _, opt := range options {
+			if opt.Strategy != StrategyRandom {
+				continue
+			}
+			if got, want := FormatCPUs(opt.CPUs), "4-7,12-15"; got != want {
+				t.Fatalf("run %d: got %s, want %s: %s", i, got, want, opt.Description)
+			}
+		}
+	}
+}
+
+func TestMinCCDsNeeded(t *testing.T) {
+	topo := testTopology([]int{0, 0, 0},
+		[][]int{{0}, {1}, {2}, {3}},
+		[][]int{{4, 12}, {5, 13}, {6, 14}, {7, 15}},
+		smtCores(16, 2, 4, 2))
+
+	tests := []struct {
+		cpus       int
+		includeSMT bool
+		want       int
+	}{
+		{cpus: 8, includeSMT: true, want: 1},
+		{cpus: 9, includeSMT: true, want: 2},
+		{cpus: 16, includeSMT: true, want: 2},
+		{cpus: 17, includeSMT: true, want: 3},
+		{cpus: 4, includeSMT: false, want: 1},
+		{cpus: 5, includeSMT: false, want: 2},
+	}
+	for _, tt := range tests {
+		req := &Request{CoresNeeded: tt.cpus, IncludeSMT: tt.includeSMT, Topology: topo}
+		if got := req.MinCCDsNeeded(); got != tt.want {
+			t.Errorf("MinCCDsNeeded(%d, smt=%v) = %d, want %d", tt.cpus, tt.includeSMT, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package affinity
+
+import (
+	"fmt"
+
+	"epyc-pve/internal/topology"
+)
+
+// Locality summarises where a CPU set sits in a topology: how many cores,
+// core groups (CCDs), NUMA nodes and packages it touches, and whether each
+// core is used with all of its threads.
+type Locality struct {
+	CPUs      int
+	Cores     int
+	Groups    int
+	NUMANodes int
+	Packages  int
+	// FullCores is true when every core in the set contributes all of its
+	// online threads, i.e. SMT siblings are pinned together.
+	FullCores bool
+}
+
+// DescribeLocality returns the locality of the CPUs of cpus that are online
+// in topo; the others are ignored.
+func DescribeLocality(topo *topology.CPUTopology, cpus []int) Locality {
+	online := make(map[int]bool)
+	for _, cpu := range topo.OnlineCPUs() {
+		online[cpu] = true
+	}
+	inSet := make(map[int]bool)
+	for _, cpu := range cpus {
+		if online[cpu] {
+			inSet[cpu] = true
+		}
+	}
+
+	loc := Locality{CPUs: len(inSet), FullCores: len(inSet) > 0}
+	cores := make(map[int]bool)
+	groups := make(map[int]bool)
+	nodes := make(map[int]bool)
+	packages := make(map[int]bool)
+	for cpu := range inSet {
+		threads := topo.Threads(cpu)
+		cores[threads[0]] = true
+		for _, t := range threads {
+			if online[t] && !inSet[t] {
+				loc.FullCores = false
+			}
+		}
+		for i, cg := range topo.CoreGroups {
+			if containsCPU(cg.AllCPUs, cpu) {
+				groups[i] = true
+				packages[cg.PackageID] = true
+			}
+		}
+		nodes[topo.NUMANodeOf(cpu)] = true
+	}
+	loc.Cores = len(cores)
+	loc.Groups = len(groups)
+	loc.NUMANodes = len(nodes)
+	loc.Packages = len(packages)
+	return loc
+}
+
+func (l Locality) String() string {
+	smt := "split SMT siblings"
+	if l.FullCores {
+		smt = "whole cores"
+	}
+	return fmt.Sprintf("%d CPUs on %d cores, %d core groups, %d NUMA nodes, %d packages, %s",
+		l.CPUs, l.Cores, l.Groups, l.NUMANodes, l.Packages, smt)
+}
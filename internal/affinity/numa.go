@@ -0,0 +1,211 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"epyc-pve/internal/topology"
+)
+
+// Value renders the node in the format Proxmox expects for numaN.
+func (n GuestNUMANode) Value() string {
+	hostNodes := make([]string, len(n.HostNodes))
+	for i, id := range n.HostNodes {
+		hostNodes[i] = strconv.Itoa(id)
+	}
+	parts := []string{
+		"cpus=" + n.CPUs,
+		"hostnodes=" + strings.Join(hostNodes, ";"),
+	}
+	if n.MemoryMB > 0 {
+		parts = append(parts, "memory="+strconv.Itoa(n.MemoryMB))
+	}
+	parts = append(parts, "policy=bind")
+	return strings.Join(parts, ",")
+}
+
+// NUMAConfig returns the VM config lines that bind guest memory to the host
+// nodes this option runs on, or nil if the option carries no NUMA binding.
+func (o *Option) NUMAConfig() []string {
+	if len(o.GuestNUMA) == 0 {
+		return nil
+	}
+	lines := []string{"numa: 1"}
+	for _, node := range o.GuestNUMA {
+		lines = append(lines, fmt.Sprintf("numa%d: %s", node.ID, node.Value()))
+	}
+	return lines
+}
+
+func generateNUMALocal(req *Request, physicalCoresNeeded int) *Option {
+	option := &Option{
+		Strategy:    StrategyNUMALocal,
+		Name:        "NUMA Local",
+		Description: "All cores from one NUMA node, memory bound to it",
+	}
+
+	topo := req.Topology
+	if len(topo.NUMANodes) == 0 {
+		option.Description = "Unavailable: NUMA topology not detected"
+		return option
+	}
+
+	groupsByNode := make(map[int][]topology.CoreGroup)
+	cpusByNode := make(map[int]int)
+	for _, cg := range sortedCoreGroups(topo.CoreGroups) {
+		if cg.NUMANodeID < 0 {
+			continue
+		}
+		groupsByNode[cg.NUMANodeID] = append(groupsByNode[cg.NUMANodeID], cg)
+		cpusByNode[cg.NUMANodeID] += req.GroupCPUs(cg)
+	}
+
+	nodes := selectNUMANodes(req.nodesByOccupancy(topo.NUMANodes, groupsByNode), cpusByNode, req.CoresNeeded, req.MemoryMB)
+	if len(nodes) == 0 {
+		option.Description = fmt.Sprintf("Unavailable: NUMA nodes only have %d CPUs, need %d",
+			totalCPUs(cpusByNode), req.CoresNeeded)
+		return option
+	}
+	if len(nodes) > 1 {
+		option.Description = fmt.Sprintf("Fewest NUMA nodes (%d), memory bound to each", len(nodes))
+	}
+
+	selectedPhysical := make([]int, 0, physicalCoresNeeded)
+	for _, nodeID := range nodes {
+		remaining := req.CoresNeeded - req.countCPUs(selectedPhysical)
+		picked, _ := pickFromGroups(req, req.byOccupancy(groupsByNode[nodeID]), remaining)
+		selectedPhysical = append(selectedPhysical, picked...)
+	}
+
+	option.CPUs = expandToVCPUs(selectedPhysical, req.IncludeSMT, topo)
+	option.CCDsUsed = countCCDsUsedByPhysical(selectedPhysical, topo)
+	option.GuestNUMA = buildGuestNUMA(option.CPUs, nodes, req.MemoryMB, topo)
+	return option
+}
+
+// selectNUMANodes returns the IDs of the fewest nodes that together hold
+// cpusNeeded CPUs. A single node with enough free memory wins over one
+// without; otherwise nodes are added nearest-first from the largest.
+func selectNUMANodes(nodes []topology.NUMANode, cpusByNode map[int]int, cpusNeeded int, memoryMB int) []int {
+	var fitting []topology.NUMANode
+	for _, node := range nodes {
+		if cpusByNode[node.ID] >= cpusNeeded {
+			fitting = append(fitting, node)
+		}
+	}
+	if len(fitting) > 0 {
+		memoryKB := int64(memoryMB) * 1024
+		for _, node := range fitting {
+			if memoryKB == 0 || node.MemFreeKB == 0 || node.MemFreeKB >= memoryKB {
+				return []int{node.ID}
+			}
+		}
+		return []int{fitting[0].ID}
+	}
+
+	if totalCPUs(cpusByNode) < cpusNeeded {
+		return nil
+	}
+
+	bySize := make([]topology.NUMANode, len(nodes))
+	copy(bySize, nodes)
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return cpusByNode[bySize[i].ID] > cpusByNode[bySize[j].ID]
+	})
+
+	seed := bySize[0]
+	rest := bySize[1:]
+	sort.SliceStable(rest, func(i, j int) bool {
+		di, dj := numaDistance(seed, rest[i].ID), numaDistance(seed, rest[j].ID)
+		if di != dj {
+			return di < dj
+		}
+		return cpusByNode[rest[i].ID] > cpusByNode[rest[j].ID]
+	})
+
+	selected := []int{seed.ID}
+	cpus := cpusByNode[seed.ID]
+	for _, node := range rest {
+		if cpus >= cpusNeeded {
+			break
+		}
+		if cpusByNode[node.ID] == 0 {
+			continue
+		}
+		selected = append(selected, node.ID)
+		cpus += cpusByNode[node.ID]
+	}
+	return selected
+}
+
+func numaDistance(from topology.NUMANode, to int) int {
+	if to >= 0 && to < len(from.Distances) {
+		return from.Distances[to]
+	}
+	return 0
+}
+
+func totalCPUs(cpusByNode map[int]int) int {
+	total := 0
+	for _, count := range cpusByNode {
+		total += count
+	}
+	return total
+}
+
+// buildGuestNUMA creates one guest node per host node used, numbering guest
+// vCPUs consecutively and splitting memory by vCPU share.
+func buildGuestNUMA(cpus []int, nodes []int, memoryMB int, topo *topology.CPUTopology) []GuestNUMANode {
+	countByNode := make(map[int]int)
+	for _, cpu := range cpus {
+		countByNode[topo.NUMANodeOf(cpu)]++
+	}
+
+	guest := make([]GuestNUMANode, 0, len(nodes))
+	nextVCPU := 0
+	for _, nodeID := range nodes {
+		count := countByNode[nodeID]
+		if count == 0 {
+			continue
+		}
+		guest = append(guest, GuestNUMANode{
+			ID:        len(guest),
+			CPUs:      formatRange(nextVCPU, nextVCPU+count-1),
+			HostNodes: []int{nodeID},
+		})
+		nextVCPU += count
+	}
+
+	return SplitGuestMemory(guest, memoryMB)
+}
+
+// SplitGuestMemory returns a copy of nodes with memoryMB split across them
+// by vCPU share. The parts add up to memoryMB, as Proxmox requires.
+func SplitGuestMemory(nodes []GuestNUMANode, memoryMB int) []GuestNUMANode {
+	split := append([]GuestNUMANode(nil), nodes...)
+	if memoryMB <= 0 || len(split) == 0 {
+		return split
+	}
+	counts := make([]int, len(split))
+	total := 0
+	for i, node := range split {
+		vcpus, err := topology.ParseList(node.CPUs)
+		if err != nil {
+			continue
+		}
+		counts[i] = len(vcpus)
+		total += len(vcpus)
+	}
+	if total == 0 {
+		return split
+	}
+	memoryLeft := memoryMB
+	for i := range split {
+		split[i].MemoryMB = memoryMB * counts[i] / total
+		memoryLeft -= split[i].MemoryMB
+	}
+	split[len(split)-1].MemoryMB += memoryLeft
+	return split
+}
@@ -0,0 +1,159 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+
+	"epyc-pve/internal/topology"
+)
+
+// Occupancy maps host CPUs to the VMs whose affinity already includes them.
+type Occupancy map[int][]int
+
+// NewOccupancy builds an occupancy map from VM affinity strings keyed by
+// VMID. The VM being planned is passed as exclude so its current pinning
+// does not count against it; use 0 to keep every VM.
+func NewOccupancy(affinities map[int]string, exclude int) (Occupancy, error) {
+	occupancy := make(Occupancy)
+	vmids := make([]int, 0, len(affinities))
+	for vmid := range affinities {
+		vmids = append(vmids, vmid)
+	}
+	sort.Ints(vmids)
+
+	for _, vmid := range vmids {
+		if vmid == exclude {
+			continue
+		}
+		cpus, err := topology.ParseList(affinities[vmid])
+		if err != nil {
+			return nil, fmt.Errorf("VM %d: invalid affinity %q: %w", vmid, affinities[vmid], err)
+		}
+		for _, cpu := range cpus {
+			occupancy[cpu] = append(occupancy[cpu], vmid)
+		}
+	}
+	return occupancy, nil
+}
+
+// Busy reports whether any VM is pinned to cpu.
+func (o Occupancy) Busy(cpu int) bool {
+	return len(o[cpu]) > 0
+}
+
+// Overlap returns how many of cpus are already pinned by other VMs, and
+// which VMs those are.
+func (o Occupancy) Overlap(cpus []int) (int, []int) {
+	count := 0
+	seen := make(map[int]bool)
+	var vmids []int
+	for _, cpu := range cpus {
+		if !o.Busy(cpu) {
+			continue
+		}
+		count++
+		for _, vmid := range o[cpu] {
+			if !seen[vmid] {
+				seen[vmid] = true
+				vmids = append(vmids, vmid)
+			}
+		}
+	}
+	sort.Ints(vmids)
+	return count, vmids
+}
+
+// coreBusy reports whether any thread of the physical core is pinned.
+func (o Occupancy) coreBusy(topo *topology.CPUTopology, core int) bool {
+	for _, cpu := range topo.Threads(core) {
+		if o.Busy(cpu) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Occupancy) busyCores(topo *topology.CPUTopology, cg topology.CoreGroup) int {
+	count := 0
+	for _, core := range cg.PhysicalCPUs {
+		if o.coreBusy(topo, core) {
+			count++
+		}
+	}
+	return count
+}
+
+// preferFree returns a copy of the request whose core groups list free
+// cores before pinned ones, so every strategy that takes leading cores from
+// a group takes free ones first.
+func (r *Request) preferFree() *Request {
+	if len(r.Occupancy) == 0 {
+		return r
+	}
+
+	topo := *r.Topology
+	topo.CoreGroups = make([]topology.CoreGroup, len(r.Topology.CoreGroups))
+	for i, cg := range r.Topology.CoreGroups {
+		cores := append([]int(nil), cg.PhysicalCPUs...)
+		sort.SliceStable(cores, func(a, b int) bool {
+			return !r.Occupancy.coreBusy(r.Topology, cores[a]) && r.Occupancy.coreBusy(r.Topology, cores[b])
+		})
+		cg.PhysicalCPUs = cores
+		topo.CoreGroups[i] = cg
+	}
+
+	free := *r
+	free.Topology = &topo
+	return &free
+}
+
+// byOccupancy orders groups by how many of their cores are already pinned,
+// keeping the original order between equally busy groups.
+func (r *Request) byOccupancy(groups []topology.CoreGroup) []topology.CoreGroup {
+	if len(r.Occupancy) == 0 {
+		return groups
+	}
+	type ranked struct {
+		group topology.CoreGroup
+		busy  int
+	}
+	list := make([]ranked, len(groups))
+	for i, cg := range groups {
+		list[i] = ranked{group: cg, busy: r.Occupancy.busyCores(r.Topology, cg)}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].busy < list[j].busy
+	})
+
+	sorted := make([]topology.CoreGroup, len(list))
+	for i, item := range list {
+		sorted[i] = item.group
+	}
+	return sorted
+}
+
+// nodesByOccupancy orders NUMA nodes like byOccupancy orders groups, so a
+// free node that fits is chosen before a busy one.
+func (r *Request) nodesByOccupancy(nodes []topology.NUMANode, groupsByNode map[int][]topology.CoreGroup) []topology.NUMANode {
+	if len(r.Occupancy) == 0 {
+		return nodes
+	}
+	busy := make(map[int]int, len(nodes))
+	for _, node := range nodes {
+		for _, cg := range groupsByNode[node.ID] {
+			busy[node.ID] += r.Occupancy.busyCores(r.Topology, cg)
+		}
+	}
+	list := make([]topology.NUMANode, len(nodes))
+	copy(list, nodes)
+	sort.SliceStable(list, func(i, j int) bool {
+		return busy[list[i].ID] < busy[list[j].ID]
+	})
+	return list
+}
+
+// setOverlap counts every CPU the QEMU process gets, emulator CPUs
+// included, against the other VMs' pinning.
+func (o *Option) setOverlap(occupancy Occupancy) {
+	o.Overlap, o.OverlapVMs = occupancy.Overlap(o.ProcessCPUs())
+}
@@ -0,0 +1,75 @@
+package affinity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"epyc-pve/internal/topology"
+)
+
+// Reservation is a set of host CPUs kept for Proxmox itself (IRQs,
+// pvestatd, ZFS, Ceph OSDs). No strategy hands them out.
+type Reservation struct {
+	CPUs []int
+	// CoresPerNode reserves the first N cores of every NUMA node with all
+	// their threads.
+	CoresPerNode int
+}
+
+// IsZero reports whether nothing is reserved.
+func (r Reservation) IsZero() bool {
+	return len(r.CPUs) == 0 && r.CoresPerNode <= 0
+}
+
+// Resolve returns the online CPUs of topo the reservation covers, sorted.
+func (r Reservation) Resolve(topo *topology.CPUTopology) []int {
+	reserved := make(map[int]bool)
+	online := make(map[int]bool)
+	for _, cpu := range topo.OnlineCPUs() {
+		online[cpu] = true
+	}
+	for _, cpu := range r.CPUs {
+		if online[cpu] {
+			reserved[cpu] = true
+		}
+	}
+	if r.CoresPerNode > 0 {
+		for _, nodeID := range groupNodeIDs(topo) {
+			var cores []int
+			for _, gi := range groupsInNode(topo, nodeID) {
+				cores = append(cores, topo.CoreGroups[gi].PhysicalCPUs...)
+			}
+			sort.Ints(cores)
+			for _, core := range cores[:min(r.CoresPerNode, len(cores))] {
+				for _, cpu := range topo.Threads(core) {
+					reserved[cpu] = true
+				}
+			}
+		}
+	}
+
+	cpus := make([]int, 0, len(reserved))
+	for cpu := range reserved {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+func (r Reservation) String() string {
+	var parts []string
+	if len(r.CPUs) > 0 {
+		parts = append(parts, "CPUs "+FormatCPUs(r.CPUs))
+	}
+	switch {
+	case r.CoresPerNode == 1:
+		parts = append(parts, "first core of each NUMA node")
+	case r.CoresPerNode > 1:
+		parts = append(parts, fmt.Sprintf("first %d cores of each NUMA node", r.CoresPerNode))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " and ")
+}
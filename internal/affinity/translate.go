@@ -0,0 +1,407 @@
+package affinity
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"epyc-pve/internal/topology"
+)
+
+var ErrTranslate = errors.New("cannot translate affinity")
+
+// Translation is an affinity carried over to another topology.
+type Translation struct {
+	CPUs        []int
+	AffinityStr string
+	// Relaxed names the parts of the shape the destination could not
+	// keep: "SMT pairing", "NUMA spread" or "core groups".
+	Relaxed []string
+}
+
+// Translate returns the CPUs on dst with the same shape as affinityStr has
+// on src: as many NUMA nodes, as many core groups (CCDs) per node, as many
+// cores per group and as many threads per core. Positions are kept where
+// dst allows, so CCD 2 of node 1 stays CCD 2 of node 1. When dst cannot hold
+// the shape, SMT pairing, then NUMA spread, then the group layout are given
+// up in turn, as reported in Relaxed.
+func Translate(affinityStr string, src, dst *topology.CPUTopology) (*Translation, error) {
+	return TranslateAvoiding(affinityStr, src, dst, nil)
+}
+
+// TranslateAvoiding is Translate preferring groups and cores of dst that
+// no VM in occupancy is pinned to.
+func TranslateAvoiding(affinityStr string, src, dst *topology.CPUTopology, occupancy Occupancy) (*Translation, error) {
+	cpus, err := topology.ParseList(affinityStr)
+	if err != nil || len(cpus) == 0 {
+		return nil, fmt.Errorf("%w: invalid affinity %q", ErrTranslate, affinityStr)
+	}
+	shape, err := shapeOf(src, cpus)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Translation{}
+	if width := dst.ThreadsPerCore(); shape.maxThreads() > width {
+		shape = shape.splitCores(width)
+		t.Relaxed = append(t.Relaxed, "SMT pairing")
+	}
+
+	picked, ok := newPlacer(dst, occupancy).placeNodes(shape.nodes)
+	if !ok {
+		p := newPlacer(dst, occupancy)
+		picked, ok = p.placeGroups(shape.groups(), p.allGroups())
+		t.Relaxed = append(t.Relaxed, "NUMA spread")
+	}
+	if !ok {
+		p := newPlacer(dst, occupancy)
+		picked, ok = p.placeCores(shape.cores(), p.allCores())
+		t.Relaxed = append(t.Relaxed, "core groups")
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %d CPUs do not fit the destination", ErrTranslate, len(cpus))
+	}
+
+	for _, pick := range picked {
+		t.CPUs = append(t.CPUs, dst.Threads(pick.core)[:pick.threads]...)
+	}
+	sort.Ints(t.CPUs)
+	t.AffinityStr = FormatCPUs(t.CPUs)
+	return t, nil
+}
+
+// Shape of a CPU set: NUMA nodes holding core groups holding cores, each
+// with its position so it can be mirrored on another host.
+type (
+	shapeCore struct {
+		offset  int // index within the core group
+		threads int
+	}
+	shapeGroup struct {
+		pos   int // index within the NUMA node
+		cores []shapeCore
+	}
+	shapeNode struct {
+		pos    int // index among the host's NUMA nodes
+		groups []shapeGroup
+	}
+	cpuShape struct {
+		nodes []shapeNode
+	}
+)
+
+func shapeOf(topo *topology.CPUTopology, cpus []int) (*cpuShape, error) {
+	nodeIDs := groupNodeIDs(topo)
+	inSet := make(map[int]bool)
+	for _, cpu := range cpus {
+		inSet[cpu] = true
+	}
+
+	shape := &cpuShape{}
+	found := 0
+	for pos, nodeID := range nodeIDs {
+		node := shapeNode{pos: pos}
+		for gpos, gi := range groupsInNode(topo, nodeID) {
+			cg := topo.CoreGroups[gi]
+			group := shapeGroup{pos: gpos}
+			for offset, core := range cg.PhysicalCPUs {
+				threads := 0
+				for _, t := range topo.Threads(core) {
+					if inSet[t] {
+						threads++
+					}
+				}
+				if threads > 0 {
+					group.cores = append(group.cores, shapeCore{offset: offset, threads: threads})
+					found += threads
+				}
+			}
+			if len(group.cores) > 0 {
+				node.groups = append(node.groups, group)
+			}
+		}
+		if len(node.groups) > 0 {
+			shape.nodes = append(shape.nodes, node)
+		}
+	}
+	if found != len(inSet) {
+		return nil, fmt.Errorf("%w: %d of its CPUs are not online on the source", ErrTranslate, len(inSet)-found)
+	}
+	return shape, nil
+}
+
+func (s *cpuShape) maxThreads() int {
+	width := 0
+	for _, c := range s.cores() {
+		if c.threads > width {
+			width = c.threads
+		}
+	}
+	return width
+}
+
+// splitCores turns cores using more than width threads into several cores
+// of at most width threads, for a destination with narrower SMT.
+func (s *cpuShape) splitCores(width int) *cpuShape {
+	split := &cpuShape{}
+	for _, node := range s.nodes {
+		n := shapeNode{pos: node.pos}
+		for _, group := range node.groups {
+			g := shapeGroup{pos: group.pos}
+			offset := 0
+			for _, c := range group.cores {
+				for left := c.threads; left > 0; left -= width {
+					g.cores = append(g.cores, shapeCore{offset: offset, threads: min(left, width)})
+					offset++
+				}
+			}
+			n.groups = append(n.groups, g)
+		}
+		split.nodes = append(split.nodes, n)
+	}
+	return split
+}
+
+func (s *cpuShape) groups() []shapeGroup {
+	var groups []shapeGroup
+	for _, node := range s.nodes {
+		groups = append(groups, node.groups...)
+	}
+	return groups
+}
+
+func (s *cpuShape) cores() []shapeCore {
+	var cores []shapeCore
+	for _, group := range s.groups() {
+		cores = append(cores, group.cores...)
+	}
+	return cores
+}
+
+// placer assigns a shape to cores of topo, never using a group or core
+// twice.
+type placer struct {
+	topo       *topology.CPUTopology
+	occupancy  Occupancy
+	usedGroups map[int]bool
+}
+
+func newPlacer(topo *topology.CPUTopology, occupancy Occupancy) *placer {
+	return &placer{topo: topo, occupancy: occupancy, usedGroups: make(map[int]bool)}
+}
+
+type pickedCore struct {
+	core    int
+	threads int
+}
+
+// placeNodes puts every node of the shape on its own NUMA node of topo,
+// trying the node at the same position first and then the least busy.
+func (p *placer) placeNodes(nodes []shapeNode) ([]pickedCore, bool) {
+	nodeIDs := groupNodeIDs(p.topo)
+	usedNodes := make(map[int]bool)
+
+	order := append([]shapeNode(nil), nodes...)
+	sort.SliceStable(order, func(i, j int) bool { return len(order[i].groups) > len(order[j].groups) })
+
+	var picked []pickedCore
+	for _, node := range order {
+		candidates := make([]int, 0, len(nodeIDs))
+		for pos := range nodeIDs {
+			if !usedNodes[pos] {
+				candidates = append(candidates, pos)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if (candidates[i] == node.pos) != (candidates[j] == node.pos) {
+				return candidates[i] == node.pos
+			}
+			return p.groupsBusy(groupsInNode(p.topo, nodeIDs[candidates[i]])) <
+				p.groupsBusy(groupsInNode(p.topo, nodeIDs[candidates[j]]))
+		})
+
+		placed := false
+		for _, pos := range candidates {
+			saved := p.copyUsed()
+			cores, ok := p.placeGroups(node.groups, groupsInNode(p.topo, nodeIDs[pos]))
+			if ok {
+				usedNodes[pos] = true
+				picked = append(picked, cores...)
+				placed = true
+				break
+			}
+			p.usedGroups = saved
+		}
+		if !placed {
+			return nil, false
+		}
+	}
+	return picked, true
+}
+
+// placeGroups puts every group of the shape on its own group among
+// candidates, preferring the same position within the node and then the
+// least busy group.
+func (p *placer) placeGroups(groups []shapeGroup, candidates []int) ([]pickedCore, bool) {
+	order := append([]shapeGroup(nil), groups...)
+	sort.SliceStable(order, func(i, j int) bool { return len(order[i].cores) > len(order[j].cores) })
+
+	var picked []pickedCore
+	for _, group := range order {
+		ranked := make([]int, 0, len(candidates))
+		for _, gi := range candidates {
+			if !p.usedGroups[gi] {
+				ranked = append(ranked, gi)
+			}
+		}
+		position := func(gi int) int {
+			for pos, c := range candidates {
+				if c == gi {
+					return pos
+				}
+			}
+			return -1
+		}
+		sort.SliceStable(ranked, func(i, j int) bool {
+			si, sj := position(ranked[i]) == group.pos, position(ranked[j]) == group.pos
+			if si != sj {
+				return si
+			}
+			return p.groupsBusy([]int{ranked[i]}) < p.groupsBusy([]int{ranked[j]})
+		})
+
+		placed := false
+		for _, gi := range ranked {
+			cores, ok := p.placeCores(group.cores, p.topo.CoreGroups[gi].PhysicalCPUs)
+			if ok {
+				p.usedGroups[gi] = true
+				picked = append(picked, cores...)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return nil, false
+		}
+	}
+	return picked, true
+}
+
+// placeCores picks a core with enough threads from available for every core
+// of the shape. The same offsets are used when they are all free and wide
+// enough; otherwise free cores come first.
+func (p *placer) placeCores(cores []shapeCore, available []int) ([]pickedCore, bool) {
+	used := make(map[int]bool)
+	same := make([]pickedCore, 0, len(cores))
+	for _, c := range cores {
+		if c.offset >= len(available) {
+			break
+		}
+		core := available[c.offset]
+		if used[core] || p.coreBusy(core) || len(p.topo.Threads(core)) < c.threads {
+			break
+		}
+		used[core] = true
+		same = append(same, pickedCore{core: core, threads: c.threads})
+	}
+	if len(same) == len(cores) {
+		return same, true
+	}
+	used = make(map[int]bool)
+
+	ranked := append([]int(nil), available...)
+	sort.SliceStable(ranked, func(i, j int) bool { return !p.coreBusy(ranked[i]) && p.coreBusy(ranked[j]) })
+	order := append([]shapeCore(nil), cores...)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].threads > order[j].threads })
+
+	picked := make([]pickedCore, 0, len(cores))
+	for _, c := range order {
+		found := false
+		for _, core := range ranked {
+			if used[core] || len(p.topo.Threads(core)) < c.threads {
+				continue
+			}
+			used[core] = true
+			picked = append(picked, pickedCore{core: core, threads: c.threads})
+			found = true
+			break
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return picked, true
+}
+
+func (p *placer) allGroups() []int {
+	var groups []int
+	for _, nodeID := range groupNodeIDs(p.topo) {
+		groups = append(groups, groupsInNode(p.topo, nodeID)...)
+	}
+	return groups
+}
+
+func (p *placer) allCores() []int {
+	var cores []int
+	for _, gi := range p.allGroups() {
+		cores = append(cores, p.topo.CoreGroups[gi].PhysicalCPUs...)
+	}
+	return cores
+}
+
+func (p *placer) coreBusy(core int) bool {
+	for _, t := range p.topo.Threads(core) {
+		if p.occupancy.Busy(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsBusy counts the busy CPUs of the given core groups.
+func (p *placer) groupsBusy(groups []int) int {
+	busy := 0
+	for _, gi := range groups {
+		for _, cpu := range p.topo.CoreGroups[gi].AllCPUs {
+			if p.occupancy.Busy(cpu) {
+				busy++
+			}
+		}
+	}
+	return busy
+}
+
+func (p *placer) copyUsed() map[int]bool {
+	saved := make(map[int]bool, len(p.usedGroups))
+	for gi := range p.usedGroups {
+		saved[gi] = true
+	}
+	return saved
+}
+
+// groupNodeIDs returns the NUMA nodes that hold non-empty core groups, in
+// ascending order.
+func groupNodeIDs(topo *topology.CPUTopology) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, cg := range topo.CoreGroups {
+		if len(cg.PhysicalCPUs) > 0 && !seen[cg.NUMANodeID] {
+			seen[cg.NUMANodeID] = true
+			ids = append(ids, cg.NUMANodeID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// groupsInNode returns the indices of the non-empty core groups on a NUMA
+// node, in topology order.
+func groupsInNode(topo *topology.CPUTopology, nodeID int) []int {
+	var groups []int
+	for i, cg := range topo.CoreGroups {
+		if len(cg.PhysicalCPUs) > 0 && cg.NUMANodeID == nodeID {
+			groups = append(groups, i)
+		}
+	}
+	return groups
+}
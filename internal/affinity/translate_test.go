@@ -0,0 +1,132 @@
+package affinity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"epyc-pve/internal/topology"
+)
+
+// ccds returns n CCDs of cores SMT2 cores each, numbered like Linux does:
+// first threads 0..n*cores-1, then their siblings.
+func ccds(n, cores int) [][][]int {
+	total := n * cores
+	groups := make([][][]int, n)
+	for g := range groups {
+		groups[g] = smtCores(g*cores, cores, 2, total)
+	}
+	return groups
+}
+
+// noSMT returns n CCDs of cores single-threaded cores each.
+func noSMT(n, cores int) [][][]int {
+	groups := make([][][]int, n)
+	for g := range groups {
+		groups[g] = smtCores(g*cores, cores, 1, 0)
+	}
+	return groups
+}
+
+func TestTranslate(t *testing.T) {
+	// Milan-like: 2 NUMA nodes of 2 CCDs with 4 SMT2 cores.
+	milan := testTopology([]int{0, 0, 1, 1}, ccds(4, 4)...)
+	// Genoa-like: 2 NUMA nodes of 3 CCDs with 8 SMT2 cores.
+	genoa := testTopology([]int{0, 0, 0, 1, 1, 1}, ccds(6, 8)...)
+	// Milan's layout with SMT off.
+	milanNoSMT := testTopology([]int{0, 0, 1, 1}, noSMT(4, 4)...)
+	// NPS4: every CCD is its own NUMA node; NPS1: one node for all.
+	nps4 := testTopology([]int{0, 1, 2, 3}, ccds(4, 4)...)
+	nps1 := testTopology([]int{0, 0, 0, 0}, ccds(4, 4)...)
+
+	tests := []struct {
+		name      string
+		affinity  string
+		src, dst  *topology.CPUTopology
+		occupancy Occupancy
+		want      string
+		relaxed   []string
+	}{
+		{
+			name:     "identity",
+			affinity: "4-5,20-21,8,24",
+			src:      milan,
+			dst:      milan,
+			want:     "4-5,8,20-21,24",
+		},
+		{
+			name:     "milan to genoa, same shape",
+			affinity: "4-5,20-21",
+			src:      milan,
+			dst:      genoa,
+			want:     "8-9,56-57",
+		},
+		{
+			name:     "milan to genoa, two nodes",
+			affinity: "0,16,8,24",
+			src:      milan,
+			dst:      genoa,
+			want:     "0,24,48,72",
+		},
+		{
+			name:     "SMT2 to no SMT",
+			affinity: "0-1,16-17",
+			src:      milan,
+			dst:      milanNoSMT,
+			want:     "0-3",
+			relaxed:  []string{"SMT pairing"},
+		},
+		{
+			name:     "NPS4 to NPS1",
+			affinity: "0,16,4,20",
+			src:      nps4,
+			dst:      nps1,
+			want:     "0,4,16,20",
+			relaxed:  []string{"NUMA spread"},
+		},
+		{
+			name:      "avoids pinned cores",
+			affinity:  "4-5,20-21",
+			src:       milan,
+			dst:       genoa,
+			occupancy: Occupancy{8: {300}, 56: {300}},
+			want:      "9-10,57-58",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TranslateAvoiding(tt.affinity, tt.src, tt.dst, tt.occupancy)
+			if err != nil {
+				t.Fatalf("TranslateAvoiding: %v", err)
+			}
+			if got.AffinityStr != tt.want {
+				t.Errorf("got %s, want %s", got.AffinityStr, tt.want)
+			}
+			if !reflect.DeepEqual(got.Relaxed, tt.relaxed) {
+				t.Errorf("relaxed %q, want %q", got.Relaxed, tt.relaxed)
+			}
+		})
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	milan := testTopology([]int{0, 0, 1, 1}, ccds(4, 4)...)
+	small := testTopology([]int{0}, ccds(1, 2)...)
+
+	tests := []struct {
+		name     string
+		affinity string
+		dst      *topology.CPUTopology
+	}{
+		{"empty", "", milan},
+		{"unparsable", "0-x", milan},
+		{"not online on the source", "99", milan},
+		{"too large for the destination", "0-7", small},
+	}
+	for _, tt := range tests {
+		if _, err := Translate(tt.affinity, milan, tt.dst); !errors.Is(err, ErrTranslate) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrTranslate)
+		}
+	}
+}
@@ -13,6 +13,19 @@ const (
 	StrategyPCoresOnly  Strategy = "p-cores-only"
 	StrategyECoresOnly  Strategy = "e-cores-only"
 	StrategyAllCores    Strategy = "all-cores"
+	StrategyNUMALocal   Strategy = "numa-local"
+	StrategyCacheCCD    Strategy = "cache-ccd"
+	StrategyFreqCCD     Strategy = "frequency-ccd"
+)
+
+// CPUPool selects which online CPUs strategies may hand out, based on the
+// kernel's isolcpus setting.
+type CPUPool string
+
+const (
+	PoolExcludeIsolated CPUPool = "exclude-isolated"
+	PoolIsolatedOnly    CPUPool = "isolated-only"
+	PoolAll             CPUPool = "all"
 )
 
 type Option struct {
@@ -22,10 +35,36 @@ type Option struct {
 	CPUs        []int
 	AffinityStr string
 	CCDsUsed    int
+	GuestNUMA   []GuestNUMANode
+	// Overlap counts CPUs of this option, emulator CPUs included, already
+	// pinned by other VMs, listed in OverlapVMs.
+	Overlap    int
+	OverlapVMs []int
+	// EmulatorCPUs are reserved for QEMU's emulator and IO threads,
+	// outside CPUs.
+	EmulatorCPUs []int
+}
+
+// GuestNUMANode is one Proxmox numaN entry: a range of guest vCPUs whose
+// memory is bound to the given host nodes.
+type GuestNUMANode struct {
+	ID        int
+	CPUs      string
+	HostNodes []int
+	MemoryMB  int
 }
 
 type Request struct {
 	CoresNeeded int
 	IncludeSMT  bool
-	Topology    *topology.CPUTopology
+	MemoryMB    int
+	Pool        CPUPool
+	Occupancy   Occupancy
+	// Reserved CPUs are left to the host in every pool.
+	Reserved Reservation
+	// EmulatorCPUs host CPUs are reserved per option for emulator and IO
+	// threads, placed according to EmulatorPlacement.
+	EmulatorCPUs      int
+	EmulatorPlacement EmulatorPlacement
+	Topology          *topology.CPUTopology
 }
@@ -0,0 +1,93 @@
+package affinity
+
+import (
+	"sort"
+
+	"epyc-pve/internal/topology"
+)
+
+// VCPUMap assigns every guest vCPU one host CPU of the option, indexed by
+// vCPU number. Host cores are taken in topology order and all of a core's
+// threads go to consecutive vCPUs, so the sibling pairs a guest sees
+// (0/1, 2/3, ...) land on one host core's SMT siblings and each CCD serves
+// a contiguous range of vCPUs. With guest NUMA nodes, each node's vCPU range
+// gets the CPUs of the host nodes its memory is bound to.
+func (o *Option) VCPUMap(topo *topology.CPUTopology) []int {
+	selected := make(map[int]bool, len(o.CPUs))
+	for _, cpu := range o.CPUs {
+		selected[cpu] = true
+	}
+
+	ordered := make([]int, 0, len(o.CPUs))
+	for _, cg := range topo.CoreGroups {
+		cores := append([]int(nil), cg.PhysicalCPUs...)
+		sort.Ints(cores)
+		for _, core := range cores {
+			for _, cpu := range topo.Threads(core) {
+				if selected[cpu] {
+					ordered = append(ordered, cpu)
+					delete(selected, cpu)
+				}
+			}
+		}
+	}
+
+	// CPUs outside every core group, if any, follow in numeric order.
+	var rest []int
+	for cpu := range selected {
+		rest = append(rest, cpu)
+	}
+	sort.Ints(rest)
+	ordered = append(ordered, rest...)
+
+	if len(o.GuestNUMA) == 0 {
+		return ordered
+	}
+	return placeByGuestNUMA(ordered, o.GuestNUMA, topo)
+}
+
+// placeByGuestNUMA moves host CPUs to the vCPU ranges of the guest nodes
+// bound to their host node, keeping their order within each range. vCPUs no
+// guest node claims take the remaining CPUs in order.
+func placeByGuestNUMA(ordered []int, guest []GuestNUMANode, topo *topology.CPUTopology) []int {
+	byHostNode := make(map[int][]int)
+	for _, cpu := range ordered {
+		node := topo.NUMANodeOf(cpu)
+		byHostNode[node] = append(byHostNode[node], cpu)
+	}
+
+	vcpuMap := make([]int, len(ordered))
+	assigned := make([]bool, len(ordered))
+	used := make(map[int]bool, len(ordered))
+	for _, node := range guest {
+		vcpus, err := topology.ParseList(node.CPUs)
+		if err != nil {
+			continue
+		}
+		var cpus []int
+		for _, hostNode := range node.HostNodes {
+			cpus = append(cpus, byHostNode[hostNode]...)
+		}
+		for i, vcpu := range vcpus {
+			if i >= len(cpus) || vcpu < 0 || vcpu >= len(vcpuMap) || assigned[vcpu] || used[cpus[i]] {
+				continue
+			}
+			vcpuMap[vcpu] = cpus[i]
+			assigned[vcpu] = true
+			used[cpus[i]] = true
+		}
+	}
+
+	next := 0
+	for vcpu := range vcpuMap {
+		if assigned[vcpu] {
+			continue
+		}
+		for used[ordered[next]] {
+			next++
+		}
+		vcpuMap[vcpu] = ordered[next]
+		used[ordered[next]] = true
+	}
+	return vcpuMap
+}
@@ -0,0 +1,54 @@
+package affinity
+
+import (
+	"testing"
+
+	"epyc-pve/internal/topology"
+)
+
+func TestVCPUMapFollowsGuestNUMA(t *testing.T) {
+	// Node 0 has one CCD, node 1 two, so a request neither node can hold
+	// alone starts on node 1: guest node 0 is bound to host node 1.
+	topo := testTopology([]int{0, 1, 1}, smtCores(0, 2, 2, 6), smtCores(2, 2, 2, 6), smtCores(4, 2, 2, 6))
+	req := &Request{CoresNeeded: 10, IncludeSMT: true, Pool: PoolAll, MemoryMB: 10240, Topology: topo}
+
+	options, err := Generate(req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var opt *Option
+	for i := range options {
+		if options[i].Strategy == StrategyNUMALocal {
+			opt = &options[i]
+		}
+	}
+	if opt == nil || len(opt.GuestNUMA) != 2 {
+		t.Fatalf("want a numa-local option with two guest nodes, got %+v", opt)
+	}
+	if host := opt.GuestNUMA[0].HostNodes; len(host) != 1 || host[0] != 1 {
+		t.Fatalf("guest node 0 bound to host nodes %v, want [1]", host)
+	}
+
+	vcpuMap := opt.VCPUMap(topo)
+	if len(vcpuMap) != len(opt.CPUs) {
+		t.Fatalf("mapped %d vCPUs, want %d", len(vcpuMap), len(opt.CPUs))
+	}
+	for _, node := range opt.GuestNUMA {
+		vcpus, err := topology.ParseList(node.CPUs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vcpu := range vcpus {
+			if got := topo.NUMANodeOf(vcpuMap[vcpu]); got != node.HostNodes[0] {
+				t.Errorf("vCPU %d of guest node %d runs on host CPU %d in node %d, memory is on node %d",
+					vcpu, node.ID, vcpuMap[vcpu], got, node.HostNodes[0])
+			}
+		}
+	}
+	// Guest sibling pairs still share a host core.
+	for vcpu := 0; vcpu+1 < len(vcpuMap); vcpu += 2 {
+		if threads := topo.Threads(vcpuMap[vcpu]); len(threads) != 2 || threads[1] != vcpuMap[vcpu+1] {
+			t.Errorf("vCPUs %d/%d on host CPUs %d/%d, not one core", vcpu, vcpu+1, vcpuMap[vcpu], vcpuMap[vcpu+1])
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package cluster reads a Proxmox cluster's members, VM configs and the
+// topology snapshot each node publishes under /etc/pve, so pinnings can be
+// planned and validated per node.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/pve"
+	"epyc-pve/internal/topology"
+)
+
+// Dir is the cluster file system, replicated to every node.
+const Dir = "/etc/pve"
+
+// SnapshotName is the topology snapshot a node publishes in its
+// nodes/<node> directory.
+const SnapshotName = "proxmox-affinity-topology.json"
+
+var (
+	ErrNoSnapshot     = errors.New("no topology snapshot published")
+	ErrInvalidPinning = errors.New("pinned VMs do not fit their node")
+)
+
+// Node is one cluster member.
+type Node struct {
+	Name   string
+	ID     int
+	IP     string
+	Online bool
+	// Local is the node the tool runs on.
+	Local bool
+	VMs   []pve.VM
+	// Topology is the node's CPU layout: detected live on the local node,
+	// otherwise read from its published snapshot. It is nil when neither
+	// is available, with the reason in TopologyErr.
+	Topology    *topology.CPUTopology
+	TopologyErr error
+	// SnapshotAt is when the snapshot was captured; zero for a live
+	// topology.
+	SnapshotAt time.Time
+}
+
+// Cluster is the view of the whole cluster from one node.
+type Cluster struct {
+	Name  string
+	Local string
+	Nodes []Node
+}
+
+// members is the layout of /etc/pve/.members. A standalone node has no
+// nodelist.
+type members struct {
+	NodeName string `json:"nodename"`
+	Cluster  struct {
+		Name string `json:"name"`
+	} `json:"cluster"`
+	NodeList map[string]struct {
+		ID     int    `json:"id"`
+		Online int    `json:"online"`
+		IP     string `json:"ip"`
+	} `json:"nodelist"`
+}
+
+// SnapshotPath returns where node publishes its topology snapshot.
+func SnapshotPath(dir, node string) string {
+	return filepath.Join(dir, "nodes", node, SnapshotName)
+}
+
+// LocalNode returns the name of the node the tool runs on.
+func LocalNode(dir string) (string, error) {
+	m, err := readMembers(dir)
+	if err != nil {
+		return "", err
+	}
+	return m.NodeName, nil
+}
+
+// Publish writes snap as the local node's topology snapshot, where every
+// other node can read it.
+func Publish(dir string, snap *topology.Snapshot) (string, error) {
+	node, err := LocalNode(dir)
+	if err != nil {
+		return "", err
+	}
+	path := SnapshotPath(dir, node)
+	return path, snap.Save(path)
+}
+
+// Load reads the cluster from dir. local, if given, is used as the local
+// node's topology instead of its snapshot.
+func Load(dir string, local *topology.CPUTopology) (*Cluster, error) {
+	m, err := readMembers(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{Name: m.Cluster.Name, Local: m.NodeName}
+	if len(m.NodeList) == 0 {
+		c.Nodes = []Node{{Name: m.NodeName, Online: true}}
+	}
+	for name, member := range m.NodeList {
+		c.Nodes = append(c.Nodes, Node{Name: name, ID: member.ID, IP: member.IP, Online: member.Online == 1})
+	}
+	sort.Slice(c.Nodes, func(i, j int) bool { return c.Nodes[i].Name < c.Nodes[j].Name })
+
+	for i := range c.Nodes {
+		node := &c.Nodes[i]
+		node.Local = node.Name == m.NodeName
+		node.VMs, err = pve.ReadVMs(filepath.Join(dir, "nodes", node.Name, "qemu-server"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		for j := range node.VMs {
+			node.VMs[j].Node = node.Name
+		}
+		if node.Local && local != nil {
+			node.Topology = local
+			continue
+		}
+		node.Topology, node.SnapshotAt, node.TopologyErr = loadSnapshot(SnapshotPath(dir, node.Name))
+	}
+	return c, nil
+}
+
+// Node returns the member called name, or nil.
+func (c *Cluster) Node(name string) *Node {
+	for i := range c.Nodes {
+		if c.Nodes[i].Name == name {
+			return &c.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// FindVM returns the node whose config directory holds vmid, and the VM.
+func (c *Cluster) FindVM(vmid int) (*Node, *pve.VM, error) {
+	for i := range c.Nodes {
+		for j := range c.Nodes[i].VMs {
+			if c.Nodes[i].VMs[j].VMID == vmid {
+				return &c.Nodes[i], &c.Nodes[i].VMs[j], nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %d is on no cluster node", pve.ErrVMNotFound, vmid)
+}
+
+// Occupancy returns the CPUs pinned by the node's VMs, except exclude.
+func (n *Node) Occupancy(exclude int) (affinity.Occupancy, error) {
+	affinities := make(map[int]string)
+	for _, vm := range n.VMs {
+		if vm.Affinity != "" {
+			affinities[vm.VMID] = vm.Affinity
+		}
+	}
+	return affinity.NewOccupancy(affinities, exclude)
+}
+
+// Finding lists the problems of one pinned VM.
+type Finding struct {
+	Node     string
+	VMID     int
+	Name     string
+	Affinity string
+	Problems []affinity.Problem
+}
+
+// Check validates the affinity of every pinned VM against the topology of
+// the node it is on, so VMs that migrated to a different CPU are caught.
+// Nodes without a topology yield one finding with VMID 0.
+func (c *Cluster) Check() []Finding {
+	var findings []Finding
+	for _, node := range c.Nodes {
+		if node.Topology == nil {
+			if hasPinnedVMs(node.VMs) {
+				findings = append(findings, Finding{Node: node.Name, Problems: []affinity.Problem{{
+					Severity: affinity.SeverityError,
+					Message:  fmt.Sprintf("cannot validate: %v", node.TopologyErr),
+				}}})
+			}
+			continue
+		}
+		for _, vm := range node.VMs {
+			if vm.Affinity == "" {
+				continue
+			}
+			occupancy, err := node.Occupancy(vm.VMID)
+			if err != nil {
+				occupancy = nil
+			}
+			problems := affinity.Check(node.Topology, vm.Affinity, vm.NextStartVCPUs(), occupancy)
+			if len(problems) == 0 {
+				continue
+			}
+			findings = append(findings, Finding{
+				Node:     node.Name,
+				VMID:     vm.VMID,
+				Name:     vm.Name,
+				Affinity: vm.Affinity,
+				Problems: problems,
+			})
+		}
+	}
+	return findings
+}
+
+func hasPinnedVMs(vms []pve.VM) bool {
+	for _, vm := range vms {
+		if vm.Affinity != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func readMembers(dir string) (*members, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ".members"))
+	if err != nil {
+		return nil, err
+	}
+	var m members
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid %s/.members: %w", dir, err)
+	}
+	if m.NodeName == "" {
+		return nil, fmt.Errorf("invalid %s/.members: no nodename", dir)
+	}
+	return &m, nil
+}
+
+func loadSnapshot(path string) (*topology.CPUTopology, time.Time, error) {
+	snap, err := topology.LoadSnapshot(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, fmt.Errorf("%w (run \"cluster publish\" on the node)", ErrNoSnapshot)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	topo, err := snap.Detect()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return topo, snap.CapturedAt, nil
+}
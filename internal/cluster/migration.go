@@ -0,0 +1,146 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/topology"
+)
+
+var (
+	ErrIncompatible = errors.New("affinity does not fit the target node")
+	ErrUnknownNode  = errors.New("unknown node")
+)
+
+// MigrationReport is the result of CheckMigration.
+type MigrationReport struct {
+	VMID     int
+	Name     string
+	Source   string
+	Target   string
+	Affinity string
+	// Problems are those of the unchanged affinity on the target.
+	Problems []affinity.Problem
+	// Before is the locality on the source; nil when the source has no
+	// topology. After is the locality of the same CPU numbers on the
+	// target.
+	Before *affinity.Locality
+	After  affinity.Locality
+	// Proposed is an affinity for the target with the source's locality,
+	// empty if none fits. It is translated from the source topology when
+	// that is known; otherwise ProposedStrategy names the strategy that
+	// generated it.
+	Proposed         string
+	ProposedStrategy affinity.Strategy
+	ProposedRelaxed  []string
+	ProposedLocality affinity.Locality
+}
+
+// Compatible reports whether the affinity can be kept as it is.
+func (r *MigrationReport) Compatible() bool {
+	return !affinity.HasErrors(r.Problems)
+}
+
+// KeepsLocality reports whether the affinity spans the same number of core
+// groups and NUMA nodes on the target, with the same SMT pairing.
+func (r *MigrationReport) KeepsLocality() bool {
+	if r.Before == nil {
+		return true
+	}
+	return r.Before.Groups == r.After.Groups && r.Before.NUMANodes == r.After.NUMANodes &&
+		r.Before.FullCores == r.After.FullCores && r.Before.CPUs == r.After.CPUs
+}
+
+// CheckMigration checks vmid's affinity against the topology of target and
+// proposes one for the target that keeps the source's locality. The proposal
+// only uses CPUs of pool that are not reserved on the target.
+func (c *Cluster) CheckMigration(vmid int, target string, pool affinity.CPUPool, reserved affinity.Reservation) (*MigrationReport, error) {
+	source, vm, err := c.FindVM(vmid)
+	if err != nil {
+		return nil, err
+	}
+	dst := c.Node(target)
+	if dst == nil {
+		return nil, fmt.Errorf("%w: %q is not a cluster member", ErrUnknownNode, target)
+	}
+	if dst.Topology == nil {
+		return nil, fmt.Errorf("node %s: %w", dst.Name, dst.TopologyErr)
+	}
+
+	report := &MigrationReport{VMID: vmid, Name: vm.Name, Source: source.Name, Target: dst.Name, Affinity: vm.Affinity}
+	if vm.Affinity == "" {
+		return report, nil
+	}
+	cpus, err := topology.ParseList(vm.Affinity)
+	if err != nil {
+		return nil, fmt.Errorf("VM %d: invalid affinity %q: %w", vmid, vm.Affinity, err)
+	}
+
+	occupancy, err := dst.Occupancy(vmid)
+	if err != nil {
+		occupancy = nil
+	}
+	report.Problems = affinity.Check(dst.Topology, vm.Affinity, vm.NextStartVCPUs(), occupancy)
+	report.After = affinity.DescribeLocality(dst.Topology, cpus)
+
+	before := affinity.Locality{CPUs: len(cpus), FullCores: true}
+	if source.Topology != nil {
+		before = affinity.DescribeLocality(source.Topology, cpus)
+		report.Before = &before
+	}
+	req := &affinity.Request{
+		CoresNeeded: len(cpus),
+		IncludeSMT:  before.FullCores,
+		Pool:        pool,
+		Occupancy:   occupancy,
+		Reserved:    reserved,
+		Topology:    dst.Topology,
+	}
+	if source.Topology != nil {
+		if t, err := affinity.TranslateAvoiding(vm.Affinity, source.Topology, req.UsableTopology(), occupancy); err == nil {
+			report.Proposed = t.AffinityStr
+			report.ProposedRelaxed = t.Relaxed
+			report.ProposedLocality = affinity.DescribeLocality(dst.Topology, t.CPUs)
+			return report, nil
+		}
+	}
+	if option := proposeFor(req, before); option != nil {
+		report.Proposed = option.AffinityStr
+		report.ProposedStrategy = option.Strategy
+		report.ProposedLocality = affinity.DescribeLocality(dst.Topology, option.CPUs)
+	}
+	return report, nil
+}
+
+// proposeFor generates the affinity req asks for when it cannot be
+// translated from the source, picking the strategy that matches the spread
+// of before: one core group, one NUMA node, or spread out. It returns nil
+// when the target cannot hold it.
+func proposeFor(req *affinity.Request, before affinity.Locality) *affinity.Option {
+	options, err := affinity.Generate(req)
+	if err != nil {
+		return nil
+	}
+
+	preferred := []affinity.Strategy{affinity.StrategyDistributed, affinity.StrategySequential}
+	switch {
+	case before.Groups == 1:
+		preferred = []affinity.Strategy{affinity.StrategySingleCCD, affinity.StrategyNUMALocal, affinity.StrategySequential}
+	case before.NUMANodes == 1:
+		preferred = []affinity.Strategy{affinity.StrategyNUMALocal, affinity.StrategySequential}
+	}
+	for _, strategy := range preferred {
+		for i := range options {
+			if options[i].Strategy == strategy && len(options[i].CPUs) > 0 {
+				return &options[i]
+			}
+		}
+	}
+	for i := range options {
+		if len(options[i].CPUs) > 0 && options[i].Strategy != affinity.StrategyManual {
+			return &options[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/pve"
+	"epyc-pve/internal/topology"
+)
+
+// twoCCDs has one NUMA node with two CCDs of four cores without SMT: CPUs
+// 0-3 and 4-7.
+func twoCCDs() *topology.CPUTopology {
+	topo := &topology.CPUTopology{
+		Architecture:   topology.ArchAMD,
+		ThreadSiblings: make(map[int][]int),
+		TotalCores:     8,
+		TotalCPUs:      8,
+		NUMANodes:      []topology.NUMANode{{ID: 0, CPUs: []int{0, 1, 2, 3, 4, 5, 6, 7}, Distances: []int{10}}},
+	}
+	for g := 0; g < 2; g++ {
+		cg := topology.CoreGroup{ID: g, Name: fmt.Sprintf("CCD %d", g), L3CacheID: g, DieID: g, L3SizeKB: 32768}
+		for cpu := 4 * g; cpu < 4*g+4; cpu++ {
+			cg.PhysicalCPUs = append(cg.PhysicalCPUs, cpu)
+			cg.AllCPUs = append(cg.AllCPUs, cpu)
+			topo.ThreadSiblings[cpu] = []int{cpu}
+		}
+		topo.CoreGroups = append(topo.CoreGroups, cg)
+	}
+	return topo
+}
+
+func TestCheckMigrationAvoidsReservedCPUs(t *testing.T) {
+	vm := pve.VM{VMID: 201, Name: "db"}
+	vm.Affinity = "0-1"
+	c := &Cluster{Nodes: []Node{
+		{Name: "pve1", VMs: []pve.VM{vm}, Topology: twoCCDs()},
+		{Name: "pve2", Topology: twoCCDs()},
+	}}
+
+	report, err := c.CheckMigration(201, "pve2", "", affinity.Reservation{CPUs: []int{0, 1}})
+	if err != nil {
+		t.Fatalf("CheckMigration: %v", err)
+	}
+	cpus, err := topology.ParseList(report.Proposed)
+	if err != nil || len(cpus) != 2 {
+		t.Fatalf("proposed %q", report.Proposed)
+	}
+	for _, cpu := range cpus {
+		if cpu == 0 || cpu == 1 {
+			t.Errorf("proposed %s uses reserved CPU %d", report.Proposed, cpu)
+		}
+	}
+	if report.ProposedLocality.Groups != 1 {
+		t.Errorf("proposed %s spans %d CCDs, want 1", report.Proposed, report.ProposedLocality.Groups)
+	}
+}
@@ -0,0 +1,304 @@
+package host
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"epyc-pve/internal/affinity"
+)
+
+// IsolationKeys are the kernel parameters Isolation manages.
+var IsolationKeys = []string{"isolcpus", "nohz_full", "rcu_nocbs", "irqaffinity"}
+
+var (
+	ErrNothingPinned = errors.New("no VM CPUs to isolate")
+	ErrNoBootloader  = errors.New("no supported boot configuration found")
+	ErrGrubCmdline   = errors.New("cannot parse " + grubCmdlineKey)
+)
+
+// Isolation splits the host's online CPUs into those VMs are pinned to,
+// which the kernel should stay off, and housekeeping CPUs for everything
+// else.
+type Isolation struct {
+	Isolated     []int
+	Housekeeping []int
+}
+
+// Param is one kernel parameter.
+type Param struct {
+	Key   string
+	Value string
+}
+
+func (p Param) String() string {
+	return p.Key + "=" + p.Value
+}
+
+// NewIsolation isolates the pinned CPUs except reserved ones. Interrupts go
+// to the online reserved CPUs when there are any, otherwise to every CPU
+// that is not isolated.
+func NewIsolation(online, pinned, reserved []int) (*Isolation, error) {
+	isReserved := toSet(reserved)
+	isPinned := toSet(pinned)
+
+	iso := &Isolation{}
+	for _, cpu := range online {
+		if isPinned[cpu] && !isReserved[cpu] {
+			iso.Isolated = append(iso.Isolated, cpu)
+		}
+	}
+	if len(iso.Isolated) == 0 {
+		return nil, ErrNothingPinned
+	}
+	if len(iso.Isolated) == len(online) {
+		return nil, fmt.Errorf("%w: VMs are pinned to every online CPU, reserve some for the host", ErrNothingPinned)
+	}
+
+	for _, cpu := range online {
+		if isReserved[cpu] {
+			iso.Housekeeping = append(iso.Housekeeping, cpu)
+		}
+	}
+	if len(iso.Housekeeping) == 0 {
+		isIsolated := toSet(iso.Isolated)
+		for _, cpu := range online {
+			if !isIsolated[cpu] {
+				iso.Housekeeping = append(iso.Housekeeping, cpu)
+			}
+		}
+	}
+	return iso, nil
+}
+
+// Params returns the kernel parameters, in IsolationKeys order. isolcpus
+// also moves managed interrupts and scheduler domains off the CPUs.
+func (i *Isolation) Params() []Param {
+	isolated := affinity.FormatCPUs(i.Isolated)
+	return []Param{
+		{Key: "isolcpus", Value: "managed_irq,domain," + isolated},
+		{Key: "nohz_full", Value: isolated},
+		{Key: "rcu_nocbs", Value: isolated},
+		{Key: "irqaffinity", Value: affinity.FormatCPUs(i.Housekeeping)},
+	}
+}
+
+// ParamChange compares one parameter of a command line with its planned
+// value. Current is empty when the parameter is not set.
+type ParamChange struct {
+	Param
+	Current string
+}
+
+// Changed reports whether the parameter differs from the command line.
+func (c ParamChange) Changed() bool {
+	return c.Current != c.Value
+}
+
+// DiffCmdline compares params with those on cmdline, e.g. /proc/cmdline.
+func DiffCmdline(cmdline string, params []Param) []ParamChange {
+	current := make(map[string]string)
+	for _, field := range strings.Fields(cmdline) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			current[key] = value
+		}
+	}
+	changes := make([]ParamChange, len(params))
+	for i, p := range params {
+		changes[i] = ParamChange{Param: p, Current: current[p.Key]}
+	}
+	return changes
+}
+
+// ReplaceParams returns cmdline with every managed parameter removed and
+// params appended.
+func ReplaceParams(cmdline string, params []Param) string {
+	managed := make(map[string]bool)
+	for _, key := range IsolationKeys {
+		managed[key] = true
+	}
+	var fields []string
+	for _, field := range strings.Fields(cmdline) {
+		key, _, _ := strings.Cut(field, "=")
+		if !managed[key] {
+			fields = append(fields, field)
+		}
+	}
+	for _, p := range params {
+		fields = append(fields, p.String())
+	}
+	return strings.Join(fields, " ")
+}
+
+// Bootloader is where a Proxmox host keeps its kernel command line.
+type Bootloader struct {
+	// Name is "systemd-boot" or "grub".
+	Name string
+	// Path holds the command line: /etc/kernel/cmdline for systemd-boot
+	// (ZFS on UEFI), /etc/default/grub for GRUB.
+	Path string
+	// Refresh writes the new command line into the boot entries.
+	Refresh []string
+}
+
+const (
+	kernelCmdlinePath = "etc/kernel/cmdline"
+	grubDefaultPath   = "etc/default/grub"
+	bootUUIDsPath     = "etc/kernel/proxmox-boot-uuids"
+	grubCmdlineKey    = "GRUB_CMDLINE_LINUX_DEFAULT"
+)
+
+var (
+	// grubCmdlineLine finds every assignment, however it is written.
+	grubCmdlineLine = regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?` + grubCmdlineKey + `=.*$`)
+	// grubCmdlinePattern matches the assignments this tool can rewrite: one
+	// literal value, quoted or not, optionally followed by a comment.
+	// Variables and command substitutions would need a shell to evaluate.
+	grubCmdlinePattern = regexp.MustCompile(`(?m)^` + grubCmdlineKey + "=(?:\"([^\"$`\\\\]*)\"|'([^']*)'|([^\\s\"'$`\\\\#]*))([ \t]*(?:#.*)?)$")
+)
+
+// DetectBootloader finds the boot configuration under root, normally "/".
+// /etc/kernel/cmdline is only used by systemd-boot, so it wins over
+// /etc/default/grub. Hosts whose ESPs are managed by proxmox-boot-tool are
+// refreshed with it, plain GRUB installs with update-grub.
+func DetectBootloader(root string) (*Bootloader, error) {
+	refresh := []string{"update-grub"}
+	if exists(filepath.Join(root, bootUUIDsPath)) {
+		refresh = []string{"proxmox-boot-tool", "refresh"}
+	}
+	if path := filepath.Join(root, kernelCmdlinePath); exists(path) {
+		return &Bootloader{Name: "systemd-boot", Path: path, Refresh: []string{"proxmox-boot-tool", "refresh"}}, nil
+	}
+	if path := filepath.Join(root, grubDefaultPath); exists(path) {
+		return &Bootloader{Name: "grub", Path: path, Refresh: refresh}, nil
+	}
+	return nil, fmt.Errorf("%w: neither /%s nor /%s exists", ErrNoBootloader, kernelCmdlinePath, grubDefaultPath)
+}
+
+// Cmdline returns the command line the boot configuration holds.
+func (b *Bootloader) Cmdline() (string, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return "", err
+	}
+	if b.Name != "grub" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	text := string(data)
+	loc, err := b.findGrubCmdline(text)
+	if err != nil || loc == nil {
+		return "", err
+	}
+	return submatch(text, loc, 1) + submatch(text, loc, 2) + submatch(text, loc, 3), nil
+}
+
+// findGrubCmdline returns the submatch indexes of the single assignment of
+// GRUB_CMDLINE_LINUX_DEFAULT in text, or nil if there is none. An
+// assignment it cannot rewrite safely is an error rather than a reason to
+// add a second one, which would drop the options it holds.
+func (b *Bootloader) findGrubCmdline(text string) ([]int, error) {
+	lines := grubCmdlineLine.FindAllString(text, -1)
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	if len(lines) > 1 {
+		return nil, fmt.Errorf("%w: %s sets it %d times, edit it by hand", ErrGrubCmdline, b.Path, len(lines))
+	}
+	loc := grubCmdlinePattern.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return nil, fmt.Errorf("%w: %s: %s, edit it by hand", ErrGrubCmdline, b.Path, strings.TrimSpace(lines[0]))
+	}
+	return loc, nil
+}
+
+func submatch(text string, loc []int, n int) string {
+	if loc[2*n] < 0 {
+		return ""
+	}
+	return text[loc[2*n]:loc[2*n+1]]
+}
+
+// Update writes params into the boot configuration, keeping a copy of the
+// old file next to it with a .bak suffix, and returns the new command line.
+// With dryRun nothing is written. The change takes effect after Refresh and
+// a reboot.
+func (b *Bootloader) Update(params []Param, dryRun bool) (string, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return "", err
+	}
+	current, err := b.Cmdline()
+	if err != nil {
+		return "", err
+	}
+	cmdline := ReplaceParams(current, params)
+
+	var updated string
+	if b.Name != "grub" {
+		updated = cmdline + "\n"
+	} else {
+		text := string(data)
+		line := grubCmdlineKey + `="` + cmdline + `"`
+		loc, err := b.findGrubCmdline(text)
+		if err != nil {
+			return "", err
+		}
+		if loc != nil {
+			// Keep a trailing comment.
+			updated = text[:loc[0]] + line + submatch(text, loc, 4) + text[loc[1]:]
+		} else {
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			updated = text + line + "\n"
+		}
+	}
+	if dryRun {
+		return cmdline, nil
+	}
+
+	info, err := os.Stat(b.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(b.Path+".bak", data, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(b.Path, []byte(updated), info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return cmdline, nil
+}
+
+// RunRefresh runs the command that copies the command line into the boot
+// entries.
+func (b *Bootloader) RunRefresh() error {
+	cmd := exec.Command(b.Refresh[0], b.Refresh[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %v: %s", strings.Join(b.Refresh, " "), err, msg)
+		}
+		return fmt.Errorf("%s: %v", strings.Join(b.Refresh, " "), err)
+	}
+	return nil
+}
+
+func toSet(cpus []int) map[int]bool {
+	set := make(map[int]bool, len(cpus))
+	for _, cpu := range cpus {
+		set[cpu] = true
+	}
+	return set
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,84 @@
+package host
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGrubUpdate(t *testing.T) {
+	params := []Param{{Key: "isolcpus", Value: "managed_irq,domain,4-7"}, {Key: "irqaffinity", Value: "0-3"}}
+
+	tests := []struct {
+		name    string
+		grub    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "double quoted",
+			grub: "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\nGRUB_CMDLINE_LINUX=\"\"\n",
+			want: "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet isolcpus=managed_irq,domain,4-7 irqaffinity=0-3\"\nGRUB_CMDLINE_LINUX=\"\"\n",
+		},
+		{
+			name: "single quoted with comment",
+			grub: "GRUB_CMDLINE_LINUX_DEFAULT='quiet isolcpus=2-3' # tuned\n",
+			want: "GRUB_CMDLINE_LINUX_DEFAULT=\"quiet isolcpus=managed_irq,domain,4-7 irqaffinity=0-3\" # tuned\n",
+		},
+		{
+			name: "missing",
+			grub: "GRUB_DEFAULT=0\n#GRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"",
+			want: "GRUB_DEFAULT=0\n#GRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\nGRUB_CMDLINE_LINUX_DEFAULT=\"isolcpus=managed_irq,domain,4-7 irqaffinity=0-3\"\n",
+		},
+		{
+			name:    "variable",
+			grub:    "GRUB_CMDLINE_LINUX_DEFAULT=\"quiet $EXTRA\"\n",
+			wantErr: ErrGrubCmdline,
+		},
+		{
+			name:    "unquoted variable",
+			grub:    "GRUB_CMDLINE_LINUX_DEFAULT=$EXTRA\n",
+			wantErr: ErrGrubCmdline,
+		},
+		{
+			name:    "set twice",
+			grub:    "GRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\nGRUB_CMDLINE_LINUX_DEFAULT=\"${GRUB_CMDLINE_LINUX_DEFAULT} splash\"\n",
+			wantErr: ErrGrubCmdline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "grub")
+			if err := os.WriteFile(path, []byte(tt.grub), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			boot := &Bootloader{Name: "grub", Path: path}
+
+			_, err := boot.Update(params, false)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if data, _ := os.ReadFile(path); string(data) != tt.grub {
+					t.Errorf("file changed to %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", data, tt.want)
+			}
+			if backup, _ := os.ReadFile(path + ".bak"); string(backup) != tt.grub {
+				t.Errorf("backup holds %q", backup)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Package host writes host settings that keep Proxmox's own work apart from
+// pinned VMs.
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"epyc-pve/internal/affinity"
+)
+
+// SystemdDir is where systemd reads administrator drop-ins from.
+const SystemdDir = "/etc/systemd/system"
+
+// DropInName is the drop-in file written for each slice.
+const DropInName = "proxmox-affinity.conf"
+
+// Slices are the slices confined to the reserved CPUs: host services and
+// login sessions. VMs run in qemu.slice and are left alone.
+var Slices = []string{"system.slice", "user.slice"}
+
+var ErrNoReservation = errors.New("no CPUs reserved")
+
+// SliceDropIn renders the drop-in that limits a slice to cpus.
+func SliceDropIn(cpus []int) string {
+	return "# Written by proxmox-affinity: keep host services on the reserved CPUs.\n" +
+		"[Slice]\n" +
+		"AllowedCPUs=" + affinity.FormatCPUs(cpus) + "\n"
+}
+
+// SliceDropInPath returns the drop-in file of slice under dir.
+func SliceDropInPath(dir, slice string) string {
+	return filepath.Join(dir, slice+".d", DropInName)
+}
+
+// WriteSliceDropIns writes a drop-in for every slice in Slices under dir and
+// returns their paths. systemd picks them up after a daemon-reload.
+func WriteSliceDropIns(dir string, cpus []int) ([]string, error) {
+	if len(cpus) == 0 {
+		return nil, ErrNoReservation
+	}
+	content := SliceDropIn(cpus)
+	paths := make([]string, 0, len(Slices))
+	for _, slice := range Slices {
+		path := SliceDropInPath(dir, slice)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return nil, fmt.Errorf("write %s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
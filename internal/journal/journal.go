@@ -0,0 +1,186 @@
+// Package journal keeps an append-only log of affinity changes so they can
+// be listed and rolled back.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"epyc-pve/internal/topology"
+)
+
+const DefaultPath = "/var/lib/proxmox-affinity/journal.jsonl"
+
+// Strategies recorded for changes that did not come from affinity.Generate.
+const (
+	StrategyReset    = "reset"
+	StrategyRollback = "rollback"
+)
+
+var ErrNoEntry = errors.New("no journal entry")
+
+// Entry is one affinity change. An empty Previous or Affinity means the VM
+// had no affinity set.
+type Entry struct {
+	// Seq is the entry's line number, counted from 1. It is not stored.
+	Seq      int       `json:"-"`
+	Time     time.Time `json:"time"`
+	VMID     int       `json:"vmid"`
+	Previous string    `json:"previous"`
+	Affinity string    `json:"affinity"`
+	Strategy string    `json:"strategy"`
+	Topology string    `json:"topology"`
+	// Config holds the other settings changed along with the affinity
+	// (sockets, cores, numaN, ...) and PreviousConfig their values before.
+	// An empty value means the setting was unset.
+	Config         map[string]string `json:"config,omitempty"`
+	PreviousConfig map[string]string `json:"previous_config,omitempty"`
+}
+
+// NewEntry describes setting vmid's affinity and the settings in config,
+// taking the previous values from current, the VM's config.
+func NewEntry(vmid int, current map[string]string, affinity string, config map[string]string, strategy string) Entry {
+	entry := Entry{
+		VMID:     vmid,
+		Previous: current["affinity"],
+		Affinity: affinity,
+		Strategy: strategy,
+	}
+	if len(config) > 0 {
+		entry.Config = make(map[string]string, len(config))
+		entry.PreviousConfig = make(map[string]string, len(config))
+		for key, value := range config {
+			entry.Config[key] = value
+			entry.PreviousConfig[key] = current[key]
+		}
+	}
+	return entry
+}
+
+// Append adds entry to the journal at path, creating it if needed.
+func Append(path string, entry Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns every entry in the journal, oldest first. A missing journal
+// has no entries.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entry.Seq = line
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ForVM returns the entries of one VM; vmid 0 returns all of them.
+func ForVM(entries []Entry, vmid int) []Entry {
+	if vmid == 0 {
+		return entries
+	}
+	var result []Entry
+	for _, entry := range entries {
+		if entry.VMID == vmid {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Target is what a rollback restores.
+type Target struct {
+	Affinity string
+	// Config maps the other settings to restore to their value; an empty
+	// value deletes the setting.
+	Config map[string]string
+	// Entry is the journal entry the target comes from.
+	Entry Entry
+}
+
+// RollbackTarget returns what to restore for vmid. With seq 0 the VM's
+// latest change is undone; otherwise the VM returns to the state right after
+// entry seq, so settings later entries changed are restored too.
+func RollbackTarget(entries []Entry, vmid, seq int) (*Target, error) {
+	vmEntries := ForVM(entries, vmid)
+	if len(vmEntries) == 0 {
+		return nil, fmt.Errorf("%w for VM %d", ErrNoEntry, vmid)
+	}
+	if seq == 0 {
+		last := vmEntries[len(vmEntries)-1]
+		return &Target{Affinity: last.Previous, Config: copyConfig(last.PreviousConfig), Entry: last}, nil
+	}
+	for i, entry := range vmEntries {
+		if entry.Seq != seq {
+			continue
+		}
+		config := copyConfig(entry.Config)
+		for _, later := range vmEntries[i+1:] {
+			for key, value := range later.PreviousConfig {
+				if _, ok := config[key]; !ok {
+					config[key] = value
+				}
+			}
+		}
+		return &Target{Affinity: entry.Affinity, Config: config, Entry: entry}, nil
+	}
+	return nil, fmt.Errorf("%w #%d for VM %d", ErrNoEntry, seq, vmid)
+}
+
+func copyConfig(config map[string]string) map[string]string {
+	result := make(map[string]string, len(config))
+	for key, value := range config {
+		result[key] = value
+	}
+	return result
+}
+
+// Record appends entry, made on the host topo describes. topo may be nil
+// when the host's layout is not known.
+func Record(path string, entry Entry, topo *topology.CPUTopology) error {
+	if topo != nil {
+		entry.Topology = topo.Fingerprint()
+	}
+	return Append(path, entry)
+}
@@ -0,0 +1,60 @@
+package journal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// history has VM 101 pinned with the vCPUs fixed, re-pinned with guest NUMA
+// and reset; VM 102's entry is in between.
+func history() []Entry {
+	return []Entry{
+		{Seq: 1, VMID: 101, Previous: "", Affinity: "0-7",
+			Config:         map[string]string{"sockets": "1", "cores": "8", "vcpus": ""},
+			PreviousConfig: map[string]string{"sockets": "2", "cores": "2", "vcpus": "4"}},
+		{Seq: 2, VMID: 102, Previous: "", Affinity: "8-9"},
+		{Seq: 3, VMID: 101, Previous: "0-7", Affinity: "16-23",
+			Config:         map[string]string{"numa": "1", "numa0": "cpus=0-7,hostnodes=1,memory=8192,policy=bind"},
+			PreviousConfig: map[string]string{"numa": "", "numa0": ""}},
+		{Seq: 4, VMID: 101, Previous: "16-23", Affinity: ""},
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		seq      int
+		affinity string
+		config   map[string]string
+	}{
+		{"latest", 0, "16-23", map[string]string{}},
+		{"with later settings", 1, "0-7", map[string]string{"sockets": "1", "cores": "8", "vcpus": "", "numa": "", "numa0": ""}},
+		{"own settings", 3, "16-23", map[string]string{"numa": "1", "numa0": "cpus=0-7,hostnodes=1,memory=8192,policy=bind"}},
+	}
+	for _, tt := range tests {
+		target, err := RollbackTarget(history(), 101, tt.seq)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if target.Affinity != tt.affinity || !reflect.DeepEqual(target.Config, tt.config) {
+			t.Errorf("%s: got %q with %v, want %q with %v", tt.name, target.Affinity, target.Config, tt.affinity, tt.config)
+		}
+	}
+
+	if _, err := RollbackTarget(history(), 101, 2); !errors.Is(err, ErrNoEntry) {
+		t.Errorf("entry of another VM: got %v, want %v", err, ErrNoEntry)
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	current := map[string]string{"affinity": "0-3", "cores": "4", "sockets": "1"}
+	entry := NewEntry(101, current, "0-7", map[string]string{"cores": "8", "vcpus": ""}, "single-ccd")
+	if entry.Previous != "0-3" || entry.Affinity != "0-7" {
+		t.Errorf("got %q → %q", entry.Previous, entry.Affinity)
+	}
+	want := map[string]string{"cores": "4", "vcpus": ""}
+	if !reflect.DeepEqual(entry.PreviousConfig, want) {
+		t.Errorf("PreviousConfig = %v, want %v", entry.PreviousConfig, want)
+	}
+}
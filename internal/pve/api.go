@@ -0,0 +1,352 @@
+package pve
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APITokenEnv names the environment variable the API token can be read
+// from, to keep it out of shell history.
+const APITokenEnv = "PVE_API_TOKEN"
+
+var ErrInvalidToken = errors.New("invalid API token")
+
+// APIBackend manages VMs through the Proxmox VE REST API
+// (/api2/json/nodes/{node}/qemu/...) with an API token, so it works from
+// any machine that reaches the cluster.
+type APIBackend struct {
+	// URL is the API root, e.g. https://pve1:8006.
+	URL string
+	// Token is "USER@REALM!TOKENID=SECRET".
+	Token string
+	// Node limits the backend to one node; empty reaches every node.
+	Node   string
+	Client *http.Client
+
+	// vmNodes caches which node each VM runs on, filled by ListVMs.
+	vmNodes map[int]string
+}
+
+// NewAPIBackend returns a backend for the API at rawURL. insecure skips TLS
+// verification, for the self-signed certificate a fresh node ships with.
+func NewAPIBackend(rawURL, token, node string, insecure bool) (*APIBackend, error) {
+	id, secret, ok := strings.Cut(token, "=")
+	if !ok || !strings.Contains(id, "!") || secret == "" {
+		return nil, fmt.Errorf("%w: expected USER@REALM!TOKENID=SECRET", ErrInvalidToken)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid API URL %q", rawURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &APIBackend{
+		URL:    strings.TrimSuffix(rawURL, "/"),
+		Token:  token,
+		Node:   node,
+		Client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// ListVMs lists the VMs of Node, or of every online node.
+func (b *APIBackend) ListVMs() ([]VM, error) {
+	nodes := []string{b.Node}
+	if b.Node == "" {
+		var err error
+		nodes, err = b.onlineNodes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var vms []VM
+	for _, node := range nodes {
+		nodeVMs, err := b.nodeVMs(node)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, nodeVMs...)
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		return vms[i].VMID < vms[j].VMID
+	})
+	return vms, nil
+}
+
+// LoadVM reads the VM's config and its pending changes.
+func (b *APIBackend) LoadVM(vmid int) (*VM, error) {
+	node, err := b.nodeOf(vmid)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]any
+	if err := b.get(vmPath(node, vmid)+"/config", &config); err != nil {
+		return nil, fmt.Errorf("VM %d: %w", vmid, err)
+	}
+
+	vm := &VM{VMID: vmid, Node: node, Config: newConfig()}
+	for key, raw := range config {
+		value := apiValue(raw)
+		switch key {
+		case "digest":
+			continue
+		case "description":
+			vm.Description = value
+			continue
+		case "name":
+			vm.Name = value
+		}
+		if err := vm.Config.set(key, value); err != nil {
+			vm.Problems = append(vm.Problems, err.Error())
+		}
+	}
+
+	var pending []struct {
+		Key     string `json:"key"`
+		Pending any    `json:"pending"`
+		Delete  int    `json:"delete"`
+	}
+	if err := b.get(vmPath(node, vmid)+"/pending", &pending); err != nil {
+		return nil, fmt.Errorf("VM %d: %w", vmid, err)
+	}
+	changes := newConfig()
+	var deleted []string
+	for _, entry := range pending {
+		switch {
+		case entry.Delete > 0:
+			deleted = append(deleted, entry.Key)
+		case entry.Pending != nil:
+			if err := changes.set(entry.Key, apiValue(entry.Pending)); err != nil {
+				vm.Problems = append(vm.Problems, "pending "+err.Error())
+			}
+		}
+	}
+	if len(deleted) > 0 {
+		changes.Raw["delete"] = strings.Join(deleted, ",")
+	}
+	if len(changes.Raw) > 0 {
+		vm.Pending = &changes
+	}
+	return vm, nil
+}
+
+// SetConfig updates the VM's config with the qm set style args.
+func (b *APIBackend) SetConfig(vmid int, args ...string) error {
+	if len(args)%2 != 0 {
+		return fmt.Errorf("odd number of config arguments: %v", args)
+	}
+	node, err := b.nodeOf(vmid)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	for i := 0; i < len(args); i += 2 {
+		key := strings.TrimPrefix(args[i], "--")
+		if key == "delete" && form.Has("delete") {
+			form.Set("delete", form.Get("delete")+","+args[i+1])
+			continue
+		}
+		form.Set(key, args[i+1])
+	}
+	return b.do(http.MethodPut, vmPath(node, vmid)+"/config", form, nil)
+}
+
+// Affinities reads the affinity of every VM on vmid's node, or on Node for
+// vmid 0. Without either it returns none, since VMs on other nodes share
+// no CPUs.
+func (b *APIBackend) Affinities(vmid int) (map[int]string, error) {
+	node := b.Node
+	if vmid > 0 {
+		var err error
+		node, err = b.nodeOf(vmid)
+		if errors.Is(err, ErrVMNotFound) {
+			// Reported by whoever looks the VM up itself.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if node == "" {
+		return nil, nil
+	}
+
+	vms, err := b.nodeVMs(node)
+	if err != nil {
+		return nil, err
+	}
+	affinities := make(map[int]string)
+	for _, vm := range vms {
+		var config map[string]any
+		if err := b.get(vmPath(node, vm.VMID)+"/config", &config); err != nil {
+			return nil, fmt.Errorf("VM %d: %w", vm.VMID, err)
+		}
+		if value, ok := config["affinity"]; ok {
+			affinities[vm.VMID] = apiValue(value)
+		}
+	}
+	return affinities, nil
+}
+
+func (b *APIBackend) onlineNodes() ([]string, error) {
+	var nodes []struct {
+		Node   string `json:"node"`
+		Status string `json:"status"`
+	}
+	if err := b.get("/nodes", &nodes); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, node := range nodes {
+		if node.Status == "online" {
+			names = append(names, node.Node)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *APIBackend) nodeVMs(node string) ([]VM, error) {
+	var list []struct {
+		VMID   int    `json:"vmid"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	if err := b.get("/nodes/"+url.PathEscape(node)+"/qemu", &list); err != nil {
+		return nil, fmt.Errorf("node %s: %w", node, err)
+	}
+	if b.vmNodes == nil {
+		b.vmNodes = make(map[int]string)
+	}
+	vms := make([]VM, 0, len(list))
+	for _, entry := range list {
+		b.vmNodes[entry.VMID] = node
+		vms = append(vms, VM{VMID: entry.VMID, Name: entry.Name, Status: entry.Status, Node: node})
+	}
+	return vms, nil
+}
+
+// nodeOf returns the node vmid is on, listing VMs if it is not known yet.
+func (b *APIBackend) nodeOf(vmid int) (string, error) {
+	if node, ok := b.vmNodes[vmid]; ok {
+		return node, nil
+	}
+	if b.Node != "" {
+		return b.Node, nil
+	}
+	if _, err := b.ListVMs(); err != nil {
+		return "", err
+	}
+	if node, ok := b.vmNodes[vmid]; ok {
+		return node, nil
+	}
+	return "", fmt.Errorf("%w: %d", ErrVMNotFound, vmid)
+}
+
+func (b *APIBackend) get(path string, data any) error {
+	return b.do(http.MethodGet, path, nil, data)
+}
+
+// do sends a request to /api2/json<path> and decodes the "data" member of
+// the response into data, if given.
+func (b *APIBackend) do(method, path string, form url.Values, data any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, b.URL+"/api2/json"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+b.Token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message := apiErrorMessage(resp)
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("%w: %s", ErrPermissionDenied, message)
+		case resp.StatusCode == http.StatusNotFound || strings.Contains(message, "does not exist"):
+			return fmt.Errorf("%w: %s", ErrVMNotFound, message)
+		default:
+			return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, message)
+		}
+	}
+	if data == nil {
+		return nil
+	}
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: data}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// apiErrorMessage returns why a request failed. Proxmox puts the message in
+// the status line and parameter errors in the body's "errors" member.
+func apiErrorMessage(resp *http.Response) string {
+	message := strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+	var body struct {
+		Message string            `json:"message"`
+		Errors  map[string]string `json:"errors"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) != nil {
+		return message
+	}
+	if body.Message != "" {
+		message = strings.TrimSpace(body.Message)
+	}
+	keys := make([]string, 0, len(body.Errors))
+	for key := range body.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		message += fmt.Sprintf("; %s: %s", key, strings.TrimSpace(body.Errors[key]))
+	}
+	return message
+}
+
+func vmPath(node string, vmid int) string {
+	return "/nodes/" + url.PathEscape(node) + "/qemu/" + strconv.Itoa(vmid)
+}
+
+// apiValue renders a config value the API returned as JSON the way it
+// appears in the config file.
+func apiValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,175 @@
+package pve
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"epyc-pve/internal/pve/pvetest"
+)
+
+const testToken = "root@pam!test=00000000-0000-0000-0000-000000000000"
+
+func newTestBackend(t *testing.T, url, token, node string) *APIBackend {
+	t.Helper()
+	b, err := NewAPIBackend(url, token, node, false)
+	if err != nil {
+		t.Fatalf("NewAPIBackend: %v", err)
+	}
+	return b
+}
+
+func TestAPIListVMsAcrossNodes(t *testing.T) {
+	srv := pvetest.NewServer(testToken)
+	defer srv.Close()
+	srv.AddVM("pve2", 201, "db", "running", map[string]string{"cores": "4"})
+	srv.AddVM("pve1", 101, "web", "stopped", map[string]string{"cores": "2"})
+	srv.AddVM("pve1", 102, "cache", "running", map[string]string{"cores": "2"})
+
+	vms, err := newTestBackend(t, srv.URL, testToken, "").ListVMs()
+	if err != nil {
+		t.Fatalf("ListVMs: %v", err)
+	}
+	want := []struct {
+		vmid int
+		node string
+	}{{101, "pve1"}, {102, "pve1"}, {201, "pve2"}}
+	if len(vms) != len(want) {
+		t.Fatalf("got %d VMs, want %d", len(vms), len(want))
+	}
+	for i, w := range want {
+		if vms[i].VMID != w.vmid || vms[i].Node != w.node {
+			t.Errorf("VM %d: got %d on %s, want %d on %s", i, vms[i].VMID, vms[i].Node, w.vmid, w.node)
+		}
+	}
+
+	vms, err = newTestBackend(t, srv.URL, testToken, "pve2").ListVMs()
+	if err != nil {
+		t.Fatalf("ListVMs on pve2: %v", err)
+	}
+	if len(vms) != 1 || vms[0].VMID != 201 {
+		t.Errorf("ListVMs on pve2: got %v, want only VM 201", vms)
+	}
+}
+
+func TestAPILoadVMWithPending(t *testing.T) {
+	srv := pvetest.NewServer(testToken)
+	defer srv.Close()
+	srv.AddVM("pve1", 101, "web", "running", map[string]string{
+		"cores":    "4",
+		"sockets":  "1",
+		"vcpus":    "2",
+		"memory":   "4096",
+		"affinity": "0-3",
+	})
+	srv.AddPending(101, "cores", "8")
+	srv.AddPending(101, "vcpus", "")
+
+	vm, err := newTestBackend(t, srv.URL, testToken, "").LoadVM(101)
+	if err != nil {
+		t.Fatalf("LoadVM: %v", err)
+	}
+	if vm.Node != "pve1" || vm.Cores != 4 || vm.Memory != 4096 || vm.Affinity != "0-3" {
+		t.Errorf("got node %s, cores %d, memory %d, affinity %q", vm.Node, vm.Cores, vm.Memory, vm.Affinity)
+	}
+	if vm.Pending == nil {
+		t.Fatal("pending changes missing")
+	}
+	if vm.Pending.Cores != 8 || vm.Pending.Raw["delete"] != "vcpus" {
+		t.Errorf("got pending cores %d, delete %q", vm.Pending.Cores, vm.Pending.Raw["delete"])
+	}
+	if got := vm.NextStartVCPUs(); got != 8 {
+		t.Errorf("NextStartVCPUs = %d, want 8", got)
+	}
+}
+
+func TestAPISetConfigMergesDeletes(t *testing.T) {
+	srv := pvetest.NewServer(testToken)
+	defer srv.Close()
+	srv.AddVM("pve1", 101, "web", "stopped", map[string]string{
+		"cores":      "4",
+		"affinity":   "0-3",
+		"hookscript": "local:snippets/pin.sh",
+	})
+
+	b := newTestBackend(t, srv.URL, testToken, "")
+	if err := b.SetConfig(101, "--delete", "affinity", "--delete", "hookscript", "--cores", "8"); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	config := srv.Config(101)
+	if _, ok := config["affinity"]; ok {
+		t.Error("affinity not deleted")
+	}
+	if _, ok := config["hookscript"]; ok {
+		t.Error("hookscript not deleted")
+	}
+	if config["cores"] != "8" {
+		t.Errorf("cores = %q, want 8", config["cores"])
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	srv := pvetest.NewServer(testToken)
+	defer srv.Close()
+	srv.AddVM("pve1", 101, "web", "stopped", map[string]string{"cores": "4"})
+
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"data":null}`, http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{
+			name: "401 wrong token",
+			call: func() error {
+				_, err := newTestBackend(t, srv.URL, "root@pam!test=wrong", "").ListVMs()
+				return err
+			},
+			want: ErrPermissionDenied,
+		},
+		{
+			name: "403",
+			call: func() error {
+				_, err := newTestBackend(t, forbidden.URL, testToken, "pve1").LoadVM(101)
+				return err
+			},
+			want: ErrPermissionDenied,
+		},
+		{
+			name: "404",
+			call: func() error {
+				_, err := newTestBackend(t, missing.URL, testToken, "pve1").LoadVM(101)
+				return err
+			},
+			want: ErrVMNotFound,
+		},
+		{
+			name: "unknown VM",
+			call: func() error {
+				_, err := newTestBackend(t, srv.URL, testToken, "").LoadVM(999)
+				return err
+			},
+			want: ErrVMNotFound,
+		},
+		{
+			name: "VM on another node",
+			call: func() error {
+				_, err := newTestBackend(t, srv.URL, testToken, "pve2").LoadVM(101)
+				return err
+			},
+			want: ErrVMNotFound,
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
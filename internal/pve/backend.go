@@ -0,0 +1,54 @@
+package pve
+
+// Backend reads and changes VM configs. CLIBackend uses qm and
+// /etc/pve on the node itself; APIBackend uses the REST API and works from
+// any machine that can reach the cluster.
+type Backend interface {
+	// ListVMs returns the VMs the backend can reach, sorted by VMID.
+	ListVMs() ([]VM, error)
+	// LoadVM reads a VM's current config and pending changes.
+	LoadVM(vmid int) (*VM, error)
+	// SetConfig changes a VM's config. args are in qm set form, pairs of
+	// "--key" and value, e.g. "--affinity", "0-7" or "--delete", "affinity".
+	SetConfig(vmid int, args ...string) error
+	// Affinities returns the affinity of every VM on the same node as vmid
+	// that has one, keyed by VMID.
+	Affinities(vmid int) (map[int]string, error)
+}
+
+// CLIBackend manages the VMs of the local node through qm and the config
+// files under ConfigDir. It needs root.
+type CLIBackend struct{}
+
+var active Backend = CLIBackend{}
+
+// UseBackend makes the package functions (ListVMs, LoadVM, ChangeConfig, ...)
+// go through b.
+func UseBackend(b Backend) {
+	active = b
+}
+
+// IsLocal reports whether the active backend manages the local node, so
+// running VMs can be pinned live and snippets written.
+func IsLocal() bool {
+	_, ok := active.(CLIBackend)
+	return ok
+}
+
+// LoadVM reads a VM's config with the active backend.
+func LoadVM(vmid int) (*VM, error) {
+	return active.LoadVM(vmid)
+}
+
+// Affinities returns the affinities of VMs sharing a node with vmid.
+func Affinities(vmid int) (map[int]string, error) {
+	return active.Affinities(vmid)
+}
+
+func (CLIBackend) LoadVM(vmid int) (*VM, error) {
+	return ReadVM(ConfigDir, vmid)
+}
+
+func (CLIBackend) Affinities(int) (map[int]string, error) {
+	return ReadAffinities(ConfigDir)
+}
@@ -11,16 +11,31 @@ import (
 	"strings"
 )
 
+// VM is a guest. ListVMs fills VMID, Name, Status and, with the API
+// backend, Node; the config readers fill the rest.
 type VM struct {
 	VMID   int
 	Name   string
 	Status string
+	Node   string
+	Config
+	Pending   *Config
+	Snapshots []Snapshot
+	// Problems lists settings that could not be parsed. Their typed fields
+	// are left unset and the values stay in Raw.
+	Problems []string
 }
 
 var ErrPermissionDenied = errors.New("permission denied")
 var ErrVMNotFound = errors.New("vm not found")
 
+// ListVMs lists the VMs the active backend can reach.
 func ListVMs() ([]VM, error) {
+	return active.ListVMs()
+}
+
+// ListVMs runs qm list.
+func (CLIBackend) ListVMs() ([]VM, error) {
 	cmd := exec.Command("qm", "list")
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -63,18 +78,85 @@ func ListVMs() ([]VM, error) {
 	return vms, nil
 }
 
-func SetAffinity(vmid int, affinity string, dryRun bool) error {
+// ChangeConfig applies settings to the VM in one qm set, so they are
+// changed all together or not at all. An empty value deletes the setting.
+func ChangeConfig(vmid int, settings map[string]string) error {
 	if vmid <= 0 {
 		return errors.New("vmid must be greater than zero")
 	}
-	if strings.TrimSpace(affinity) == "" {
-		return errors.New("affinity string is empty")
+	if len(settings) == 0 {
+		return nil
+	}
+	return active.SetConfig(vmid, ConfigArgs(settings)...)
+}
+
+// ConfigArgs returns the qm set arguments for settings, in key order with
+// the deletions last.
+func ConfigArgs(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var args, deleted []string
+	for _, key := range keys {
+		if settings[key] == "" {
+			deleted = append(deleted, key)
+			continue
+		}
+		args = append(args, "--"+key, settings[key])
+	}
+	if len(deleted) > 0 {
+		args = append(args, "--delete", strings.Join(deleted, ","))
+	}
+	return args
+}
+
+// ClearAffinity deletes the VM's affinity, and the hookscript and its plan
+// if this tool installed them, so QEMU may run on every host CPU from the
+// next start.
+func ClearAffinity(vm *VM, dryRun bool) error {
+	if vm.VMID <= 0 {
+		return errors.New("vmid must be greater than zero")
 	}
 	if dryRun {
 		return nil
 	}
 
-	cmd := exec.Command("qm", "set", strconv.Itoa(vmid), "--affinity", affinity)
+	if err := active.SetConfig(vm.VMID, ClearAffinityArgs(vm)...); err != nil {
+		return err
+	}
+	return RemoveHookPlan(vm)
+}
+
+// ClearAffinityArgs returns the qm set arguments ClearAffinity uses.
+func ClearAffinityArgs(vm *VM) []string {
+	keys := "affinity"
+	if IsOwnHookscript(vm.Hookscript) {
+		keys += ",hookscript"
+	}
+	return []string{"--delete", keys}
+}
+
+// NUMASettings enables guest NUMA and sets numa0..numaN to the given
+// values, e.g. "cpus=0-7,hostnodes=0,memory=16384,policy=bind".
+func NUMASettings(nodes []string) map[string]string {
+	settings := map[string]string{"numa": "1"}
+	for i, node := range nodes {
+		settings[fmt.Sprintf("numa%d", i)] = node
+	}
+	return settings
+}
+
+// QMSetCommand renders a qm set invocation, for dry runs.
+func QMSetCommand(vmid int, args ...string) string {
+	return "qm " + strings.Join(append([]string{"set", strconv.Itoa(vmid)}, args...), " ")
+}
+
+// SetConfig runs qm set.
+func (CLIBackend) SetConfig(vmid int, args ...string) error {
+	cmd := exec.Command("qm", append([]string{"set", strconv.Itoa(vmid)}, args...)...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
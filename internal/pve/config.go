@@ -0,0 +1,308 @@
+package pve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigDir holds the local node's VM configs.
+const ConfigDir = "/etc/pve/qemu-server"
+
+// PendingSection is the section holding changes not yet applied to a
+// running VM.
+const PendingSection = "PENDING"
+
+// Config is one section of a VM config: the current settings, a snapshot or
+// the pending changes. Settings the tool does not model are kept in Raw.
+type Config struct {
+	Cores       int
+	Sockets     int
+	VCPUs       int
+	NUMA        bool
+	NUMANodes   map[int]string
+	Affinity    string
+	CPU         string
+	CPULimit    float64
+	CPUUnits    int
+	Hookscript  string
+	Tags        []string
+	Memory      int
+	Description string
+	Raw         map[string]string
+}
+
+// Snapshot is a named snapshot section of a VM config.
+type Snapshot struct {
+	Name     string
+	Parent   string
+	SnapTime int64
+	Config   Config
+}
+
+// VCPUCount returns the number of vCPUs the guest runs with: vcpus when
+// set, otherwise sockets*cores (each defaulting to 1).
+func (c *Config) VCPUCount() int {
+	if c.VCPUs > 0 {
+		return c.VCPUs
+	}
+	return c.MaxVCPUs()
+}
+
+// MaxVCPUs returns sockets*cores, the hot-pluggable maximum.
+func (c *Config) MaxVCPUs() int {
+	sockets := c.Sockets
+	if sockets <= 0 {
+		sockets = 1
+	}
+	cores := c.Cores
+	if cores <= 0 {
+		cores = 1
+	}
+	return sockets * cores
+}
+
+// ConfigPath returns the config file of vmid in dir.
+func ConfigPath(dir string, vmid int) string {
+	return filepath.Join(dir, strconv.Itoa(vmid)+".conf")
+}
+
+// ReadVM reads <vmid>.conf from dir.
+func ReadVM(dir string, vmid int) (*VM, error) {
+	f, err := os.Open(ConfigPath(dir, vmid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %d", ErrVMNotFound, vmid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vm, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("VM %d: %w", vmid, err)
+	}
+	vm.VMID = vmid
+	return vm, nil
+}
+
+// ReadVMs reads every VM config in dir, sorted by VMID. A config that
+// cannot be read does not stop the others: its VM is listed with the error
+// in Problems.
+func ReadVMs(dir string) ([]VM, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	vms := make([]VM, 0, len(paths))
+	for _, path := range paths {
+		vmid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".conf"))
+		if err != nil {
+			continue
+		}
+		vm, err := ReadVM(dir, vmid)
+		if err != nil {
+			vm = &VM{VMID: vmid, Config: newConfig(), Problems: []string{err.Error()}}
+		}
+		vms = append(vms, *vm)
+	}
+
+	sort.Slice(vms, func(i, j int) bool {
+		return vms[i].VMID < vms[j].VMID
+	})
+	return vms, nil
+}
+
+// ReadAffinities returns the affinity of every VM in dir that has one, keyed
+// by VMID. Only the current config counts; snapshot sections are ignored.
+func ReadAffinities(dir string) (map[int]string, error) {
+	vms, err := ReadVMs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	affinities := make(map[int]string)
+	for _, vm := range vms {
+		if vm.Affinity != "" {
+			affinities[vm.VMID] = vm.Affinity
+		}
+	}
+	return affinities, nil
+}
+
+var (
+	configLinePattern    = regexp.MustCompile(`^([a-z][a-z_-]*\d*):\s*(.*?)\s*$`)
+	configSectionPattern = regexp.MustCompile(`^\[([^\]]+)\]\s*$`)
+)
+
+// ParseConfig parses a qemu-server config in the format written by Proxmox:
+// "key: value" lines, "#" description lines, then one "[name]" section per
+// snapshot and optionally a "[PENDING]" section. Lines that are not
+// settings are skipped, as Proxmox itself does, and values that do not
+// parse are recorded in Problems.
+func ParseConfig(r io.Reader) (*VM, error) {
+	vm := &VM{Config: newConfig()}
+	current := &vm.Config
+	var snapshot *Snapshot
+	var description []string
+	section := "current config"
+
+	finish := func() {
+		if len(description) > 0 {
+			current.Description = strings.Join(description, "\n")
+			description = nil
+		}
+		if snapshot != nil {
+			vm.Snapshots = append(vm.Snapshots, *snapshot)
+			snapshot = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if m := configSectionPattern.FindStringSubmatch(line); m != nil {
+			finish()
+			name := m[1]
+			section = "[" + name + "]"
+			switch {
+			case name == PendingSection:
+				pending := newConfig()
+				vm.Pending = &pending
+				current = vm.Pending
+			case strings.HasPrefix(name, "special:"):
+				// Cloud-init state and similar, not VM settings.
+				ignored := newConfig()
+				current = &ignored
+			default:
+				snapshot = &Snapshot{Name: name, Config: newConfig()}
+				current = &snapshot.Config
+			}
+			continue
+		}
+
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			if text, err := url.PathUnescape(comment); err == nil {
+				comment = text
+			}
+			description = append(description, comment)
+			continue
+		}
+
+		m := configLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key, value := m[1], m[2]
+
+		if snapshot != nil {
+			switch key {
+			case "parent":
+				snapshot.Parent = value
+				continue
+			case "snaptime":
+				t, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					vm.Problems = append(vm.Problems, fmt.Sprintf("line %d in %s: snaptime %q", lineNo, section, value))
+				}
+				snapshot.SnapTime = t
+				continue
+			}
+		}
+		if current == &vm.Config && key == "name" {
+			vm.Name = value
+		}
+		if err := current.set(key, value); err != nil {
+			vm.Problems = append(vm.Problems, fmt.Sprintf("line %d in %s: %v", lineNo, section, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+
+	return vm, nil
+}
+
+func newConfig() Config {
+	return Config{Raw: make(map[string]string), NUMANodes: make(map[int]string)}
+}
+
+// set stores one setting. The value is always kept in Raw; when a typed
+// field does not parse it is left at zero and the error says which.
+func (c *Config) set(key, value string) error {
+	c.Raw[key] = value
+
+	var err error
+	switch key {
+	case "cores":
+		c.Cores, err = strconv.Atoi(value)
+	case "sockets":
+		c.Sockets, err = strconv.Atoi(value)
+	case "vcpus":
+		c.VCPUs, err = strconv.Atoi(value)
+	case "numa":
+		c.NUMA = value == "1"
+	case "affinity":
+		c.Affinity = value
+	case "cpu":
+		c.CPU = value
+	case "cpulimit":
+		c.CPULimit, err = strconv.ParseFloat(value, 64)
+	case "cpuunits":
+		c.CPUUnits, err = strconv.Atoi(value)
+	case "hookscript":
+		c.Hookscript = value
+	case "tags":
+		c.Tags = splitTags(value)
+	case "memory":
+		c.Memory, err = parseMemory(value)
+	default:
+		if n, ok := strings.CutPrefix(key, "numa"); ok {
+			id, convErr := strconv.Atoi(n)
+			if convErr == nil {
+				c.NUMANodes[id] = value
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s %q", key, value)
+	}
+	return nil
+}
+
+// parseMemory reads a memory setting, either a plain size in MiB or the
+// property string "current=<MiB>" newer Proxmox versions write.
+func parseMemory(value string) (int, error) {
+	for _, part := range strings.Split(value, ",") {
+		if !strings.Contains(part, "=") {
+			return strconv.Atoi(part)
+		}
+		if size, ok := strings.CutPrefix(part, "current="); ok {
+			return strconv.Atoi(size)
+		}
+	}
+	return 0, fmt.Errorf("no current size in %q", value)
+}
+
+// splitTags splits a tags value; Proxmox accepts ';', ',' and spaces.
+func splitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,81 @@
+package pve
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigLenient(t *testing.T) {
+	vm, err := ParseConfig(strings.NewReader(`cores: 4
+memory: current=8192
+sockets: two
+cpuunits: 100
+affinity: 0-3
+
+[PENDING]
+vcpus: many
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if vm.Cores != 4 || vm.Memory != 8192 || vm.CPUUnits != 100 || vm.Affinity != "0-3" {
+		t.Errorf("got cores %d, memory %d, cpuunits %d, affinity %q", vm.Cores, vm.Memory, vm.CPUUnits, vm.Affinity)
+	}
+	if vm.Sockets != 0 || vm.Raw["sockets"] != "two" {
+		t.Errorf("got sockets %d, raw %q; want 0 and the raw value kept", vm.Sockets, vm.Raw["sockets"])
+	}
+	if len(vm.Problems) != 2 {
+		t.Fatalf("got problems %q, want sockets and pending vcpus", vm.Problems)
+	}
+	if !strings.Contains(vm.Problems[1], "[PENDING]") {
+		t.Errorf("problem %q does not name the section", vm.Problems[1])
+	}
+}
+
+func TestReadVMsKeepsGoing(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("100.conf", "cores: 2\naffinity: 0-1\n")
+	write("101.conf", "cores: 2\nmemory: lots\naffinity: 2-3\n")
+	write("102.conf", "cores: 1\n")
+
+	vms, err := ReadVMs(dir)
+	if err != nil {
+		t.Fatalf("ReadVMs: %v", err)
+	}
+	if len(vms) != 3 {
+		t.Fatalf("got %d VMs, want 3", len(vms))
+	}
+	if len(vms[1].Problems) != 1 || vms[1].Affinity != "2-3" {
+		t.Errorf("VM 101: got problems %q, affinity %q", vms[1].Problems, vms[1].Affinity)
+	}
+
+	affinities, err := ReadAffinities(dir)
+	if err != nil {
+		t.Fatalf("ReadAffinities: %v", err)
+	}
+	if len(affinities) != 2 {
+		t.Errorf("got affinities %v, want VMs 100 and 101", affinities)
+	}
+}
+
+func TestConfigArgs(t *testing.T) {
+	settings := map[string]string{
+		"affinity": "0-7",
+		"sockets":  "1",
+		"cores":    "8",
+		"vcpus":    "",
+		"numa1":    "",
+	}
+	want := []string{"--affinity", "0-7", "--cores", "8", "--sockets", "1", "--delete", "numa1,vcpus"}
+	if got := ConfigArgs(settings); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
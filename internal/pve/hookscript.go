@@ -0,0 +1,337 @@
+package pve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HookScriptName is the snippet every pinned VM shares; the per-VM plan sits
+// next to it.
+const HookScriptName = "proxmox-affinity-hook.sh"
+
+var ErrHookscriptInUse = errors.New("vm already has another hookscript")
+
+// HookPlan is the sidecar file the hookscript reads at post-start. CPU sets
+// are in affinity list format ("0-7,64-71").
+type HookPlan struct {
+	VMID int
+	// VCPUs holds every vCPU thread unless VCPUMap is set.
+	VCPUs string
+	// VCPUMap pins vCPU thread N to host CPU VCPUMap[N].
+	VCPUMap []int
+	// Emulator holds the other QEMU threads; empty means VCPUs.
+	Emulator string
+	// PCIDevices are passed-through devices whose interrupts are steered
+	// to the emulator CPUs, or the vCPU set without them.
+	PCIDevices []string
+}
+
+// PlanFileName returns the name of the sidecar plan for vmid.
+func PlanFileName(vmid int) string {
+	return fmt.Sprintf("proxmox-affinity-%d.plan", vmid)
+}
+
+// Render writes the plan in the line format the hookscript parses.
+func (p *HookPlan) Render() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# proxmox-affinity pin plan for VM %d\n", p.VMID)
+	fmt.Fprintf(&b, "vcpus %s\n", p.VCPUs)
+	for i, cpu := range p.VCPUMap {
+		fmt.Fprintf(&b, "vcpu %d %d\n", i, cpu)
+	}
+	if p.Emulator != "" {
+		fmt.Fprintf(&b, "emulator %s\n", p.Emulator)
+	}
+	irqCPUs := p.Emulator
+	if irqCPUs == "" {
+		irqCPUs = p.VCPUs
+	}
+	for _, dev := range p.PCIDevices {
+		fmt.Fprintf(&b, "irq %s %s\n", dev, irqCPUs)
+	}
+	return b.String()
+}
+
+// PCIDevices returns the host PCI addresses of the VM's hostpciN entries,
+// with the domain filled in. Addresses without a function ("01:00") stand
+// for all functions of the device. Resource mappings are skipped since
+// their address differs per node.
+func PCIDevices(vm *VM) []string {
+	var keys []string
+	for key := range vm.Raw {
+		if strings.HasPrefix(key, "hostpci") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var devices []string
+	for _, key := range keys {
+		first, _, _ := strings.Cut(vm.Raw[key], ",")
+		if strings.HasPrefix(first, "mapping=") {
+			continue
+		}
+		first = strings.TrimPrefix(first, "host=")
+		for _, dev := range strings.Split(first, ";") {
+			if strings.Count(dev, ":") == 1 {
+				dev = "0000:" + dev
+			}
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// HookScript returns the hookscript. It only needs a POSIX shell and
+// taskset, so it works on nodes without this tool, and applying it again
+// leaves the same pinning.
+func HookScript() string {
+	return hookScript
+}
+
+const hookScript = `#!/bin/sh
+# Installed by proxmox-affinity. Applies the per-thread pinning stored in
+# proxmox-affinity-<vmid>.plan next to this script when the VM starts.
+vmid="$1"
+phase="$2"
+
+[ "$phase" = "post-start" ] || exit 0
+
+plan="$(dirname "$0")/proxmox-affinity-${vmid}.plan"
+[ -r "$plan" ] || exit 0
+
+pid="$(cat "/var/run/qemu-server/${vmid}.pid" 2>/dev/null)"
+[ -n "$pid" ] && [ -d "/proc/${pid}" ] || exit 0
+
+vcpus=""
+emulator=""
+while read -r kind a b; do
+	case "$kind" in
+	vcpus) vcpus="$a" ;;
+	emulator) emulator="$a" ;;
+	esac
+done < "$plan"
+[ -n "$vcpus" ] || exit 0
+[ -n "$emulator" ] || emulator="$vcpus"
+
+vcpu_cpu() {
+	while read -r kind a b; do
+		if [ "$kind" = "vcpu" ] && [ "$a" = "$1" ]; then
+			echo "$b"
+			return
+		fi
+	done < "$plan"
+	echo "$vcpus"
+}
+
+for task in /proc/"${pid}"/task/*; do
+	tid="${task##*/}"
+	comm="$(cat "${task}/comm" 2>/dev/null)" || continue
+	case "$comm" in
+	"CPU "*/KVM)
+		n="${comm#CPU }"
+		n="${n%/KVM}"
+		cpus="$(vcpu_cpu "$n")"
+		;;
+	*)
+		cpus="$emulator"
+		;;
+	esac
+	taskset -c -p "$cpus" "$tid" >/dev/null 2>&1 || true
+done
+
+while read -r kind dev cpus; do
+	[ "$kind" = "irq" ] || continue
+	for devdir in /sys/bus/pci/devices/"${dev}"*; do
+		[ -d "$devdir" ] || continue
+		irqs="$(cat "${devdir}/irq" 2>/dev/null)"
+		if [ -d "${devdir}/msi_irqs" ]; then
+			irqs="$irqs $(ls "${devdir}/msi_irqs")"
+		fi
+		for irq in $irqs; do
+			[ "$irq" != "0" ] || continue
+			echo "$cpus" > "/proc/irq/${irq}/smp_affinity_list" 2>/dev/null || true
+		done
+	done
+done < "$plan"
+
+exit 0
+`
+
+// SnippetVolume returns the volume ID of a snippet on storage.
+func SnippetVolume(storage, name string) string {
+	return storage + ":snippets/" + name
+}
+
+// SnippetPath resolves a snippet volume to its file path with pvesm.
+func SnippetPath(storage, name string) (string, error) {
+	cmd := exec.Command("pvesm", "path", SnippetVolume(storage, name))
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", wrapCommandError(err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// WriteHookFiles writes the hookscript and the VM's plan into dir. The
+// script is only rewritten when its content changed.
+func WriteHookFiles(dir string, plan *HookPlan) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	script := filepath.Join(dir, HookScriptName)
+	if current, err := os.ReadFile(script); err != nil || string(current) != hookScript {
+		if err := os.WriteFile(script, []byte(hookScript), 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, PlanFileName(plan.VMID)), []byte(plan.Render()), 0o644)
+}
+
+// IsOwnHookscript reports whether a hookscript volume is the one this tool
+// installs, on any storage.
+func IsOwnHookscript(volume string) bool {
+	return strings.HasSuffix(volume, ":snippets/"+HookScriptName)
+}
+
+// CheckHookscript refuses to replace a hookscript this tool did not
+// install.
+func CheckHookscript(vm *VM) error {
+	if vm.Hookscript == "" || IsOwnHookscript(vm.Hookscript) {
+		return nil
+	}
+	return fmt.Errorf("%w: VM %d uses %s", ErrHookscriptInUse, vm.VMID, vm.Hookscript)
+}
+
+// HookStorage returns the storage of the VM's hookscript if this tool
+// installed it, or "".
+func HookStorage(vm *VM) string {
+	if !IsOwnHookscript(vm.Hookscript) {
+		return ""
+	}
+	storage, _, _ := strings.Cut(vm.Hookscript, ":")
+	return storage
+}
+
+// InstallHookscript writes the hookscript and plan into the snippets
+// directory of storage and attaches the script to the VM.
+func InstallHookscript(vm *VM, storage string, plan *HookPlan, dryRun bool) error {
+	if err := CheckHookscript(vm); err != nil {
+		return err
+	}
+	if err := CheckSnippetStorage(storage); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if err := writeSnippets(storage, plan); err != nil {
+		return err
+	}
+	return active.SetConfig(vm.VMID, "--hookscript", SnippetVolume(storage, HookScriptName))
+}
+
+func writeSnippets(storage string, plan *HookPlan) error {
+	path, err := SnippetPath(storage, HookScriptName)
+	if err != nil {
+		return err
+	}
+	return WriteHookFiles(filepath.Dir(path), plan)
+}
+
+// RefreshHookscript keeps the hookscript this tool installed in step with a
+// new affinity, since at every start it re-pins the VM's threads to what its
+// plan says. On the local node the plan is rewritten; through the API the
+// snippets cannot be reached, so the script is detached instead. It reports
+// whether it detached the script.
+func RefreshHookscript(vm *VM, plan *HookPlan, dryRun bool) (bool, error) {
+	storage := HookStorage(vm)
+	if storage == "" {
+		return false, nil
+	}
+	if !IsLocal() {
+		return true, DetachHookscript(vm, dryRun)
+	}
+	if dryRun {
+		return false, nil
+	}
+	return false, writeSnippets(storage, plan)
+}
+
+// DetachHookscript detaches the hookscript this tool installed and deletes
+// the VM's plan, so the next start keeps to the config's affinity.
+func DetachHookscript(vm *VM, dryRun bool) error {
+	if HookStorage(vm) == "" || dryRun {
+		return nil
+	}
+	if err := active.SetConfig(vm.VMID, "--delete", "hookscript"); err != nil {
+		return err
+	}
+	return RemoveHookPlan(vm)
+}
+
+// RemoveHookPlan deletes the VM's plan next to the hookscript this tool
+// installed. Only the local node's snippets can be reached; elsewhere the
+// plan is left for the detached script, which no longer reads it.
+func RemoveHookPlan(vm *VM) error {
+	storage := HookStorage(vm)
+	if storage == "" || !IsLocal() {
+		return nil
+	}
+	path, err := SnippetPath(storage, PlanFileName(vm.VMID))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// HookscriptCommands lists what InstallHookscript does, for dry runs.
+func HookscriptCommands(vmid int, storage string) []string {
+	volume := SnippetVolume(storage, HookScriptName)
+	return []string{
+		fmt.Sprintf("write %s and %s:snippets/%s", volume, storage, PlanFileName(vmid)),
+		QMSetCommand(vmid, "--hookscript", volume),
+	}
+}
+
+// RefreshHookscriptCommands lists what RefreshHookscript does, for dry runs.
+func RefreshHookscriptCommands(vm *VM) []string {
+	storage := HookStorage(vm)
+	switch {
+	case storage == "":
+		return nil
+	case !IsLocal():
+		return DetachHookscriptCommands(vm)
+	default:
+		return []string{fmt.Sprintf("write %s:snippets/%s", storage, PlanFileName(vm.VMID))}
+	}
+}
+
+// DetachHookscriptCommands lists what DetachHookscript does, for dry runs.
+func DetachHookscriptCommands(vm *VM) []string {
+	if HookStorage(vm) == "" {
+		return nil
+	}
+	return append([]string{QMSetCommand(vm.VMID, "--delete", "hookscript")}, RemoveHookPlanCommands(vm)...)
+}
+
+// RemoveHookPlanCommands lists what RemoveHookPlan does, for dry runs.
+func RemoveHookPlanCommands(vm *VM) []string {
+	storage := HookStorage(vm)
+	if storage == "" || !IsLocal() {
+		return nil
+	}
+	return []string{fmt.Sprintf("remove %s:snippets/%s", storage, PlanFileName(vm.VMID))}
+}
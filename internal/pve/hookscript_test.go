@@ -0,0 +1,95 @@
+package pve
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"epyc-pve/internal/pve/pvetest"
+)
+
+// fakeNode puts qm and pvesm stand-ins on PATH: qm logs its arguments and
+// pvesm resolves snippets into the returned directory.
+func fakeNode(t *testing.T) (snippets string, qmLog string) {
+	t.Helper()
+	bin := t.TempDir()
+	snippets = t.TempDir()
+	qmLog = filepath.Join(bin, "qm.log")
+	scripts := map[string]string{
+		"qm":    "#!/bin/sh\necho \"$@\" >> " + qmLog + "\n",
+		"pvesm": "#!/bin/sh\necho " + snippets + "/\"${2#*:snippets/}\"\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return snippets, qmLog
+}
+
+func hookedVM() *VM {
+	vm := &VM{VMID: 101, Config: newConfig()}
+	vm.Affinity = "0-3"
+	vm.Hookscript = SnippetVolume("shared", HookScriptName)
+	return vm
+}
+
+func TestRefreshHookscriptRewritesPlan(t *testing.T) {
+	snippets, _ := fakeNode(t)
+	plan := filepath.Join(snippets, PlanFileName(101))
+	if err := os.WriteFile(plan, []byte("vcpus 0-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	detached, err := RefreshHookscript(hookedVM(), &HookPlan{VMID: 101, VCPUs: "8-11"}, false)
+	if err != nil || detached {
+		t.Fatalf("RefreshHookscript: detached %v, err %v", detached, err)
+	}
+	data, err := os.ReadFile(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "vcpus 8-11\n") {
+		t.Errorf("plan not rewritten:\n%s", data)
+	}
+}
+
+func TestClearAffinityRemovesPlan(t *testing.T) {
+	snippets, qmLog := fakeNode(t)
+	plan := filepath.Join(snippets, PlanFileName(101))
+	if err := os.WriteFile(plan, []byte("vcpus 0-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ClearAffinity(hookedVM(), false); err != nil {
+		t.Fatalf("ClearAffinity: %v", err)
+	}
+	if _, err := os.Stat(plan); !os.IsNotExist(err) {
+		t.Errorf("plan still present: %v", err)
+	}
+	log, _ := os.ReadFile(qmLog)
+	if got := strings.TrimSpace(string(log)); got != "set 101 --delete affinity,hookscript" {
+		t.Errorf("qm ran %q", got)
+	}
+}
+
+func TestRefreshHookscriptDetachesThroughAPI(t *testing.T) {
+	srv := pvetest.NewServer(testToken)
+	defer srv.Close()
+	srv.AddVM("pve1", 101, "web", "stopped", map[string]string{
+		"affinity":   "0-3",
+		"hookscript": SnippetVolume("shared", HookScriptName),
+	})
+	UseBackend(newTestBackend(t, srv.URL, testToken, ""))
+	t.Cleanup(func() { UseBackend(CLIBackend{}) })
+
+	detached, err := RefreshHookscript(hookedVM(), &HookPlan{VMID: 101, VCPUs: "8-11"}, false)
+	if err != nil || !detached {
+		t.Fatalf("RefreshHookscript: detached %v, err %v", detached, err)
+	}
+	if _, ok := srv.Config(101)["hookscript"]; ok {
+		t.Error("hookscript still attached")
+	}
+}
@@ -0,0 +1,232 @@
+package pve
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PIDDir holds the QEMU pid file of every running VM on the node.
+const PIDDir = "/var/run/qemu-server"
+
+// ProcDir is where per-process thread lists are read from.
+const ProcDir = "/proc"
+
+var ErrVMNotRunning = errors.New("vm not running")
+
+// ReadPID returns the PID of the VM's QEMU process.
+func ReadPID(vmid int) (int, error) {
+	data, err := os.ReadFile(filepath.Join(PIDDir, strconv.Itoa(vmid)+".pid"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("%w: %d", ErrVMNotRunning, vmid)
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file for VM %d: %q", vmid, strings.TrimSpace(string(data)))
+	}
+	if _, err := os.Stat(filepath.Join(ProcDir, strconv.Itoa(pid))); err != nil {
+		return 0, fmt.Errorf("%w: %d (stale pid %d)", ErrVMNotRunning, vmid, pid)
+	}
+	return pid, nil
+}
+
+// Threads lists the thread IDs of a process.
+func Threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(ProcDir, strconv.Itoa(pid), "task"))
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		tids = append(tids, tid)
+	}
+	return tids, nil
+}
+
+// ThreadKind classifies QEMU threads for pinning.
+type ThreadKind string
+
+const (
+	ThreadVCPU     ThreadKind = "vcpu"
+	ThreadIO       ThreadKind = "iothread"
+	ThreadVhost    ThreadKind = "vhost"
+	ThreadEmulator ThreadKind = "emulator"
+)
+
+// ThreadPin restricts one QEMU thread to a set of host CPUs.
+type ThreadPin struct {
+	TID  int
+	Name string
+	Kind ThreadKind
+	CPUs []int
+}
+
+// PinPlan says where each kind of QEMU thread runs.
+type PinPlan struct {
+	// VCPUs holds every vCPU thread unless VCPUMap is set.
+	VCPUs []int
+	// VCPUMap pins vCPU thread N to host CPU VCPUMap[N].
+	VCPUMap []int
+	// Emulator holds the main loop, iothreads and vhost workers. When
+	// empty they share VCPUs.
+	Emulator []int
+}
+
+// vcpuThreadPattern matches the names KVM gives vCPU threads.
+var vcpuThreadPattern = regexp.MustCompile(`^CPU (\d+)/KVM$`)
+
+// ThreadNames maps the thread IDs of a process to their comm names.
+func ThreadNames(pid int) (map[int]string, error) {
+	tids, err := Threads(pid)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(tids))
+	for _, tid := range tids {
+		data, err := os.ReadFile(filepath.Join(ProcDir, strconv.Itoa(pid), "task", strconv.Itoa(tid), "comm"))
+		if err != nil {
+			// The thread exited after it was listed.
+			continue
+		}
+		names[tid] = strings.TrimSpace(string(data))
+	}
+	return names, nil
+}
+
+// VCPUIndex returns the vCPU number of a thread named "CPU N/KVM".
+func VCPUIndex(name string) (int, bool) {
+	m := vcpuThreadPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// ClassifyThread tells vCPU, iothread and vhost worker threads apart from
+// the rest of QEMU (main loop, RCU, VNC and other workers).
+func ClassifyThread(name string) ThreadKind {
+	switch {
+	case vcpuThreadPattern.MatchString(name):
+		return ThreadVCPU
+	case strings.HasPrefix(name, "IO "):
+		// QEMU names iothreads "IO <id>", e.g. "IO iothread-virt".
+		return ThreadIO
+	case strings.HasPrefix(name, "vhost-"):
+		return ThreadVhost
+	default:
+		return ThreadEmulator
+	}
+}
+
+// PlanThreadPins assigns every thread of the QEMU process to its CPUs
+// under plan. With a VCPUMap the VM must run exactly len(VCPUMap) vCPU
+// threads.
+func PlanThreadPins(pid int, plan PinPlan) ([]ThreadPin, error) {
+	names, err := ThreadNames(pid)
+	if err != nil {
+		return nil, err
+	}
+	emulator := plan.Emulator
+	if len(emulator) == 0 {
+		emulator = plan.VCPUs
+	}
+
+	tids := make([]int, 0, len(names))
+	for tid := range names {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+
+	pins := make([]ThreadPin, 0, len(tids))
+	vcpus := 0
+	for _, tid := range tids {
+		name := names[tid]
+		pin := ThreadPin{TID: tid, Name: name, Kind: ClassifyThread(name), CPUs: emulator}
+		if pin.Kind == ThreadVCPU {
+			vcpus++
+			pin.CPUs = plan.VCPUs
+			if plan.VCPUMap != nil {
+				n, _ := VCPUIndex(name)
+				if n >= len(plan.VCPUMap) {
+					return nil, fmt.Errorf("%w: thread %q has no host CPU, only %d mapped", ErrVCPUMismatch, name, len(plan.VCPUMap))
+				}
+				pin.CPUs = []int{plan.VCPUMap[n]}
+			}
+		}
+		pins = append(pins, pin)
+	}
+	if plan.VCPUMap != nil && vcpus != len(plan.VCPUMap) {
+		return nil, fmt.Errorf("%w: process %d runs %d vCPU threads, %d host CPUs mapped", ErrVCPUMismatch, pid, vcpus, len(plan.VCPUMap))
+	}
+	return pins, nil
+}
+
+// ApplyPins applies each pin, skipping threads that exited. It returns the
+// number of threads pinned.
+func ApplyPins(pins []ThreadPin) (int, error) {
+	pinned := 0
+	for _, pin := range pins {
+		err := setThreadAffinity(pin.TID, pin.CPUs)
+		if errors.Is(err, errThreadGone) {
+			continue
+		}
+		if err != nil {
+			return pinned, fmt.Errorf("thread %d (%s): %w", pin.TID, pin.Name, err)
+		}
+		pinned++
+	}
+	return pinned, nil
+}
+
+// PinCommand is the shell equivalent of applying one pin, for dry runs.
+func PinCommand(pin ThreadPin, affinity string) string {
+	return fmt.Sprintf("taskset -c -p %s %d  # %s (%s)", affinity, pin.TID, pin.Name, pin.Kind)
+}
+
+// ApplyLive pins every thread of the running VM's QEMU process to cpus, so
+// a new affinity takes effect without a restart. It returns the number of
+// threads pinned; threads that exit meanwhile are skipped.
+func ApplyLive(vmid int, cpus []int, dryRun bool) (int, error) {
+	if len(cpus) == 0 {
+		return 0, errors.New("no CPUs given")
+	}
+	pid, err := ReadPID(vmid)
+	if err != nil {
+		return 0, err
+	}
+	tids, err := Threads(pid)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(tids), nil
+	}
+
+	pins := make([]ThreadPin, len(tids))
+	for i, tid := range tids {
+		pins[i] = ThreadPin{TID: tid, CPUs: cpus}
+	}
+	pinned, err := ApplyPins(pins)
+	if err != nil {
+		return pinned, fmt.Errorf("VM %d: %w", vmid, err)
+	}
+	return pinned, nil
+}
+
+// LiveCommand is the shell equivalent of ApplyLive, for dry runs.
+func LiveCommand(pid int, affinity string) string {
+	return fmt.Sprintf("taskset -a -c -p %s %d", affinity, pid)
+}
@@ -0,0 +1,30 @@
+package pve
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+var errThreadGone = errors.New("thread exited")
+
+func setThreadAffinity(tid int, cpus []int) error {
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	err := unix.SchedSetaffinity(tid, &set)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ESRCH):
+		return errThreadGone
+	case errors.Is(err, unix.EPERM):
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	default:
+		return err
+	}
+}
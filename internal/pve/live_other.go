@@ -0,0 +1,11 @@
+//go:build !linux
+
+package pve
+
+import "errors"
+
+var errThreadGone = errors.New("thread exited")
+
+func setThreadAffinity(tid int, cpus []int) error {
+	return errors.New("live affinity is only supported on Linux")
+}
@@ -0,0 +1,25 @@
+package pve
+
+import "testing"
+
+func TestClassifyThread(t *testing.T) {
+	tests := []struct {
+		name string
+		want ThreadKind
+	}{
+		{"CPU 0/KVM", ThreadVCPU},
+		{"CPU 12/KVM", ThreadVCPU},
+		{"IO iothread-virt", ThreadIO},
+		{"IO iothread-scsi", ThreadIO},
+		{"vhost-1234", ThreadVhost},
+		{"qemu-system-x86", ThreadEmulator},
+		{"call_rcu", ThreadEmulator},
+		{"IO mon_iothread", ThreadIO},
+		{"worker", ThreadEmulator},
+	}
+	for _, tt := range tests {
+		if got := ClassifyThread(tt.name); got != tt.want {
+			t.Errorf("ClassifyThread(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package pvetest provides a local stand-in for the Proxmox VE REST API,
+// covering the endpoints pve.APIBackend uses. VM configs are kept in
+// memory as the string values a config file would hold.
+package pvetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a running stand-in. Requests must carry Token as an API token.
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu  sync.Mutex
+	vms map[int]*vm
+}
+
+type vm struct {
+	node    string
+	name    string
+	status  string
+	config  map[string]string
+	pending map[string]string
+	deleted []string
+}
+
+// NewServer starts a stand-in accepting token, e.g.
+// "root@pam!test=00000000-0000-0000-0000-000000000000". Call Close when
+// done.
+func NewServer(token string) *Server {
+	s := &Server{Token: token, vms: make(map[int]*vm)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api2/json/nodes", s.handleNodes)
+	mux.HandleFunc("GET /api2/json/nodes/{node}/qemu", s.handleList)
+	mux.HandleFunc("GET /api2/json/nodes/{node}/qemu/{vmid}/config", s.handleConfig)
+	mux.HandleFunc("PUT /api2/json/nodes/{node}/qemu/{vmid}/config", s.handleSetConfig)
+	mux.HandleFunc("POST /api2/json/nodes/{node}/qemu/{vmid}/config", s.handleSetConfig)
+	mux.HandleFunc("GET /api2/json/nodes/{node}/qemu/{vmid}/pending", s.handlePending)
+	mux.HandleFunc("GET /api2/json/cluster/resources", s.handleResources)
+
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// AddVM creates a VM on node with the given config.
+func (s *Server) AddVM(node string, vmid int, name, status string, config map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := make(map[string]string, len(config))
+	for k, v := range config {
+		copied[k] = v
+	}
+	s.vms[vmid] = &vm{node: node, name: name, status: status, config: copied, pending: make(map[string]string)}
+}
+
+// AddPending records a pending change of key; an empty value is a pending
+// delete.
+func (s *Server) AddPending(vmid int, key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.vms[vmid]; ok {
+		if value == "" {
+			v.deleted = append(v.deleted, key)
+		} else {
+			v.pending[key] = value
+		}
+	}
+}
+
+// Config returns a copy of a VM's current config, or nil if it does not
+// exist.
+func (s *Server) Config(vmid int) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vms[vmid]
+	if !ok {
+		return nil
+	}
+	copied := make(map[string]string, len(v.config))
+	for k, val := range v.config {
+		copied[k] = val
+	}
+	return copied
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "PVEAPIToken="+s.Token {
+			fail(w, http.StatusUnauthorized, "authentication failure")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	nodes := []map[string]any{}
+	for _, v := range s.vms {
+		if !seen[v.node] {
+			seen[v.node] = true
+			nodes = append(nodes, map[string]any{"node": v.node, "status": "online"})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i]["node"].(string) < nodes[j]["node"].(string) })
+	reply(w, nodes)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	node := r.PathValue("node")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]any{}
+	for _, vmid := range s.sortedIDs() {
+		v := s.vms[vmid]
+		if v.node == node {
+			list = append(list, map[string]any{"vmid": vmid, "name": v.name, "status": v.status})
+		}
+	}
+	reply(w, list)
+}
+
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]any{}
+	if t := r.URL.Query().Get("type"); t == "" || t == "vm" {
+		for _, vmid := range s.sortedIDs() {
+			v := s.vms[vmid]
+			list = append(list, map[string]any{
+				"id": fmt.Sprintf("qemu/%d", vmid), "type": "qemu",
+				"vmid": vmid, "node": v.node, "name": v.name, "status": v.status,
+			})
+		}
+	}
+	reply(w, list)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	config := map[string]any{"digest": "0000000000000000000000000000000000000000"}
+	for k, val := range v.config {
+		config[k] = jsonValue(val)
+	}
+	reply(w, config)
+}
+
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	keys := make(map[string]bool)
+	for k := range v.config {
+		keys[k] = true
+	}
+	for k := range v.pending {
+		keys[k] = true
+	}
+	for _, k := range v.deleted {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	list := []map[string]any{}
+	for _, k := range sorted {
+		entry := map[string]any{"key": k}
+		if val, ok := v.config[k]; ok {
+			entry["value"] = jsonValue(val)
+		}
+		if val, ok := v.pending[k]; ok {
+			entry["pending"] = jsonValue(val)
+		}
+		for _, d := range v.deleted {
+			if d == k {
+				entry["delete"] = 1
+			}
+		}
+		list = append(list, entry)
+	}
+	reply(w, list)
+}
+
+func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for key, values := range r.PostForm {
+		if key == "delete" {
+			for _, k := range strings.Split(values[0], ",") {
+				delete(v.config, strings.TrimSpace(k))
+			}
+			continue
+		}
+		v.config[key] = values[0]
+	}
+	reply(w, nil)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*vm, bool) {
+	node := r.PathValue("node")
+	vmid, err := strconv.Atoi(r.PathValue("vmid"))
+	v, ok := s.vms[vmid]
+	if err != nil || !ok || v.node != node {
+		fail(w, http.StatusInternalServerError,
+			fmt.Sprintf("Configuration file 'nodes/%s/qemu-server/%s.conf' does not exist", node, r.PathValue("vmid")))
+		return nil, false
+	}
+	return v, true
+}
+
+func (s *Server) sortedIDs() []int {
+	ids := make([]int, 0, len(s.vms))
+	for vmid := range s.vms {
+		ids = append(ids, vmid)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// jsonValue returns integers as numbers, as the real API does.
+func jsonValue(value string) any {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return value
+}
+
+func reply(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// fail answers like Proxmox does: the message goes into the status line.
+func fail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"data": nil, "message": message})
+}
@@ -0,0 +1,88 @@
+package pve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// StorageConfigPath lists the cluster's storages.
+	StorageConfigPath = "/etc/pve/storage.cfg"
+	// CorosyncConfigPath only exists on nodes that are part of a cluster.
+	CorosyncConfigPath = "/etc/pve/corosync.conf"
+)
+
+var ErrStorageNotShared = errors.New("storage is not shared")
+
+// sharedStorageTypes are mounted on every node by their nature; other file
+// storages count as shared only with "shared 1".
+var sharedStorageTypes = map[string]bool{
+	"nfs":       true,
+	"cifs":      true,
+	"cephfs":    true,
+	"glusterfs": true,
+}
+
+// CheckSnippetStorage refuses a snippets storage that only this node sees
+// when the node is part of a cluster: a VM migrated to another node would
+// start without its hookscript, and Proxmox refuses to start it at all.
+func CheckSnippetStorage(storage string) error {
+	return checkSnippetStorage(StorageConfigPath, CorosyncConfigPath, storage)
+}
+
+func checkSnippetStorage(storageConfig, corosyncConfig, storage string) error {
+	if _, err := os.Stat(corosyncConfig); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	f, err := os.Open(storageConfig)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	shared, found, err := isSharedStorage(f, storage)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", storageConfig, err)
+	}
+	if !found {
+		return fmt.Errorf("storage %q not found in %s", storage, storageConfig)
+	}
+	if !shared {
+		return fmt.Errorf("%w: %s exists on this node only, so VMs migrated elsewhere lose the hookscript; use a shared storage with snippets content", ErrStorageNotShared, storage)
+	}
+	return nil
+}
+
+// isSharedStorage looks storage up in a storage.cfg, which holds one
+// "type: name" line per storage followed by indented properties.
+func isSharedStorage(r io.Reader, storage string) (shared, found bool, err error) {
+	scanner := bufio.NewScanner(r)
+	inSection := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			kind, name, ok := strings.Cut(trimmed, ":")
+			inSection = ok && strings.TrimSpace(name) == storage
+			if inSection {
+				found = true
+				shared = sharedStorageTypes[strings.TrimSpace(kind)]
+			}
+			continue
+		}
+		if inSection {
+			key, value, _ := strings.Cut(trimmed, " ")
+			if key == "shared" && strings.TrimSpace(value) == "1" {
+				shared = true
+			}
+		}
+	}
+	return shared, found, scanner.Err()
+}
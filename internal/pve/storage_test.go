@@ -0,0 +1,57 @@
+package pve
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testStorageConfig = `dir: local
+	path /var/lib/vz
+	content iso,vztmpl,backup,snippets
+
+dir: shared-dir
+	path /mnt/shared
+	content snippets
+	shared 1
+
+nfs: nas
+	path /mnt/pve/nas
+	server 10.0.0.5
+	export /srv/pve
+	content snippets
+`
+
+func TestCheckSnippetStorage(t *testing.T) {
+	dir := t.TempDir()
+	storageConfig := filepath.Join(dir, "storage.cfg")
+	if err := os.WriteFile(storageConfig, []byte(testStorageConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	corosync := filepath.Join(dir, "corosync.conf")
+
+	if err := checkSnippetStorage(storageConfig, corosync, "local"); err != nil {
+		t.Errorf("standalone node: got %v, want local storage accepted", err)
+	}
+
+	if err := os.WriteFile(corosync, []byte("totem {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		storage string
+		want    error
+	}{
+		{"local", ErrStorageNotShared},
+		{"shared-dir", nil},
+		{"nas", nil},
+	}
+	for _, tt := range tests {
+		if err := checkSnippetStorage(storageConfig, corosync, tt.storage); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.storage, err, tt.want)
+		}
+	}
+	if err := checkSnippetStorage(storageConfig, corosync, "missing"); err == nil {
+		t.Error("unknown storage accepted")
+	}
+}
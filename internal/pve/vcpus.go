@@ -0,0 +1,101 @@
+package pve
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrVCPUMismatch = errors.New("pinned CPU count does not match VM vCPUs")
+
+// NextStartVCPUs returns the vCPU count the VM will run with after its next
+// start, which is when a new affinity takes effect: pending cores, sockets
+// and vcpus changes override the current config.
+func (vm *VM) NextStartVCPUs() int {
+	if vm.Pending == nil {
+		return vm.VCPUCount()
+	}
+
+	next := vm.Config
+	deleted := vm.pendingDeletes()
+	if deleted["cores"] {
+		next.Cores = 0
+	}
+	if deleted["sockets"] {
+		next.Sockets = 0
+	}
+	if deleted["vcpus"] {
+		next.VCPUs = 0
+	}
+	if _, ok := vm.Pending.Raw["cores"]; ok {
+		next.Cores = vm.Pending.Cores
+	}
+	if _, ok := vm.Pending.Raw["sockets"]; ok {
+		next.Sockets = vm.Pending.Sockets
+	}
+	if _, ok := vm.Pending.Raw["vcpus"]; ok {
+		next.VCPUs = vm.Pending.VCPUs
+	}
+	return next.VCPUCount()
+}
+
+// DefaultMemoryMB is the memory Proxmox gives a VM without a memory setting.
+const DefaultMemoryMB = 512
+
+// NextStartMemory returns the memory in MiB the VM will start with, taking
+// pending changes into account.
+func (vm *VM) NextStartMemory() int {
+	memory := vm.Memory
+	if vm.Pending != nil {
+		if vm.pendingDeletes()["memory"] {
+			memory = 0
+		}
+		if _, ok := vm.Pending.Raw["memory"]; ok {
+			memory = vm.Pending.Memory
+		}
+	}
+	if memory <= 0 {
+		return DefaultMemoryMB
+	}
+	return memory
+}
+
+// pendingDeletes returns the keys the pending section deletes.
+func (vm *VM) pendingDeletes() map[string]bool {
+	deleted := make(map[string]bool)
+	for _, key := range strings.Split(vm.Pending.Raw["delete"], ",") {
+		deleted[strings.TrimPrefix(strings.TrimSpace(key), "!")] = true
+	}
+	return deleted
+}
+
+// CheckVCPUs reports ErrVCPUMismatch when the number of pinned host CPUs
+// differs from the vCPUs the VM will start with.
+func CheckVCPUs(vm *VM, pinned int) error {
+	vcpus := vm.NextStartVCPUs()
+	if vcpus == pinned {
+		return nil
+	}
+	return fmt.Errorf("%w: VM %d has %d vCPUs but the affinity pins %d host CPUs", ErrVCPUMismatch, vm.VMID, vcpus, pinned)
+}
+
+// MatchingVCPUs returns sockets and cores giving the VM exactly n vCPUs,
+// keeping its socket count when n divides evenly across the sockets.
+func MatchingVCPUs(vm *VM, n int) (sockets, cores int) {
+	sockets = vm.Sockets
+	if sockets <= 0 || n%sockets != 0 {
+		sockets = 1
+	}
+	return sockets, n / sockets
+}
+
+// VCPUSettings sets sockets and cores and removes any vcpus limit, so the
+// guest starts with sockets*cores vCPUs.
+func VCPUSettings(vm *VM, sockets, cores int) map[string]string {
+	settings := map[string]string{"sockets": strconv.Itoa(sockets), "cores": strconv.Itoa(cores)}
+	if vm.VCPUs > 0 {
+		settings["vcpus"] = ""
+	}
+	return settings
+}
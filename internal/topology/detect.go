@@ -3,8 +3,10 @@ package topology
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -12,13 +14,26 @@ var ErrTopologyUnavailable = errors.New("topology unavailable")
 
 const defaultCoresPerCCD = 8
 
+// Detect reads the topology of the running host.
 func Detect() (*CPUTopology, error) {
-	info, err := os.Stat(SysfsBasePath)
+	return DetectRoot("/")
+}
+
+// DetectRoot reads the topology from a directory tree laid out like the host
+// root, e.g. a copy of another server's /sys and /proc.
+func DetectRoot(root string) (*CPUTopology, error) {
+	return DetectFS(os.DirFS(root))
+}
+
+// DetectFS reads the topology from fsys, which must be rooted where the host
+// root would be (sysfs at sys/devices/system/cpu, procfs at proc).
+func DetectFS(fsys fs.FS) (*CPUTopology, error) {
+	info, err := fs.Stat(fsys, fsPath(SysfsBasePath))
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return nil, fmt.Errorf("%w: sysfs base path not found", ErrTopologyUnavailable)
 		}
-		if os.IsPermission(err) {
+		if errors.Is(err, fs.ErrPermission) {
 			return nil, err
 		}
 		return nil, fmt.Errorf("%w: %v", ErrTopologyUnavailable, err)
@@ -27,7 +42,7 @@ func Detect() (*CPUTopology, error) {
 		return nil, fmt.Errorf("%w: sysfs base path not a directory", ErrTopologyUnavailable)
 	}
 
-	cpuIDs, err := ListCPUs()
+	cpuIDs, err := ListCPUs(fsys)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrTopologyUnavailable, err)
 	}
@@ -35,34 +50,177 @@ func Detect() (*CPUTopology, error) {
 		return nil, fmt.Errorf("%w: no CPUs found", ErrTopologyUnavailable)
 	}
 
+	state, err := readCPUState(fsys, cpuIDs)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTopologyUnavailable, err)
+	}
+	if len(state.online) == 0 {
+		return nil, fmt.Errorf("%w: no online CPUs found", ErrTopologyUnavailable)
+	}
+
 	infos := make([]CPUInfo, 0, len(cpuIDs))
 	for _, id := range cpuIDs {
-		info, err := readCPUInfo(id)
+		if !state.online[id] {
+			continue
+		}
+		info, err := readCPUInfo(fsys, id)
 		if err != nil {
-			if errors.Is(err, os.ErrPermission) {
+			if errors.Is(err, fs.ErrPermission) {
 				return nil, err
 			}
 			return nil, fmt.Errorf("%w: %v", ErrTopologyUnavailable, err)
 		}
+		info.Online = true
+		info.Isolated = state.isolated[id]
+		info.NoHZFull = state.noHZFull[id]
 		infos = append(infos, *info)
 	}
+	normalizeSiblings(infos)
+
+	nodes, err := readNUMANodes(fsys)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTopologyUnavailable, err)
+	}
 
-	arch := detectArchitecture(infos)
+	ident := readCPUIdent(fsys)
+	coreTypeSource := ""
+	if isIntel(ident) {
+		coreTypeSource = classifyCoreTypes(fsys, infos)
+	}
+	arch := detectArchitecture(ident, infos)
 
+	var topo *CPUTopology
 	switch arch {
 	case ArchIntelHybrid:
-		return buildIntelHybridTopology(infos)
+		topo, err = buildIntelHybridTopology(infos, coreTypeSource)
 	case ArchAMD:
-		return buildAMDTopology(infos)
+		topo, err = buildAMDTopology(infos)
 	default:
-		return buildGenericTopology(infos)
+		topo, err = buildGenericTopology(infos)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dies := assignDies(infos, ident)
+	applyDies(topo, dies)
+	topo.Hierarchy = buildHierarchy(infos, dies)
+	topo.ThreadSiblings = buildSiblingMap(infos)
+	topo.OfflineCPUs = state.offlineList(cpuIDs)
+	topo.IsolatedCPUs = selectCPUIDs(infos, func(info CPUInfo) bool { return info.Isolated })
+	topo.NoHZFullCPUs = selectCPUIDs(infos, func(info CPUInfo) bool { return info.NoHZFull })
+	linkNUMANodes(topo, nodes)
+	return topo, nil
+}
+
+type cpuState struct {
+	online   map[int]bool
+	isolated map[int]bool
+	noHZFull map[int]bool
+}
+
+// readCPUState reads the kernel's online, isolated and nohz_full lists.
+// Without an online file every listed CPU is taken to be online.
+func readCPUState(fsys fs.FS, cpuIDs []int) (*cpuState, error) {
+	state := &cpuState{
+		online:   make(map[int]bool),
+		isolated: make(map[int]bool),
+		noHZFull: make(map[int]bool),
+	}
+
+	online, ok, err := readCPUStateList(fsys, "online")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		online = cpuIDs
+	}
+	for _, id := range online {
+		state.online[id] = true
+	}
+
+	isolated, _, err := readCPUStateList(fsys, "isolated")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range isolated {
+		state.isolated[id] = true
+	}
+
+	noHZFull, _, err := readCPUStateList(fsys, "nohz_full")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range noHZFull {
+		state.noHZFull[id] = true
+	}
+	return state, nil
+}
+
+func (s *cpuState) offlineList(cpuIDs []int) []int {
+	offline := []int{}
+	for _, id := range cpuIDs {
+		if !s.online[id] {
+			offline = append(offline, id)
+		}
+	}
+	return offline
+}
+
+func selectCPUIDs(infos []CPUInfo, match func(CPUInfo) bool) []int {
+	result := []int{}
+	for _, info := range infos {
+		if match(info) {
+			result = append(result, info.ID)
+		}
+	}
+	return result
+}
+
+// normalizeSiblings drops sibling IDs that were not detected as CPUs and
+// recomputes which thread is the first of its core, so a missing thread
+// never leaves a core without a first thread.
+func normalizeSiblings(infos []CPUInfo) {
+	known := make(map[int]bool, len(infos))
+	for _, info := range infos {
+		known[info.ID] = true
+	}
+	for i := range infos {
+		siblings := make([]int, 0, len(infos[i].ThreadSiblings))
+		for _, s := range infos[i].ThreadSiblings {
+			if known[s] {
+				siblings = append(siblings, s)
+			}
+		}
+		if len(siblings) == 0 {
+			siblings = []int{infos[i].ID}
+		}
+		infos[i].ThreadSiblings = siblings
+		infos[i].IsFirstThread = infos[i].ID == siblings[0]
 	}
 }
 
-func detectArchitecture(cpus []CPUInfo) Architecture {
-	vendor := readCPUVendor()
+func buildSiblingMap(infos []CPUInfo) map[int][]int {
+	siblings := make(map[int][]int)
+	for _, info := range infos {
+		if !info.IsFirstThread {
+			continue
+		}
+		threads := make([]int, len(info.ThreadSiblings))
+		copy(threads, info.ThreadSiblings)
+		siblings[info.ID] = threads
+	}
+	return siblings
+}
 
-	switch vendor {
+func detectArchitecture(ident cpuIdent, cpus []CPUInfo) Architecture {
+	switch ident.Vendor {
 	case "AuthenticAMD", "AMD":
 		return ArchAMD
 	case "GenuineIntel":
@@ -78,25 +236,59 @@ func detectArchitecture(cpus []CPUInfo) Architecture {
 	}
 }
 
-func readCPUVendor() string {
-	data, err := os.ReadFile("/proc/cpuinfo")
+// cpuIdent is the vendor and family of the first processor in /proc/cpuinfo.
+type cpuIdent struct {
+	Vendor string
+	Family int
+}
+
+func readCPUIdent(fsys fs.FS) cpuIdent {
+	var ident cpuIdent
+	data, err := fs.ReadFile(fsys, fsPath(ProcCPUInfoPath))
 	if err != nil {
-		return ""
+		return ident
 	}
 
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
-		if strings.HasPrefix(line, "vendor_id") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1])
-			}
+		if strings.TrimSpace(line) == "" && ident.Vendor != "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "vendor_id":
+			ident.Vendor = value
+		case "cpu family":
+			ident.Family, _ = strconv.Atoi(value)
 		}
 	}
-	return ""
+	return ident
+}
+
+func isIntel(ident cpuIdent) bool {
+	return ident.Vendor == "GenuineIntel"
 }
 
 func hasHybridCores(cpus []CPUInfo) bool {
+	hasP := false
+	hasE := false
+	for _, cpu := range cpus {
+		switch cpu.CoreType {
+		case CoreTypePerformance:
+			hasP = true
+		case CoreTypeEfficiency, CoreTypeLowPower:
+			hasE = true
+		}
+	}
+	return hasP && hasE
+}
+
+func hasDistinctCapacities(cpus []CPUInfo) bool {
 	capacities := make(map[int]bool)
 	for _, cpu := range cpus {
 		if cpu.Capacity > 0 {
@@ -160,7 +352,7 @@ func buildAMDTopology(infos []CPUInfo) (*CPUTopology, error) {
 	}, nil
 }
 
-func buildIntelHybridTopology(infos []CPUInfo) (*CPUTopology, error) {
+func buildIntelHybridTopology(infos []CPUInfo, coreTypeSource string) (*CPUTopology, error) {
 	totalCPUs := len(infos)
 	totalCores := 0
 	for _, info := range infos {
@@ -187,9 +379,6 @@ func buildIntelHybridTopology(infos []CPUInfo) (*CPUTopology, error) {
 	for _, id := range packageIDs {
 		pkgGroups := packageMap[id]
 		sort.Slice(pkgGroups, func(i, j int) bool {
-			if pkgGroups[i].Type != pkgGroups[j].Type {
-				return pkgGroups[i].Type == CoreTypePerformance
-			}
 			return pkgGroups[i].ID < pkgGroups[j].ID
 		})
 		packages = append(packages, Package{ID: id, CoreGroups: pkgGroups})
@@ -202,7 +391,7 @@ func buildIntelHybridTopology(infos []CPUInfo) (*CPUTopology, error) {
 		HasSMT:       hasSMT,
 		Packages:     packages,
 		CoreGroups:   coreGroups,
-		DetectMethod: "intel_hybrid",
+		DetectMethod: "intel_hybrid/" + coreTypeSource,
 	}, nil
 }
 
@@ -247,35 +436,34 @@ func buildGenericTopology(infos []CPUInfo) (*CPUTopology, error) {
 	}, nil
 }
 
-func readCPUInfo(cpuID int) (*CPUInfo, error) {
-	packageID, err := readOptionalInt(cpuPath(cpuID, "physical_package_id"), 0)
+func readCPUInfo(fsys fs.FS, cpuID int) (*CPUInfo, error) {
+	packageID, err := readOptionalInt(fsys, cpuPath(cpuID, "physical_package_id"), 0)
 	if err != nil {
 		return nil, err
 	}
-	coreID, err := readOptionalInt(cpuPath(cpuID, "core_id"), cpuID)
+	coreID, err := readOptionalInt(fsys, cpuPath(cpuID, "core_id"), cpuID)
 	if err != nil {
 		return nil, err
 	}
-	clusterID, err := readOptionalInt(cpuPath(cpuID, "cluster_id"), -1)
+	clusterID, err := readOptionalInt(fsys, cpuPath(cpuID, "cluster_id"), -1)
 	if err != nil {
 		return nil, err
 	}
-	dieID, err := readOptionalInt(cpuPath(cpuID, "die_id"), -1)
+	dieID, err := readOptionalInt(fsys, cpuPath(cpuID, "die_id"), -1)
 	if err != nil {
 		return nil, err
 	}
 
-	l3CacheID, _ := ReadL3CacheID(cpuID)
+	l3CacheID, l3SizeKB, _ := ReadL3Cache(fsys, cpuID)
 
-	siblings, err := readOptionalList(cpuPath(cpuID, "thread_siblings_list"), []int{cpuID})
+	siblings, err := readOptionalList(fsys, cpuPath(cpuID, "thread_siblings_list"), []int{cpuID})
 	if err != nil {
 		return nil, err
 	}
 	sort.Ints(siblings)
 	siblings = dedupeSorted(siblings)
 
-	capacity := readCPUCapacity(cpuID)
-	coreType := detectCoreType(cpuID, capacity, siblings)
+	capacity := readCPUCapacity(fsys, cpuID)
 
 	info := &CPUInfo{
 		ID:             cpuID,
@@ -284,24 +472,34 @@ func readCPUInfo(cpuID int) (*CPUInfo, error) {
 		ClusterID:      clusterID,
 		DieID:          dieID,
 		L3CacheID:      l3CacheID,
+		L3SizeKB:       l3SizeKB,
 		ThreadSiblings: siblings,
 		IsFirstThread:  len(siblings) == 0 || cpuID == siblings[0],
-		CoreType:       coreType,
+		CoreType:       CoreTypeUnknown,
 		Capacity:       capacity,
+		MaxFreqKHz:     readCPUMaxFreq(fsys, cpuID),
 	}
 	return info, nil
 }
 
-func readCPUCapacity(cpuID int) int {
+func readCPUCapacity(fsys fs.FS, cpuID int) int {
 	path := cpuCapacityPath(cpuID)
-	value, err := ReadIntFile(path)
+	value, err := ReadIntFile(fsys, path)
 	if err != nil {
 		return 0
 	}
 	return value
 }
 
-func detectCoreType(cpuID int, capacity int, siblings []int) CoreType {
+func readCPUMaxFreq(fsys fs.FS, cpuID int) int {
+	value, err := ReadIntFile(fsys, cpuMaxFreqPath(cpuID))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func detectCoreType(capacity int) CoreType {
 	if capacity >= 1000 {
 		return CoreTypePerformance
 	}
@@ -313,66 +511,47 @@ func detectCoreType(cpuID int, capacity int, siblings []int) CoreType {
 }
 
 func groupByIntelCoreType(cpus []CPUInfo) []CoreGroup {
-	pCores := CoreGroup{
-		ID:        0,
-		Type:      CoreTypePerformance,
-		Name:      "P-Cores",
-		L3CacheID: -1,
+	groups := []CoreGroup{
+		{ID: 0, Type: CoreTypePerformance, Name: "P-Cores", L3CacheID: -1},
+		{ID: 1, Type: CoreTypeEfficiency, Name: "E-Cores", L3CacheID: -1},
+		{ID: 2, Type: CoreTypeLowPower, Name: "LP E-Cores", L3CacheID: -1},
 	}
-	eCores := CoreGroup{
-		ID:        1,
-		Type:      CoreTypeEfficiency,
-		Name:      "E-Cores",
-		L3CacheID: -1,
+	index := map[CoreType]int{
+		CoreTypePerformance: 0,
+		CoreTypeEfficiency:  1,
+		CoreTypeLowPower:    2,
 	}
 
 	for _, cpu := range cpus {
-		if cpu.PackageID > pCores.PackageID {
-			pCores.PackageID = cpu.PackageID
-		}
-		if cpu.PackageID > eCores.PackageID {
-			eCores.PackageID = cpu.PackageID
+		for i := range groups {
+			if cpu.PackageID > groups[i].PackageID {
+				groups[i].PackageID = cpu.PackageID
+			}
 		}
 
-		if cpu.CoreType == CoreTypePerformance {
-			pCores.AllCPUs = append(pCores.AllCPUs, cpu.ID)
-			if cpu.IsFirstThread {
-				pCores.PhysicalCPUs = append(pCores.PhysicalCPUs, cpu.ID)
-			}
-		} else if cpu.CoreType == CoreTypeEfficiency {
-			eCores.AllCPUs = append(eCores.AllCPUs, cpu.ID)
-			if cpu.IsFirstThread {
-				eCores.PhysicalCPUs = append(eCores.PhysicalCPUs, cpu.ID)
-			}
-		} else {
-			if len(cpu.ThreadSiblings) > 1 {
-				pCores.AllCPUs = append(pCores.AllCPUs, cpu.ID)
-				if cpu.IsFirstThread {
-					pCores.PhysicalCPUs = append(pCores.PhysicalCPUs, cpu.ID)
-				}
-			} else {
-				eCores.AllCPUs = append(eCores.AllCPUs, cpu.ID)
-				if cpu.IsFirstThread {
-					eCores.PhysicalCPUs = append(eCores.PhysicalCPUs, cpu.ID)
-				}
-			}
+		i, ok := index[cpu.CoreType]
+		if !ok {
+			// classifyCoreTypes leaves no CPU unknown; keep them with
+			// the P-cores if one slips through.
+			i = 0
+		}
+		groups[i].AllCPUs = append(groups[i].AllCPUs, cpu.ID)
+		if cpu.IsFirstThread {
+			groups[i].PhysicalCPUs = append(groups[i].PhysicalCPUs, cpu.ID)
 		}
 	}
 
-	sort.Ints(pCores.AllCPUs)
-	sort.Ints(pCores.PhysicalCPUs)
-	sort.Ints(eCores.AllCPUs)
-	sort.Ints(eCores.PhysicalCPUs)
-
-	var groups []CoreGroup
-	if len(pCores.PhysicalCPUs) > 0 {
-		groups = append(groups, pCores)
-	}
-	if len(eCores.PhysicalCPUs) > 0 {
-		groups = append(groups, eCores)
+	var result []CoreGroup
+	for _, cg := range groups {
+		if len(cg.PhysicalCPUs) == 0 {
+			continue
+		}
+		sort.Ints(cg.AllCPUs)
+		sort.Ints(cg.PhysicalCPUs)
+		result = append(result, cg)
 	}
 
-	return groups
+	return result
 }
 
 func groupByCCD(cpus []CPUInfo, method string) []CoreGroup {
@@ -408,9 +587,13 @@ func groupByCCD(cpus []CPUInfo, method string) []CoreGroup {
 				Type:      CoreTypeUnknown,
 				Name:      fmt.Sprintf("CCD %d", ccdID),
 				L3CacheID: l3ID,
+				L3SizeKB:  cpu.L3SizeKB,
 			}
 			groups[groupKey] = cg
 		}
+		if cpu.MaxFreqKHz > cg.MaxFreqKHz {
+			cg.MaxFreqKHz = cpu.MaxFreqKHz
+		}
 		cg.AllCPUs = append(cg.AllCPUs, cpu.ID)
 		if cpu.IsFirstThread {
 			cg.PhysicalCPUs = append(cg.PhysicalCPUs, cpu.ID)
@@ -439,10 +622,31 @@ func groupByCCD(cpus []CPUInfo, method string) []CoreGroup {
 		list[i].Name = fmt.Sprintf("CCD %d", list[i].ID)
 		pkgCCDCount[list[i].PackageID]++
 	}
+	flagVCache(list)
 
 	return list
 }
 
+// vcacheMinL3KB is the smallest L3 per CCD that only 3D V-Cache parts
+// reach: regular Zen 3/4/5 CCDs carry 32 MiB, V-Cache CCDs 96 MiB.
+const vcacheMinL3KB = 64 * 1024
+
+// flagVCache marks CCDs with stacked cache: those at or above the V-Cache
+// size, and on asymmetric parts (7950X3D, 9950X3D) those with more L3 than
+// the smallest CCD.
+func flagVCache(groups []CoreGroup) {
+	minSize := 0
+	for _, cg := range groups {
+		if cg.L3SizeKB > 0 && (minSize == 0 || cg.L3SizeKB < minSize) {
+			minSize = cg.L3SizeKB
+		}
+	}
+	for i := range groups {
+		size := groups[i].L3SizeKB
+		groups[i].VCache = size >= vcacheMinL3KB || (minSize > 0 && size > minSize)
+	}
+}
+
 func detectCCDMethod(cpus []CPUInfo) string {
 	if len(cpus) == 0 {
 		return "inferred"
@@ -486,10 +690,10 @@ func detectCCDMethod(cpus []CPUInfo) string {
 	return "inferred"
 }
 
-func readOptionalInt(path string, defaultValue int) (int, error) {
-	value, err := ReadIntFile(path)
+func readOptionalInt(fsys fs.FS, path string, defaultValue int) (int, error) {
+	value, err := ReadIntFile(fsys, path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			return defaultValue, nil
 		}
 		return 0, err
@@ -497,10 +701,10 @@ func readOptionalInt(path string, defaultValue int) (int, error) {
 	return value, nil
 }
 
-func readOptionalList(path string, defaultValue []int) ([]int, error) {
-	values, err := ReadListFile(path)
+func readOptionalList(fsys fs.FS, path string, defaultValue []int) ([]int, error) {
+	values, err := ReadListFile(fsys, path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			copyValue := make([]int, len(defaultValue))
 			copy(copyValue, defaultValue)
 			return copyValue, nil
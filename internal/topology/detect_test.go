@@ -0,0 +1,268 @@
+package topology
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeHost builds a sysfs/procfs tree for DetectFS.
+type fakeHost fstest.MapFS
+
+func (h fakeHost) file(p, content string) {
+	h[fsPath(p)] = &fstest.MapFile{Data: []byte(content + "\n")}
+}
+
+func (h fakeHost) vendor(id string) {
+	h.file(ProcCPUInfoPath, "processor\t: 0\nvendor_id\t: "+id+"\ncpu family\t: 25\n")
+}
+
+// cpu adds one CPU. An l3 below zero leaves out the cache directory.
+func (h fakeHost) cpu(id, pkg, core, l3 int, l3Size, siblings string) {
+	h.file(cpuPath(id, "physical_package_id"), strconv.Itoa(pkg))
+	h.file(cpuPath(id, "core_id"), strconv.Itoa(core))
+	h.file(cpuPath(id, "thread_siblings_list"), siblings)
+	if l3 >= 0 {
+		h.file(cpuCachePath(id, 3, "level"), "3")
+		h.file(cpuCachePath(id, 3, "id"), strconv.Itoa(l3))
+		h.file(cpuCachePath(id, 3, "size"), l3Size)
+	}
+}
+
+func (h fakeHost) node(id int, cpus, distances string) {
+	dir := NodeBasePath + "/node" + strconv.Itoa(id)
+	h.file(dir+"/cpulist", cpus)
+	h.file(dir+"/distance", distances)
+	h.file(dir+"/meminfo", "Node "+strconv.Itoa(id)+" MemTotal:       65843720 kB\nNode "+strconv.Itoa(id)+" MemFree:        32000000 kB")
+}
+
+// amdHost has two CCDs of two SMT2 cores: CCD 0 holds CPUs 0-1,4-5 and
+// CCD 1 holds CPUs 2-3,6-7.
+func amdHost(ccd0Size, ccd1Size string) fakeHost {
+	h := fakeHost{}
+	h.vendor("AuthenticAMD")
+	for core := 0; core < 4; core++ {
+		l3, size := 0, ccd0Size
+		if core >= 2 {
+			l3, size = 1, ccd1Size
+		}
+		siblings := strconv.Itoa(core) + "," + strconv.Itoa(core+4)
+		h.cpu(core, 0, core, l3, size, siblings)
+		h.cpu(core+4, 0, core, l3, size, siblings)
+	}
+	return h
+}
+
+func detect(t *testing.T, h fakeHost) *CPUTopology {
+	t.Helper()
+	topo, err := DetectFS(fstest.MapFS(h))
+	if err != nil {
+		t.Fatalf("DetectFS: %v", err)
+	}
+	return topo
+}
+
+func groupCPUs(groups []CoreGroup) [][]int {
+	result := make([][]int, len(groups))
+	for i, cg := range groups {
+		result[i] = cg.AllCPUs
+	}
+	return result
+}
+
+func TestDetectNUMA(t *testing.T) {
+	h := amdHost("32768K", "32768K")
+	h.node(0, "0-1,4-5", "10 32")
+	h.node(1, "2-3,6-7", "32 10")
+
+	topo := detect(t, h)
+	if len(topo.NUMANodes) != 2 {
+		t.Fatalf("got %d NUMA nodes, want 2", len(topo.NUMANodes))
+	}
+	node1 := topo.NUMANodes[1]
+	if node1.ID != 1 || !reflect.DeepEqual(node1.CPUs, []int{2, 3, 6, 7}) || !reflect.DeepEqual(node1.Distances, []int{32, 10}) {
+		t.Errorf("node 1: got %+v", node1)
+	}
+	if node1.MemTotalKB != 65843720 || node1.MemFreeKB != 32000000 {
+		t.Errorf("node 1 memory: got %d total, %d free", node1.MemTotalKB, node1.MemFreeKB)
+	}
+	for i, want := range []int{0, 1} {
+		if got := topo.CoreGroups[i].NUMANodeID; got != want {
+			t.Errorf("CCD %d on node %d, want %d", i, got, want)
+		}
+	}
+	if got := topo.NUMANodeOf(6); got != 1 {
+		t.Errorf("NUMANodeOf(6) = %d, want 1", got)
+	}
+}
+
+func TestDetectWideSMT(t *testing.T) {
+	h := fakeHost{}
+	for cpu := 0; cpu < 8; cpu++ {
+		core := cpu % 2
+		h.cpu(cpu, 0, core, 0, "8192K", map[int]string{0: "0,2,4,6", 1: "1,3,5,7"}[core])
+	}
+
+	topo := detect(t, h)
+	if topo.Architecture != ArchGeneric || topo.TotalCores != 2 || topo.TotalCPUs != 8 {
+		t.Fatalf("got %s with %d cores, %d CPUs", topo.Architecture, topo.TotalCores, topo.TotalCPUs)
+	}
+	if got := topo.ThreadsPerCore(); got != 4 {
+		t.Errorf("ThreadsPerCore = %d, want 4", got)
+	}
+	if got := topo.Threads(5); !reflect.DeepEqual(got, []int{1, 3, 5, 7}) {
+		t.Errorf("Threads(5) = %v", got)
+	}
+	if got := topo.CoreGroups[0].PhysicalCPUs; !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("PhysicalCPUs = %v, want [0 1]", got)
+	}
+}
+
+func TestDetectOfflineAndIsolated(t *testing.T) {
+	h := amdHost("32768K", "32768K")
+	h.file(SysfsBasePath+"/online", "0-2,4-7")
+	h.file(SysfsBasePath+"/isolated", "2,6")
+	h.file(SysfsBasePath+"/nohz_full", "(null)")
+
+	topo := detect(t, h)
+	if !reflect.DeepEqual(topo.OfflineCPUs, []int{3}) {
+		t.Errorf("OfflineCPUs = %v, want [3]", topo.OfflineCPUs)
+	}
+	if !reflect.DeepEqual(topo.IsolatedCPUs, []int{2, 6}) {
+		t.Errorf("IsolatedCPUs = %v, want [2 6]", topo.IsolatedCPUs)
+	}
+	if len(topo.NoHZFullCPUs) != 0 {
+		t.Errorf("NoHZFullCPUs = %v, want none", topo.NoHZFullCPUs)
+	}
+	want := [][]int{{0, 1, 4, 5}, {2, 6, 7}}
+	if got := groupCPUs(topo.CoreGroups); !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+	// CPU 7 lost its offline sibling and is now the first thread of core 3.
+	if got := topo.Threads(7); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("Threads(7) = %v, want [7]", got)
+	}
+}
+
+func TestDetectVCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		ccd0, ccd1 string
+		want       []bool
+	}{
+		{"symmetric", "32768K", "32768K", []bool{false, false}},
+		{"asymmetric X3D", "98304K", "32768K", []bool{true, false}},
+		{"all V-Cache", "98304K", "98304K", []bool{true, true}},
+	}
+	for _, tt := range tests {
+		topo := detect(t, amdHost(tt.ccd0, tt.ccd1))
+		if topo.Architecture != ArchAMD || len(topo.CoreGroups) != 2 {
+			t.Fatalf("%s: got %s with %d groups", tt.name, topo.Architecture, len(topo.CoreGroups))
+		}
+		for i, want := range tt.want {
+			if got := topo.CoreGroups[i].VCache; got != want {
+				t.Errorf("%s: CCD %d VCache = %v, want %v", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+// hybridHost has two SMT2 P-cores (CPUs 0-3) and four E-cores (4-7); E-cores
+// 6-7 sit outside the P-cores' L3.
+func hybridHost() fakeHost {
+	h := fakeHost{}
+	h.vendor("GenuineIntel")
+	h.cpu(0, 0, 0, 0, "24576K", "0-1")
+	h.cpu(1, 0, 0, 0, "24576K", "0-1")
+	h.cpu(2, 0, 4, 0, "24576K", "2-3")
+	h.cpu(3, 0, 4, 0, "24576K", "2-3")
+	h.cpu(4, 0, 8, 0, "24576K", "4")
+	h.cpu(5, 0, 9, 0, "24576K", "5")
+	h.cpu(6, 0, 64, 1, "2048K", "6")
+	h.cpu(7, 0, 65, 1, "2048K", "7")
+	return h
+}
+
+// cpuidLeaf returns a /dev/cpu/N/cpuid device whose leaf 0x1A reports
+// coreType in EAX[31:24]. The native model ID below it has bytes above 0x7f,
+// as real ones do.
+func cpuidLeaf(coreType uint32) *fstest.MapFile {
+	data := make([]byte, cpuidLeafHybrid+16)
+	binary.LittleEndian.PutUint32(data[cpuidLeafHybrid:], coreType<<24|0x80f1)
+	return &fstest.MapFile{Data: data}
+}
+
+// cpuidHost is hybridHost without PMU lists, so core types come from CPUID.
+func cpuidHost() fakeHost {
+	h := hybridHost()
+	for cpu := 0; cpu < 8; cpu++ {
+		coreType := uint32(cpuidCoreTypeAtom)
+		if cpu < 4 {
+			coreType = cpuidCoreTypeCore
+		}
+		h[fsPath(cpuidDevicePath+"/"+strconv.Itoa(cpu)+"/cpuid")] = cpuidLeaf(coreType)
+	}
+	return h
+}
+
+func TestDetectHybrid(t *testing.T) {
+	pmu := hybridHost()
+	pmu.file(PMUCorePath, "0-3")
+	pmu.file(PMUAtomPath, "4-7")
+
+	tests := []struct {
+		name   string
+		host   fakeHost
+		method string
+	}{
+		{"pmu", pmu, "intel_hybrid/pmu"},
+		{"cpuid", cpuidHost(), "intel_hybrid/cpuid"},
+	}
+	for _, tt := range tests {
+		topo := detect(t, tt.host)
+		if topo.Architecture != ArchIntelHybrid || topo.DetectMethod != tt.method {
+			t.Errorf("%s: got %s via %s, want %s", tt.name, topo.Architecture, topo.DetectMethod, tt.method)
+			continue
+		}
+		if got := topo.GetAllPCoresVCPUs(); !reflect.DeepEqual(got, []int{0, 1, 2, 3}) {
+			t.Errorf("%s: P-core CPUs = %v", tt.name, got)
+		}
+		if got := topo.GetECoresCPUs(); !reflect.DeepEqual(got, []int{4, 5}) {
+			t.Errorf("%s: E-core CPUs = %v", tt.name, got)
+		}
+		if got := topo.GetLPECoresCPUs(); !reflect.DeepEqual(got, []int{6, 7}) {
+			t.Errorf("%s: LP E-core CPUs = %v", tt.name, got)
+		}
+	}
+}
+
+func TestDetectHybridECoreWithoutL3(t *testing.T) {
+	h := hybridHost()
+	h.file(PMUCorePath, "0-3")
+	h.file(PMUAtomPath, "4-7")
+	// CPU 5 reports no caches at all.
+	for name := range h {
+		if strings.HasPrefix(name, fsPath(SysfsBasePath+"/cpu5/cache")) {
+			delete(h, name)
+		}
+	}
+
+	topo := detect(t, h)
+	if got := topo.GetECoresCPUs(); !reflect.DeepEqual(got, []int{4, 5}) {
+		t.Errorf("E-core CPUs = %v, want [4 5]", got)
+	}
+	if got := topo.GetLPECoresCPUs(); !reflect.DeepEqual(got, []int{6, 7}) {
+		t.Errorf("LP E-core CPUs = %v, want [6 7]", got)
+	}
+}
+
+func TestDetectMissingSysfs(t *testing.T) {
+	_, err := DetectFS(fstest.MapFS{"proc/cpuinfo": {Data: []byte("vendor_id : AuthenticAMD\n")}})
+	if !errors.Is(err, ErrTopologyUnavailable) {
+		t.Errorf("got %v, want %v", err, ErrTopologyUnavailable)
+	}
+}
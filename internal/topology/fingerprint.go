@@ -0,0 +1,40 @@
+package topology
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Fingerprint identifies the CPU layout an affinity was computed for. It
+// covers the architecture, core groups, thread siblings and NUMA nodes, and
+// changes when CPUs go offline or the hardware changes, but not with
+// isolation or free memory.
+func (t *CPUTopology) Fingerprint() string {
+	type group struct {
+		Package int    `json:"p"`
+		Die     int    `json:"d"`
+		Type    string `json:"t"`
+		CPUs    []int  `json:"c"`
+	}
+	type node struct {
+		ID   int   `json:"id"`
+		CPUs []int `json:"c"`
+	}
+	layout := struct {
+		Architecture Architecture  `json:"a"`
+		Groups       []group       `json:"g"`
+		Siblings     map[int][]int `json:"s"`
+		Nodes        []node        `json:"n"`
+	}{Architecture: t.Architecture, Siblings: t.ThreadSiblings}
+	for _, g := range t.CoreGroups {
+		layout.Groups = append(layout.Groups, group{g.PackageID, g.DieID, string(g.Type), g.AllCPUs})
+	}
+	for _, n := range t.NUMANodes {
+		layout.Nodes = append(layout.Nodes, node{n.ID, n.CPUs})
+	}
+
+	data, _ := json.Marshal(layout)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
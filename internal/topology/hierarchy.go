@@ -0,0 +1,181 @@
+package topology
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Zen, Zen+ and Zen 2 (family 17h) put two CCXs, each with its own L3, on
+// every CCD. From Zen 3 on a CCD has a single L3, so CCD and CCX coincide.
+const (
+	amdFamilyZen2 = 0x17
+	zen2CCXPerCCD = 2
+)
+
+// assignDies maps every CPU to the index of its die (CCD) within its
+// package. die_id is used when the kernel distinguishes dies; otherwise dies
+// are derived from L3 domains, pairing CCXs on Zen 2 and older.
+func assignDies(infos []CPUInfo, ident cpuIdent) map[int]int {
+	dies := make(map[int]int, len(infos))
+
+	if dieIDsSplitPackages(infos) {
+		index := indexWithinPackage(infos, func(info CPUInfo) int { return info.DieID })
+		for _, info := range infos {
+			dies[info.ID] = index[info.PackageID][info.DieID]
+		}
+		return dies
+	}
+
+	ccxPerDie := 1
+	if (ident.Vendor == "AuthenticAMD" || ident.Vendor == "AMD") && ident.Family == amdFamilyZen2 {
+		ccxPerDie = zen2CCXPerCCD
+	}
+
+	index := indexWithinPackage(infos, func(info CPUInfo) int { return info.L3CacheID })
+	for _, info := range infos {
+		if info.L3CacheID < 0 {
+			dies[info.ID] = 0
+			continue
+		}
+		dies[info.ID] = index[info.PackageID][info.L3CacheID] / ccxPerDie
+	}
+	return dies
+}
+
+func dieIDsSplitPackages(infos []CPUInfo) bool {
+	diesPerPackage := make(map[int]map[int]bool)
+	for _, info := range infos {
+		if info.DieID < 0 {
+			return false
+		}
+		if diesPerPackage[info.PackageID] == nil {
+			diesPerPackage[info.PackageID] = make(map[int]bool)
+		}
+		diesPerPackage[info.PackageID][info.DieID] = true
+	}
+	for _, ids := range diesPerPackage {
+		if len(ids) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// indexWithinPackage numbers the distinct non-negative values of key within
+// each package in ascending order.
+func indexWithinPackage(infos []CPUInfo, key func(CPUInfo) int) map[int]map[int]int {
+	values := make(map[int][]int)
+	for _, info := range infos {
+		if k := key(info); k >= 0 {
+			values[info.PackageID] = append(values[info.PackageID], k)
+		}
+	}
+
+	index := make(map[int]map[int]int)
+	for pkg, list := range values {
+		sort.Ints(list)
+		list = dedupeSorted(list)
+		index[pkg] = make(map[int]int, len(list))
+		for i, k := range list {
+			index[pkg][k] = i
+		}
+	}
+	return index
+}
+
+// applyDies records each core group's die and, when a die holds several L3
+// domains (Zen 2), names the groups "CCD d CCX x".
+func applyDies(topo *CPUTopology, dies map[int]int) {
+	type key struct{ pkg, die int }
+	groupsPerDie := make(map[key]int)
+	for i := range topo.CoreGroups {
+		cg := &topo.CoreGroups[i]
+		cg.DieID = -1
+		if len(cg.AllCPUs) > 0 {
+			cg.DieID = dies[cg.AllCPUs[0]]
+		}
+		groupsPerDie[key{cg.PackageID, cg.DieID}]++
+	}
+
+	splitDies := false
+	for _, count := range groupsPerDie {
+		if count > 1 {
+			splitDies = true
+		}
+	}
+
+	ccxIndex := make(map[key]int)
+	for i := range topo.CoreGroups {
+		cg := &topo.CoreGroups[i]
+		if splitDies && topo.Architecture == ArchAMD {
+			k := key{cg.PackageID, cg.DieID}
+			cg.Name = fmt.Sprintf("CCD %d CCX %d", cg.DieID, ccxIndex[k])
+			ccxIndex[k]++
+		}
+	}
+
+	for i := range topo.Packages {
+		for j := range topo.Packages[i].CoreGroups {
+			pg := &topo.Packages[i].CoreGroups[j]
+			for _, cg := range topo.CoreGroups {
+				if cg.PackageID == pg.PackageID && cg.ID == pg.ID && cg.Type == pg.Type {
+					pg.DieID = cg.DieID
+					pg.Name = cg.Name
+					break
+				}
+			}
+		}
+	}
+}
+
+// buildHierarchy arranges CPUs as package → die → L3 → core → thread.
+// Package, core and thread IDs are the kernel's (a core is identified by its
+// first thread); dies are numbered within their package; L3 domains carry
+// the L3 cache ID, or -1 when the kernel reports none.
+func buildHierarchy(infos []CPUInfo, dies map[int]int) []Domain {
+	return groupDomains(infos, LevelPackage, func(info CPUInfo) int { return info.PackageID }, func(pkg []CPUInfo) []Domain {
+		return groupDomains(pkg, LevelDie, func(info CPUInfo) int { return dies[info.ID] }, func(die []CPUInfo) []Domain {
+			return groupDomains(die, LevelL3, func(info CPUInfo) int { return info.L3CacheID }, func(l3 []CPUInfo) []Domain {
+				return groupDomains(l3, LevelCore, func(info CPUInfo) int { return info.ThreadSiblings[0] }, func(core []CPUInfo) []Domain {
+					threads := make([]Domain, 0, len(core))
+					for _, info := range core {
+						threads = append(threads, Domain{Level: LevelThread, ID: info.ID, CPUs: []int{info.ID}})
+					}
+					sort.Slice(threads, func(i, j int) bool { return threads[i].ID < threads[j].ID })
+					return threads
+				})
+			})
+		})
+	})
+}
+
+func groupDomains(infos []CPUInfo, level DomainLevel, key func(CPUInfo) int, children func([]CPUInfo) []Domain) []Domain {
+	members := make(map[int][]CPUInfo)
+	for _, info := range infos {
+		k := key(info)
+		members[k] = append(members[k], info)
+	}
+
+	keys := make([]int, 0, len(members))
+	for k := range members {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	domains := make([]Domain, 0, len(keys))
+	for _, k := range keys {
+		group := members[k]
+		cpus := make([]int, 0, len(group))
+		for _, info := range group {
+			cpus = append(cpus, info.ID)
+		}
+		sort.Ints(cpus)
+		domains = append(domains, Domain{
+			Level:    level,
+			ID:       k,
+			CPUs:     cpus,
+			Children: children(group),
+		})
+	}
+	return domains
+}
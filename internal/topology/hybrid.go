@@ -0,0 +1,172 @@
+package topology
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+)
+
+// Kernel PMU devices on hybrid Intel parts list the CPUs of each core type.
+const (
+	PMUCorePath = "/sys/devices/cpu_core/cpus"
+	PMUAtomPath = "/sys/devices/cpu_atom/cpus"
+)
+
+// CPUID leaf 0x1A reports the core type of the CPU executing it in
+// EAX[31:24]. The msr-style /dev/cpu/N/cpuid device answers for CPU N when
+// read at offset leaf.
+const (
+	cpuidDevicePath      = "/dev/cpu"
+	cpuidLeafHybrid      = 0x1A
+	cpuidCoreTypeAtom    = 0x20
+	cpuidCoreTypeCore    = 0x40
+	coreTypeSourcePMU    = "pmu"
+	coreTypeSourceCPUID  = "cpuid"
+	coreTypeSourceCap    = "capacity"
+	coreTypeSourceSMTFix = "+smt"
+)
+
+// classifyCoreTypes sets CoreType on every CPU from the most reliable source
+// available: the kernel's hybrid PMU cpu lists, then CPUID leaf 0x1A, then
+// cpu_capacity. CPUs still unknown after that are guessed from SMT (only
+// P-cores have siblings). It returns the source used, or "" if no source
+// distinguishes core types.
+func classifyCoreTypes(fsys fs.FS, infos []CPUInfo) string {
+	source := ""
+	switch {
+	case classifyFromPMU(fsys, infos):
+		source = coreTypeSourcePMU
+	case classifyFromCPUID(fsys, infos):
+		source = coreTypeSourceCPUID
+	case classifyFromCapacity(infos):
+		source = coreTypeSourceCap
+	default:
+		return ""
+	}
+
+	guessed := false
+	for i := range infos {
+		if infos[i].CoreType != CoreTypeUnknown {
+			continue
+		}
+		guessed = true
+		if len(infos[i].ThreadSiblings) > 1 {
+			infos[i].CoreType = CoreTypePerformance
+		} else {
+			infos[i].CoreType = CoreTypeEfficiency
+		}
+	}
+	if guessed {
+		source += coreTypeSourceSMTFix
+	}
+
+	markLowPowerCores(infos)
+	return source
+}
+
+func classifyFromPMU(fsys fs.FS, infos []CPUInfo) bool {
+	coreCPUs, err := ReadListFile(fsys, PMUCorePath)
+	if err != nil {
+		return false
+	}
+	atomCPUs, err := ReadListFile(fsys, PMUAtomPath)
+	if err != nil || len(atomCPUs) == 0 || len(coreCPUs) == 0 {
+		return false
+	}
+
+	types := make(map[int]CoreType)
+	for _, cpu := range coreCPUs {
+		types[cpu] = CoreTypePerformance
+	}
+	for _, cpu := range atomCPUs {
+		types[cpu] = CoreTypeEfficiency
+	}
+	for i := range infos {
+		if t, ok := types[infos[i].ID]; ok {
+			infos[i].CoreType = t
+		} else {
+			infos[i].CoreType = CoreTypeUnknown
+		}
+	}
+	return true
+}
+
+func classifyFromCPUID(fsys fs.FS, infos []CPUInfo) bool {
+	types := make([]CoreType, len(infos))
+	seen := make(map[CoreType]bool)
+	for i, info := range infos {
+		t, err := readCPUIDCoreType(fsys, info.ID)
+		if err != nil {
+			return false
+		}
+		types[i] = t
+		seen[t] = true
+	}
+	if !seen[CoreTypePerformance] || !seen[CoreTypeEfficiency] {
+		return false
+	}
+	for i := range infos {
+		infos[i].CoreType = types[i]
+	}
+	return true
+}
+
+func readCPUIDCoreType(fsys fs.FS, cpuID int) (CoreType, error) {
+	f, err := fsys.Open(fsPath(path.Join(cpuidDevicePath, strconv.Itoa(cpuID), "cpuid")))
+	if err != nil {
+		return CoreTypeUnknown, err
+	}
+	defer f.Close()
+
+	reader, ok := f.(io.ReaderAt)
+	if !ok {
+		return CoreTypeUnknown, errors.New("cpuid device does not support positioned reads")
+	}
+	regs := make([]byte, 16)
+	if _, err := reader.ReadAt(regs, cpuidLeafHybrid); err != nil {
+		return CoreTypeUnknown, err
+	}
+
+	eax := binary.LittleEndian.Uint32(regs[0:4])
+	switch eax >> 24 {
+	case cpuidCoreTypeCore:
+		return CoreTypePerformance, nil
+	case cpuidCoreTypeAtom:
+		return CoreTypeEfficiency, nil
+	default:
+		return CoreTypeUnknown, errors.New("cpuid reports no hybrid core type")
+	}
+}
+
+func classifyFromCapacity(infos []CPUInfo) bool {
+	if !hasDistinctCapacities(infos) {
+		return false
+	}
+	for i := range infos {
+		infos[i].CoreType = detectCoreType(infos[i].Capacity)
+	}
+	return true
+}
+
+// markLowPowerCores reclassifies E-cores that sit outside the L3 shared by
+// the P-cores, such as the SoC-tile LP E-cores on Meteor Lake. E-cores
+// without cache information stay E-cores.
+func markLowPowerCores(infos []CPUInfo) {
+	pCoreL3 := make(map[int]bool)
+	for _, info := range infos {
+		if info.CoreType == CoreTypePerformance && info.L3CacheID >= 0 {
+			pCoreL3[info.L3CacheID] = true
+		}
+	}
+	if len(pCoreL3) == 0 {
+		return
+	}
+	for i := range infos {
+		if infos[i].CoreType == CoreTypeEfficiency && infos[i].L3CacheID >= 0 && !pCoreL3[infos[i].L3CacheID] {
+			infos[i].CoreType = CoreTypeLowPower
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package topology
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const NodeBasePath = "/sys/devices/system/node"
+
+// readNUMANodes parses /sys/devices/system/node/node*. Kernels built without
+// NUMA support have no node directory; that yields no nodes and no error.
+func readNUMANodes(fsys fs.FS) ([]NUMANode, error) {
+	entries, err := fs.ReadDir(fsys, fsPath(NodeBasePath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var nodes []NUMANode
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "node") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "node"))
+		if err != nil {
+			continue
+		}
+		nodeDir := path.Join(NodeBasePath, entry.Name())
+
+		cpus, err := readOptionalList(fsys, path.Join(nodeDir, "cpulist"), nil)
+		if err != nil {
+			return nil, err
+		}
+		distances, err := readDistances(fsys, path.Join(nodeDir, "distance"))
+		if err != nil {
+			return nil, err
+		}
+		total, free, err := readNodeMeminfo(fsys, path.Join(nodeDir, "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, NUMANode{
+			ID:         id,
+			CPUs:       cpus,
+			Distances:  distances,
+			MemTotalKB: total,
+			MemFreeKB:  free,
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes, nil
+}
+
+func readDistances(fsys fs.FS, p string) ([]int, error) {
+	data, err := fs.ReadFile(fsys, fsPath(p))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	distances := make([]int, 0, len(fields))
+	for _, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		distances = append(distances, value)
+	}
+	return distances, nil
+}
+
+// readNodeMeminfo returns MemTotal and MemFree in kB from lines such as
+// "Node 0 MemTotal:       65843720 kB".
+func readNodeMeminfo(fsys fs.FS, p string) (int64, int64, error) {
+	data, err := fs.ReadFile(fsys, fsPath(p))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var total, free int64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[2] {
+		case "MemTotal:":
+			total = value
+		case "MemFree:":
+			free = value
+		}
+	}
+	return total, free, nil
+}
+
+// linkNUMANodes attaches the NUMA node list to the topology and records on
+// each core group the node that holds its CPUs.
+func linkNUMANodes(topo *CPUTopology, nodes []NUMANode) {
+	topo.NUMANodes = nodes
+
+	nodeOf := make(map[int]int)
+	for _, node := range nodes {
+		for _, cpu := range node.CPUs {
+			nodeOf[cpu] = node.ID
+		}
+	}
+
+	groupNode := func(cg *CoreGroup) {
+		cg.NUMANodeID = -1
+		if len(cg.AllCPUs) == 0 {
+			return
+		}
+		if id, ok := nodeOf[cg.AllCPUs[0]]; ok {
+			cg.NUMANodeID = id
+		}
+	}
+
+	for i := range topo.CoreGroups {
+		groupNode(&topo.CoreGroups[i])
+	}
+	for i := range topo.Packages {
+		for j := range topo.Packages[i].CoreGroups {
+			groupNode(&topo.Packages[i].CoreGroups[j])
+		}
+	}
+}
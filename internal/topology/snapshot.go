@@ -0,0 +1,215 @@
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion 2 stores file contents as bytes (base64 in JSON); version
+// 1 stored them as strings, which mangled binary device reads.
+const SnapshotVersion = 2
+
+const hostnamePath = "/proc/sys/kernel/hostname"
+
+// Snapshot holds every sysfs/procfs file the detector read on a host, so the
+// same topology can be rebuilt elsewhere.
+type Snapshot struct {
+	Version    int               `json:"version"`
+	Hostname   string            `json:"hostname,omitempty"`
+	CapturedAt time.Time         `json:"captured_at"`
+	Dirs       []string          `json:"dirs"`
+	Files      map[string][]byte `json:"files"`
+}
+
+// Capture runs detection against fsys and records the files it touched.
+func Capture(fsys fs.FS) (*Snapshot, error) {
+	rec := newRecordingFS(fsys)
+	if _, err := DetectFS(rec); err != nil {
+		return nil, err
+	}
+
+	hostname := ""
+	if data, err := fs.ReadFile(rec, fsPath(hostnamePath)); err == nil {
+		hostname = strings.TrimSpace(string(data))
+	}
+
+	dirs := make([]string, 0, len(rec.dirs))
+	for dir := range rec.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	return &Snapshot{
+		Version:    SnapshotVersion,
+		Hostname:   hostname,
+		CapturedAt: time.Now().UTC(),
+		Dirs:       dirs,
+		Files:      rec.files,
+	}, nil
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid snapshot %s: %v", ErrTopologyUnavailable, filename, err)
+	}
+
+	var snap Snapshot
+	switch header.Version {
+	case SnapshotVersion:
+		err = json.Unmarshal(data, &snap)
+	case 1:
+		err = unmarshalSnapshotV1(data, &snap)
+	default:
+		return nil, fmt.Errorf("%w: unsupported snapshot version %d", ErrTopologyUnavailable, header.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid snapshot %s: %v", ErrTopologyUnavailable, filename, err)
+	}
+	if len(snap.Files) == 0 {
+		return nil, fmt.Errorf("%w: snapshot %s contains no files", ErrTopologyUnavailable, filename)
+	}
+	return &snap, nil
+}
+
+// unmarshalSnapshotV1 reads a version 1 snapshot, whose files are strings.
+// Text files survive that encoding; cpuid reads may not, but they are only a
+// fallback behind the PMU lists.
+func unmarshalSnapshotV1(data []byte, snap *Snapshot) error {
+	var v1 struct {
+		Snapshot
+		Files map[string]string `json:"files"`
+	}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return err
+	}
+	*snap = v1.Snapshot
+	snap.Files = make(map[string][]byte, len(v1.Files))
+	for name, content := range v1.Files {
+		snap.Files[name] = []byte(content)
+	}
+	return nil
+}
+
+func (s *Snapshot) Save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+// FS exposes the captured files as a read-only filesystem rooted like the host.
+func (s *Snapshot) FS() fs.FS {
+	return newSnapshotFS(s.Files, s.Dirs)
+}
+
+// Detect rebuilds the topology from the captured files.
+func (s *Snapshot) Detect() (*CPUTopology, error) {
+	return DetectFS(s.FS())
+}
+
+// recordingFS wraps a filesystem and remembers every file read and every
+// subdirectory listed through it.
+type recordingFS struct {
+	fsys  fs.FS
+	files map[string][]byte
+	dirs  map[string]struct{}
+}
+
+func newRecordingFS(fsys fs.FS) *recordingFS {
+	return &recordingFS{
+		fsys:  fsys,
+		files: make(map[string][]byte),
+		dirs:  make(map[string]struct{}),
+	}
+}
+
+func (r *recordingFS) Open(name string) (fs.File, error) {
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if reader, ok := f.(io.ReaderAt); ok {
+		return &recordingFile{File: f, reader: reader, fs: r, name: name}, nil
+	}
+	return f, nil
+}
+
+// recordingFile keeps the bytes fetched by positioned reads, which is how
+// device files such as /dev/cpu/N/cpuid are queried. Unread gaps are saved
+// as zeros.
+type recordingFile struct {
+	fs.File
+	reader io.ReaderAt
+	fs     *recordingFS
+	name   string
+}
+
+func (f *recordingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.reader.ReadAt(p, off)
+	if n > 0 {
+		data := f.fs.files[f.name]
+		if end := off + int64(n); int64(len(data)) < end {
+			data = append(data, make([]byte, end-int64(len(data)))...)
+		}
+		copy(data[off:], p[:n])
+		f.fs.files[f.name] = data
+	}
+	return n, err
+}
+
+func (r *recordingFS) ReadFile(name string) ([]byte, error) {
+	data, err := fs.ReadFile(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	r.files[name] = bytes.Clone(data)
+	return data, nil
+}
+
+func (r *recordingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	r.dirs[name] = struct{}{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			r.dirs[path.Join(name, entry.Name())] = struct{}{}
+		}
+	}
+	return entries, nil
+}
+
+func (r *recordingFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		r.dirs[name] = struct{}{}
+	}
+	return info, nil
+}
+
+var (
+	_ fs.ReadFileFS = (*recordingFS)(nil)
+	_ fs.ReadDirFS  = (*recordingFS)(nil)
+	_ fs.StatFS     = (*recordingFS)(nil)
+)
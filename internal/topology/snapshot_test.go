@@ -0,0 +1,65 @@
+package topology
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSnapshotRoundTripKeepsBinaryFiles(t *testing.T) {
+	host := cpuidHost()
+	snap, err := Capture(fstest.MapFS(host))
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := snap.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	device := fsPath(cpuidDevicePath + "/4/cpuid")
+	if got := loaded.Files[device]; !reflect.DeepEqual(got, host[device].Data) {
+		t.Errorf("%s: got % x, want % x", device, got, host[device].Data)
+	}
+	topo, err := loaded.Detect()
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if topo.DetectMethod != "intel_hybrid/cpuid" {
+		t.Errorf("replay detected via %s, want intel_hybrid/cpuid", topo.DetectMethod)
+	}
+}
+
+func TestLoadSnapshotVersion1(t *testing.T) {
+	files := make(map[string]string)
+	for name, file := range amdHost("98304K", "32768K") {
+		files[name] = string(file.Data)
+	}
+	data, err := json.Marshal(map[string]any{"version": 1, "files": files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "v1.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	topo, err := snap.Detect()
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if len(topo.CoreGroups) != 2 || !topo.CoreGroups[0].VCache {
+		t.Errorf("got %d CCDs, first V-Cache %v", len(topo.CoreGroups), topo.CoreGroups[0].VCache)
+	}
+}
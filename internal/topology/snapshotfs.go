@@ -0,0 +1,147 @@
+package topology
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// snapshotFS serves a snapshot's files read-only. Directories are those the
+// snapshot recorded plus every parent of a file.
+type snapshotFS struct {
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newSnapshotFS(files map[string][]byte, dirs []string) *snapshotFS {
+	fsys := &snapshotFS{files: files, dirs: map[string]bool{".": true}}
+	addParents := func(name string) {
+		for dir := path.Dir(name); dir != "." && !fsys.dirs[dir]; dir = path.Dir(dir) {
+			fsys.dirs[dir] = true
+		}
+	}
+	for _, dir := range dirs {
+		fsys.dirs[dir] = true
+		addParents(dir)
+	}
+	for name := range files {
+		addParents(name)
+	}
+	return fsys
+}
+
+func (s *snapshotFS) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		entries, _ := s.ReadDir(name)
+		return &snapshotDir{info: info, entries: entries}, nil
+	}
+	return &snapshotFile{info: info, Reader: bytes.NewReader(s.files[name])}, nil
+}
+
+func (s *snapshotFS) ReadFile(name string) ([]byte, error) {
+	data, ok := s.files[name]
+	if !ok || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(data), nil
+}
+
+func (s *snapshotFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !s.dirs[name] {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	add := func(child string) {
+		if info, err := s.Stat(child); err == nil {
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	for dir := range s.dirs {
+		if dir != "." && path.Dir(dir) == name {
+			add(dir)
+		}
+	}
+	for file := range s.files {
+		if path.Dir(file) == name {
+			add(file)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *snapshotFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := s.files[name]; ok {
+		return snapshotInfo{name: path.Base(name), size: int64(len(data)), mode: 0o444}, nil
+	}
+	if s.dirs[name] {
+		return snapshotInfo{name: path.Base(name), mode: fs.ModeDir | 0o555}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// snapshotFile supports positioned reads, which device files such as
+// /dev/cpu/N/cpuid need.
+type snapshotFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *snapshotFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *snapshotFile) Close() error               { return nil }
+
+type snapshotDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *snapshotDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *snapshotDir) Close() error               { return nil }
+func (d *snapshotDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *snapshotDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+type snapshotInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i snapshotInfo) Name() string       { return i.name }
+func (i snapshotInfo) Size() int64        { return i.size }
+func (i snapshotInfo) Mode() fs.FileMode  { return i.mode }
+func (i snapshotInfo) ModTime() time.Time { return time.Time{} }
+func (i snapshotInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i snapshotInfo) Sys() any           { return nil }
+
+var (
+	_ fs.ReadFileFS = (*snapshotFS)(nil)
+	_ fs.ReadDirFS  = (*snapshotFS)(nil)
+	_ fs.StatFS     = (*snapshotFS)(nil)
+	_ io.ReaderAt   = (*snapshotFile)(nil)
+)
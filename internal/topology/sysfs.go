@@ -2,17 +2,26 @@ package topology
 
 import (
 	"errors"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-const SysfsBasePath = "/sys/devices/system/cpu"
+const (
+	SysfsBasePath   = "/sys/devices/system/cpu"
+	ProcCPUInfoPath = "/proc/cpuinfo"
+)
+
+// fsPath converts an absolute host path into a path relative to the root of
+// the filesystem handed to DetectFS.
+func fsPath(p string) string {
+	return strings.TrimPrefix(path.Clean(p), "/")
+}
 
-func ReadIntFile(path string) (int, error) {
-	data, err := os.ReadFile(path)
+func ReadIntFile(fsys fs.FS, p string) (int, error) {
+	data, err := fs.ReadFile(fsys, fsPath(p))
 	if err != nil {
 		return 0, err
 	}
@@ -27,12 +36,17 @@ func ReadIntFile(path string) (int, error) {
 	return parsed, nil
 }
 
-func ReadListFile(path string) ([]int, error) {
-	data, err := os.ReadFile(path)
+func ReadListFile(fsys fs.FS, p string) ([]int, error) {
+	data, err := fs.ReadFile(fsys, fsPath(p))
 	if err != nil {
 		return nil, err
 	}
-	raw := strings.TrimSpace(string(data))
+	return ParseList(string(data))
+}
+
+// ParseList parses a kernel CPU list such as "0-3,8,10-11".
+func ParseList(s string) ([]int, error) {
+	raw := strings.TrimSpace(s)
 	if raw == "" {
 		return []int{}, nil
 	}
@@ -76,13 +90,13 @@ func ReadListFile(path string) ([]int, error) {
 	return dedupeSorted(values), nil
 }
 
-func FileExists(path string) bool {
-	_, err := os.Stat(path)
+func FileExists(fsys fs.FS, p string) bool {
+	_, err := fs.Stat(fsys, fsPath(p))
 	return err == nil
 }
 
-func ListCPUs() ([]int, error) {
-	entries, err := os.ReadDir(SysfsBasePath)
+func ListCPUs(fsys fs.FS) ([]int, error) {
+	entries, err := fs.ReadDir(fsys, fsPath(SysfsBasePath))
 	if err != nil {
 		return nil, err
 	}
@@ -114,31 +128,66 @@ func ListCPUs() ([]int, error) {
 	return cpus, nil
 }
 
+// readCPUStateList reads one of the global CPU lists in the sysfs base
+// directory (online, isolated, nohz_full). A missing file yields ok=false.
+// nohz_full reads "(null)" when the feature is off; that is an empty list.
+func readCPUStateList(fsys fs.FS, name string) ([]int, bool, error) {
+	data, err := fs.ReadFile(fsys, fsPath(path.Join(SysfsBasePath, name)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	raw := strings.TrimSpace(string(data))
+	if raw == "(null)" {
+		return []int{}, true, nil
+	}
+	values, err := ParseList(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	return values, true, nil
+}
+
 func cpuPath(cpuID int, element string) string {
-	return filepath.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "topology", element)
+	return path.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "topology", element)
 }
 
 // cpuCachePath returns path to CPU cache info
 // e.g., /sys/devices/system/cpu/cpu0/cache/index3/id
 func cpuCachePath(cpuID int, index int, element string) string {
-	return filepath.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cache", "index"+strconv.Itoa(index), element)
+	return path.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cache", "index"+strconv.Itoa(index), element)
 }
 
 // cpuCapacityPath returns path to CPU capacity file
 // e.g., /sys/devices/system/cpu/cpu0/cpu_capacity
 // Used to detect Intel hybrid P-cores vs E-cores (P-cores ~1024, E-cores ~600-750)
 func cpuCapacityPath(cpuID int) string {
-	return filepath.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cpu_capacity")
+	return path.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cpu_capacity")
+}
+
+// cpuMaxFreqPath returns path to the CPU's maximum frequency in kHz
+// e.g., /sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq
+func cpuMaxFreqPath(cpuID int) string {
+	return path.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cpufreq", "cpuinfo_max_freq")
 }
 
 // ReadL3CacheID reads the L3 cache ID for a CPU
 // L3 cache is typically index3, shared by cores in the same CCD
-func ReadL3CacheID(cpuID int) (int, error) {
+func ReadL3CacheID(fsys fs.FS, cpuID int) (int, error) {
+	id, _, err := ReadL3Cache(fsys, cpuID)
+	return id, err
+}
+
+// ReadL3Cache reads the L3 cache ID and size in KiB for a CPU. The size is
+// 0 when the kernel does not report it.
+func ReadL3Cache(fsys fs.FS, cpuID int) (int, int, error) {
 	// First, find which cache index is L3
-	cacheBase := filepath.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cache")
-	entries, err := os.ReadDir(cacheBase)
+	cacheBase := path.Join(SysfsBasePath, "cpu"+strconv.Itoa(cpuID), "cache")
+	entries, err := fs.ReadDir(fsys, fsPath(cacheBase))
 	if err != nil {
-		return -1, err
+		return -1, 0, err
 	}
 
 	for _, entry := range entries {
@@ -147,20 +196,54 @@ func ReadL3CacheID(cpuID int) (int, error) {
 		}
 
 		// Check cache level
-		levelPath := filepath.Join(cacheBase, entry.Name(), "level")
-		level, err := ReadIntFile(levelPath)
+		levelPath := path.Join(cacheBase, entry.Name(), "level")
+		level, err := ReadIntFile(fsys, levelPath)
 		if err != nil {
 			continue
 		}
 
 		// L3 cache is level 3
 		if level == 3 {
-			idPath := filepath.Join(cacheBase, entry.Name(), "id")
-			return ReadIntFile(idPath)
+			idPath := path.Join(cacheBase, entry.Name(), "id")
+			id, err := ReadIntFile(fsys, idPath)
+			if err != nil {
+				return -1, 0, err
+			}
+			size, _ := readCacheSize(fsys, path.Join(cacheBase, entry.Name(), "size"))
+			return id, size, nil
 		}
 	}
 
-	return -1, errors.New("L3 cache not found")
+	return -1, 0, errors.New("L3 cache not found")
+}
+
+// readCacheSize parses a cache size file such as "32768K" into KiB.
+func readCacheSize(fsys fs.FS, p string) (int, error) {
+	data, err := fs.ReadFile(fsys, fsPath(p))
+	if err != nil {
+		return 0, err
+	}
+	raw := strings.TrimSpace(string(data))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(raw, "K"):
+		raw = strings.TrimSuffix(raw, "K")
+	case strings.HasSuffix(raw, "M"):
+		raw = strings.TrimSuffix(raw, "M")
+		multiplier = 1024
+	default:
+		// A bare number is in bytes.
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, err
+		}
+		return value / 1024, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	return value * multiplier, nil
 }
 
 func dedupeSorted(values []int) []int {
@@ -1,5 +1,7 @@
 package topology
 
+import "sort"
+
 type Architecture string
 
 const (
@@ -13,6 +15,7 @@ type CoreType string
 const (
 	CoreTypePerformance CoreType = "performance"
 	CoreTypeEfficiency  CoreType = "efficiency"
+	CoreTypeLowPower    CoreType = "low_power_efficiency"
 	CoreTypeUnknown     CoreType = "unknown"
 )
 
@@ -23,7 +26,18 @@ type CPUTopology struct {
 	HasSMT       bool         `json:"has_smt"`
 	Packages     []Package    `json:"packages"`
 	CoreGroups   []CoreGroup  `json:"core_groups"`
+	NUMANodes    []NUMANode   `json:"numa_nodes"`
 	DetectMethod string       `json:"detect_method"`
+	// ThreadSiblings maps the first thread of every core to all of the
+	// core's hardware threads, as read from thread_siblings_list.
+	ThreadSiblings map[int][]int `json:"thread_siblings"`
+	// Hierarchy is the cache/die tree: package → die (CCD) → L3 domain
+	// (CCX) → core → thread.
+	Hierarchy []Domain `json:"hierarchy"`
+	// OfflineCPUs are present but not online; they appear in no core group.
+	OfflineCPUs  []int `json:"offline_cpus"`
+	IsolatedCPUs []int `json:"isolated_cpus"`
+	NoHZFullCPUs []int `json:"nohz_full_cpus"`
 }
 
 type Package struct {
@@ -37,10 +51,44 @@ type CoreGroup struct {
 	Type         CoreType `json:"type"`
 	Name         string   `json:"name"`
 	L3CacheID    int      `json:"l3_cache_id"`
+	L3SizeKB     int      `json:"l3_size_kb"`
+	VCache       bool     `json:"vcache"`
+	MaxFreqKHz   int      `json:"max_freq_khz"`
+	DieID        int      `json:"die_id"`
+	NUMANodeID   int      `json:"numa_node_id"`
 	PhysicalCPUs []int    `json:"physical_cpus"`
 	AllCPUs      []int    `json:"all_cpus"`
 }
 
+type DomainLevel string
+
+const (
+	LevelPackage DomainLevel = "package"
+	LevelDie     DomainLevel = "die"
+	LevelL3      DomainLevel = "l3"
+	LevelCore    DomainLevel = "core"
+	LevelThread  DomainLevel = "thread"
+)
+
+// Domain is one node of the topology tree; see buildHierarchy for what ID
+// means at each level.
+type Domain struct {
+	Level    DomainLevel `json:"level"`
+	ID       int         `json:"id"`
+	CPUs     []int       `json:"cpus"`
+	Children []Domain    `json:"children,omitempty"`
+}
+
+// NUMANode is a memory node as reported under /sys/devices/system/node.
+// Distances are indexed by node ID, as in the kernel's distance file.
+type NUMANode struct {
+	ID         int   `json:"id"`
+	CPUs       []int `json:"cpus"`
+	Distances  []int `json:"distances"`
+	MemTotalKB int64 `json:"mem_total_kb"`
+	MemFreeKB  int64 `json:"mem_free_kb"`
+}
+
 func (g *CoreGroup) IsCCD() bool {
 	return g.Type == CoreTypeUnknown && g.L3CacheID >= 0
 }
@@ -53,6 +101,12 @@ func (g *CoreGroup) IsECore() bool {
 	return g.Type == CoreTypeEfficiency
 }
 
+// IsLPECore reports a group of low-power E-cores outside the compute tile's
+// L3, such as the SoC-tile cores on Meteor Lake.
+func (g *CoreGroup) IsLPECore() bool {
+	return g.Type == CoreTypeLowPower
+}
+
 type CPUInfo struct {
 	ID             int
 	PackageID      int
@@ -60,10 +114,124 @@ type CPUInfo struct {
 	ClusterID      int
 	DieID          int
 	L3CacheID      int
+	L3SizeKB       int
+	MaxFreqKHz     int
 	ThreadSiblings []int
 	IsFirstThread  bool
 	CoreType       CoreType
 	Capacity       int
+	Online         bool
+	Isolated       bool
+	NoHZFull       bool
+}
+
+// Threads returns every hardware thread of the core that cpu belongs to,
+// in ascending order. A CPU with no recorded siblings is its own core.
+func (t *CPUTopology) Threads(cpu int) []int {
+	if siblings, ok := t.ThreadSiblings[cpu]; ok {
+		result := make([]int, len(siblings))
+		copy(result, siblings)
+		return result
+	}
+	for _, siblings := range t.ThreadSiblings {
+		for _, s := range siblings {
+			if s == cpu {
+				result := make([]int, len(siblings))
+				copy(result, siblings)
+				return result
+			}
+		}
+	}
+	return []int{cpu}
+}
+
+// ThreadsPerCore returns the widest SMT width found on any core: 1 without
+// SMT, 2 for common x86 parts, 4 or more on some other platforms.
+func (t *CPUTopology) ThreadsPerCore() int {
+	width := 1
+	for _, siblings := range t.ThreadSiblings {
+		if len(siblings) > width {
+			width = len(siblings)
+		}
+	}
+	return width
+}
+
+func (t *CPUTopology) IsIsolated(cpu int) bool {
+	return containsInt(t.IsolatedCPUs, cpu)
+}
+
+// Subset returns a copy of the topology that only contains the CPUs keep
+// accepts. Core groups stay in place, possibly empty, so group indices keep
+// their meaning; the first kept thread of each core becomes its physical CPU.
+func (t *CPUTopology) Subset(keep func(cpu int) bool) *CPUTopology {
+	firstOf := make(map[int]int)
+	siblings := make(map[int][]int)
+	for _, threads := range t.ThreadSiblings {
+		var kept []int
+		for _, cpu := range threads {
+			if keep(cpu) {
+				kept = append(kept, cpu)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		siblings[kept[0]] = kept
+		for _, cpu := range kept {
+			firstOf[cpu] = kept[0]
+		}
+	}
+
+	sub := *t
+	sub.ThreadSiblings = siblings
+	sub.TotalCPUs = 0
+	sub.TotalCores = 0
+
+	filterGroup := func(cg CoreGroup) CoreGroup {
+		out := cg
+		out.AllCPUs = nil
+		out.PhysicalCPUs = nil
+		for _, cpu := range cg.AllCPUs {
+			if !keep(cpu) {
+				continue
+			}
+			out.AllCPUs = append(out.AllCPUs, cpu)
+			if first, ok := firstOf[cpu]; !ok || first == cpu {
+				out.PhysicalCPUs = append(out.PhysicalCPUs, cpu)
+			}
+		}
+		return out
+	}
+
+	sub.CoreGroups = make([]CoreGroup, len(t.CoreGroups))
+	for i, cg := range t.CoreGroups {
+		sub.CoreGroups[i] = filterGroup(cg)
+		sub.TotalCPUs += len(sub.CoreGroups[i].AllCPUs)
+		sub.TotalCores += len(sub.CoreGroups[i].PhysicalCPUs)
+	}
+	sub.Packages = make([]Package, len(t.Packages))
+	for i, pkg := range t.Packages {
+		groups := make([]CoreGroup, len(pkg.CoreGroups))
+		for j, cg := range pkg.CoreGroups {
+			groups[j] = filterGroup(cg)
+		}
+		sub.Packages[i] = Package{ID: pkg.ID, CoreGroups: groups}
+	}
+	sub.HasSMT = sub.TotalCPUs > sub.TotalCores
+	return &sub
+}
+
+// NUMANodeOf returns the NUMA node that holds cpu, or -1 if unknown.
+func (t *CPUTopology) NUMANodeOf(cpu int) int {
+	for _, node := range t.NUMANodes {
+		for _, c := range node.CPUs {
+			if c == cpu {
+				return node.ID
+			}
+		}
+	}
+	return -1
 }
 
 func (t *CPUTopology) CCDs() []CoreGroup {
@@ -96,6 +264,27 @@ func (t *CPUTopology) ECores() []CoreGroup {
 	return ecores
 }
 
+func (t *CPUTopology) LPECores() []CoreGroup {
+	var lpecores []CoreGroup
+	for _, g := range t.CoreGroups {
+		if g.IsLPECore() {
+			lpecores = append(lpecores, g)
+		}
+	}
+	return lpecores
+}
+
+// OnlineCPUs returns every online CPU in ascending order, the mask a thread
+// has before anything pins it.
+func (t *CPUTopology) OnlineCPUs() []int {
+	var cpus []int
+	for _, g := range t.CoreGroups {
+		cpus = append(cpus, g.AllCPUs...)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
 func (t *CPUTopology) GetPCoresCPUs() []int {
 	var cpus []int
 	for _, g := range t.CoreGroups {
@@ -116,6 +305,16 @@ func (t *CPUTopology) GetECoresCPUs() []int {
 	return cpus
 }
 
+func (t *CPUTopology) GetLPECoresCPUs() []int {
+	var cpus []int
+	for _, g := range t.CoreGroups {
+		if g.IsLPECore() {
+			cpus = append(cpus, g.PhysicalCPUs...)
+		}
+	}
+	return cpus
+}
+
 func (t *CPUTopology) GetAllPCoresVCPUs() []int {
 	var cpus []int
 	for _, g := range t.CoreGroups {
@@ -155,3 +354,22 @@ func (t *CPUTopology) TotalECores() int {
 	}
 	return count
 }
+
+func (t *CPUTopology) TotalLPECores() int {
+	count := 0
+	for _, g := range t.CoreGroups {
+		if g.IsLPECore() {
+			count += len(g.PhysicalCPUs)
+		}
+	}
+	return count
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
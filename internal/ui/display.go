@@ -3,9 +3,16 @@ package ui
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/charmbracelet/lipgloss"
+
 	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/cluster"
+	"epyc-pve/internal/host"
+	"epyc-pve/internal/journal"
 	"epyc-pve/internal/pve"
 	"epyc-pve/internal/topology"
 )
@@ -23,11 +30,13 @@ func PrintTopology(topo *topology.CPUTopology) {
 	b.WriteString("\n\n")
 
 	var info strings.Builder
-	info.WriteString(fmt.Sprintf("  %s %d    %s %d    %s %s    %s %s\n",
+	info.WriteString(fmt.Sprintf("  %s %d    %s %d    %s %s    %s %d    %s %s\n",
 		packageStyle.Render("Cores:"), topo.TotalCores,
 		vcpuStyle.Render("vCPUs:"), topo.TotalCPUs,
 		dimStyle.Render("SMT:"), formatBoolDisplay(topo.HasSMT),
+		dimStyle.Render("NUMA:"), len(topo.NUMANodes),
 		dimStyle.Render("Method:"), highlightStyle.Render(topo.DetectMethod)))
+	info.WriteString(formatCPUStates(topo))
 	info.WriteString("\n")
 
 	for _, pkg := range topo.Packages {
@@ -46,10 +55,7 @@ func PrintTopology(topo *topology.CPUTopology) {
 			if i == len(pkg.CoreGroups)-1 {
 				prefix = "└─"
 			}
-			l3Info := ""
-			if cg.L3CacheID >= 0 {
-				l3Info = dimStyle.Render(fmt.Sprintf(" [L3#%d]", cg.L3CacheID))
-			}
+			l3Info := formatGroupTags(cg)
 			label := cg.Name
 			if label == "" {
 				label = fmt.Sprintf("CCD %d", cg.ID)
@@ -62,6 +68,24 @@ func PrintTopology(topo *topology.CPUTopology) {
 		}
 	}
 
+	if len(topo.NUMANodes) > 0 {
+		info.WriteString("\n")
+		for _, node := range topo.NUMANodes {
+			info.WriteString(fmt.Sprintf("  %s %d  %s  %s\n",
+				packageStyle.Render("🧠 NUMA Node"), node.ID,
+				vcpuStyle.Render(affinity.FormatCPUs(node.CPUs)),
+				dimStyle.Render(formatNUMAMemory(node))))
+			if len(node.Distances) > 0 {
+				distances := make([]string, len(node.Distances))
+				for i, d := range node.Distances {
+					distances[i] = fmt.Sprintf("%d", d)
+				}
+				info.WriteString(fmt.Sprintf("     %s %s\n",
+					dimStyle.Render("└─ distances:"), strings.Join(distances, " ")))
+			}
+		}
+	}
+
 	fmt.Println(boxStyle.Render(b.String() + info.String()))
 }
 
@@ -89,6 +113,15 @@ func PrintOptions(options []affinity.Option, usePhysical bool) {
 
 		if available {
 			fmt.Printf("      %s: %s  CCDs: %d\n", coreType, vcpuStyle.Render(option.AffinityStr), option.CCDsUsed)
+			if len(option.EmulatorCPUs) > 0 {
+				fmt.Printf("      Emulator/IO: %s\n", vcpuStyle.Render(affinity.FormatCPUs(option.EmulatorCPUs)))
+			}
+			if overlap := formatOverlap(&option); overlap != "" {
+				fmt.Printf("      %s\n", highlightStyle.Render(overlap))
+			}
+			for _, line := range option.NUMAConfig() {
+				fmt.Printf("      %s\n", dimStyle.Render(line))
+			}
 		} else {
 			fmt.Printf("      %s: %s\n", coreType, dimStyle.Render("unavailable"))
 		}
@@ -122,13 +155,272 @@ func PrintVMs(vms []pve.VM) {
 	fmt.Println()
 }
 
-func PrintSuccess(vmid int, affinityStr string) {
-	content := fmt.Sprintf("✓ Successfully applied affinity to VM %d\n\n  Affinity: %s", vmid, affinityStr)
+// PrintSuccess reports an applied option; liveThreads is the number of
+// running QEMU threads re-pinned, if any, and hookscript the hookscript
+// written for the VM, if any.
+func PrintSuccess(vmid int, option *affinity.Option, liveThreads int, hookscript string) {
+	content := fmt.Sprintf("✓ Successfully applied affinity to VM %d\n\n  Affinity: %s", vmid, option.AffinityStr)
+	if len(option.EmulatorCPUs) > 0 {
+		content += "\n  Emulator: " + affinity.FormatCPUs(option.EmulatorCPUs)
+	}
+	if numa := option.NUMAConfig(); len(numa) > 0 {
+		content += "\n  NUMA:     " + strings.Join(numa, "\n            ")
+	}
+	if liveThreads > 0 {
+		content += fmt.Sprintf("\n  Live:     %d running threads re-pinned", liveThreads)
+	}
+	if hookscript != "" {
+		content += "\n  Hook:     " + hookscript
+	}
+	fmt.Println()
+	fmt.Println(successBoxStyle.Render(content))
+	fmt.Println()
+}
+
+// PrintReset reports a removed affinity; cleared says whether the VM config
+// changed and liveThreads is the number of running threads unpinned.
+func PrintReset(vmid int, cleared bool, liveThreads int) {
+	content := fmt.Sprintf("✓ Reset affinity of VM %d\n", vmid)
+	if cleared {
+		content += "\n  Config:   affinity removed, all host CPUs from the next start"
+	}
+	if liveThreads > 0 {
+		content += fmt.Sprintf("\n  Live:     %d running threads unpinned", liveThreads)
+	}
 	fmt.Println()
 	fmt.Println(successBoxStyle.Render(content))
 	fmt.Println()
 }
 
+// PrintResetDryRun shows the commands a reset would run.
+func PrintResetDryRun(vmid int, commands []string) {
+	content := fmt.Sprintf("DRY RUN - Would reset:\n\n  VM: %d", vmid)
+	for _, command := range commands {
+		content += "\n  Command: " + command
+	}
+	fmt.Println()
+	fmt.Println(boxStyle.Render(content))
+	fmt.Println()
+}
+
+// PrintHistory lists journal entries, oldest first.
+func PrintHistory(entries []journal.Entry) {
+	fmt.Println(subtitleStyle.Render("Affinity History"))
+	fmt.Println()
+
+	if len(entries) == 0 {
+		fmt.Println(dimStyle.Render("  No changes recorded"))
+		fmt.Println()
+		return
+	}
+
+	fmt.Println(dimStyle.Render(fmt.Sprintf("  %-5s %-20s %-6s %-14s %-12s %s", "#", "Time", "VMID", "Strategy", "Topology", "Change")))
+	for _, entry := range entries {
+		change := fmt.Sprintf("%s → %s", formatJournalAffinity(entry.Previous), vcpuStyle.Render(formatJournalAffinity(entry.Affinity)))
+		if len(entry.Config) > 0 {
+			change += dimStyle.Render(" (also " + strings.Join(sortedKeys(entry.Config), ", ") + ")")
+		}
+		fmt.Printf("  %-5d %-20s %-6d %-14s %-12s %s\n",
+			entry.Seq, entry.Time.Local().Format("2006-01-02 15:04:05"), entry.VMID, entry.Strategy, entry.Topology, change)
+	}
+	fmt.Println()
+}
+
+func formatJournalAffinity(affinity string) string {
+	if affinity == "" {
+		return "none"
+	}
+	return affinity
+}
+
+// sortedKeys returns the keys of settings in order.
+func sortedKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PrintRollback reports a rollback from one affinity to another, along with
+// the other settings it restores; commands are shown for dry runs.
+func PrintRollback(vmid int, from, to string, restored, commands []string, dryRun bool) {
+	if dryRun {
+		content := fmt.Sprintf("DRY RUN - Would roll back:\n\n  VM: %d\n  Affinity: %s → %s",
+			vmid, formatJournalAffinity(from), formatJournalAffinity(to))
+		if len(restored) > 0 {
+			content += "\n  Also restores: " + strings.Join(restored, ", ")
+		}
+		for _, command := range commands {
+			content += "\n  Command: " + command
+		}
+		fmt.Println()
+		fmt.Println(boxStyle.Render(content))
+		fmt.Println()
+		return
+	}
+	content := fmt.Sprintf("✓ Rolled back VM %d\n\n  Affinity: %s → %s", vmid, formatJournalAffinity(from), formatJournalAffinity(to))
+	if len(restored) > 0 {
+		content += "\n  Also restored: " + strings.Join(restored, ", ")
+	}
+	if from == to && len(restored) == 0 {
+		content = fmt.Sprintf("✓ VM %d already has affinity %s", vmid, formatJournalAffinity(to))
+	}
+	fmt.Println()
+	fmt.Println(successBoxStyle.Render(content))
+	fmt.Println()
+}
+
+// PrintClusterStatus lists the cluster's nodes with where their topology
+// comes from.
+func PrintClusterStatus(c *cluster.Cluster) {
+	title := "Cluster"
+	if c.Name != "" {
+		title += " " + c.Name
+	}
+	fmt.Println(subtitleStyle.Render(title))
+	fmt.Println()
+	fmt.Println(dimStyle.Render(fmt.Sprintf("  %-14s %-8s %-32s %-34s %s", "Node", "Status", "CPUs", "Topology", "VMs (pinned)")))
+	for _, node := range c.Nodes {
+		name := node.Name
+		if node.Local {
+			name += " *"
+		}
+		status := coreStyle.Render(fmt.Sprintf("%-8s", "online"))
+		if !node.Online {
+			status = lipgloss.NewStyle().Foreground(errorColor).Render(fmt.Sprintf("%-8s", "offline"))
+		}
+
+		cpus, source := "-", highlightStyle.Render("no snapshot")
+		if node.Topology != nil {
+			topo := node.Topology
+			cpus = fmt.Sprintf("%s, %d pkg, %d cores/%d CPUs", topo.Architecture, len(topo.Packages), topo.TotalCores, topo.TotalCPUs)
+			source = "live " + topo.Fingerprint()
+			if !node.SnapshotAt.IsZero() {
+				source = "snapshot " + node.SnapshotAt.Local().Format("2006-01-02") + " " + topo.Fingerprint()
+			}
+		}
+
+		pinned := 0
+		for _, vm := range node.VMs {
+			if vm.Affinity != "" {
+				pinned++
+			}
+		}
+		fmt.Printf("  %-14s %s %-32s %-34s %d (%d)\n", name, status, cpus, source, len(node.VMs), pinned)
+	}
+	fmt.Println()
+
+	for _, node := range c.Nodes {
+		for _, vm := range node.VMs {
+			for _, problem := range vm.Problems {
+				PrintWarning(fmt.Sprintf("VM %d on %s: %s", vm.VMID, node.Name, problem))
+			}
+		}
+	}
+}
+
+// PrintClusterCheck lists the problems found with pinned VMs.
+func PrintClusterCheck(findings []cluster.Finding) {
+	fmt.Println(subtitleStyle.Render("Affinity Check"))
+	fmt.Println()
+	if len(findings) == 0 {
+		fmt.Println(coreStyle.Render("  ✓ Every pinned VM fits its node"))
+		fmt.Println()
+		return
+	}
+	for _, finding := range findings {
+		if finding.VMID == 0 {
+			fmt.Printf("  %s\n", highlightStyle.Render(finding.Node))
+		} else {
+			fmt.Printf("  %s  VM %d %s  %s\n", highlightStyle.Render(finding.Node), finding.VMID, finding.Name, vcpuStyle.Render(finding.Affinity))
+		}
+		for _, problem := range finding.Problems {
+			line := "⚠ " + problem.Message
+			if problem.Severity == affinity.SeverityError {
+				fmt.Printf("      %s\n", lipgloss.NewStyle().Foreground(errorColor).Render("✗ "+problem.Message))
+				continue
+			}
+			fmt.Printf("      %s\n", dimStyle.Render(line))
+		}
+	}
+	fmt.Println()
+}
+
+// PrintClusterPlan introduces the options computed for a VM on its node.
+func PrintClusterPlan(node *cluster.Node, vm *pve.VM) {
+	source := "live topology"
+	if !node.SnapshotAt.IsZero() {
+		source = "snapshot of " + node.SnapshotAt.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("  VM %d %s on %s (%s)\n", vm.VMID, vm.Name, highlightStyle.Render(node.Name), dimStyle.Render(source))
+	if vm.Affinity != "" {
+		fmt.Printf("  Current affinity: %s\n", vcpuStyle.Render(vm.Affinity))
+	}
+	fmt.Println()
+}
+
+// PrintMigrationCheck shows how a VM's affinity fares on a target node.
+func PrintMigrationCheck(r *cluster.MigrationReport) {
+	fmt.Println(subtitleStyle.Render(fmt.Sprintf("Migration check: VM %d %s, %s → %s", r.VMID, r.Name, r.Source, r.Target)))
+	fmt.Println()
+	if r.Affinity == "" {
+		fmt.Println(coreStyle.Render("  ✓ VM has no affinity, nothing to check"))
+		fmt.Println()
+		return
+	}
+
+	fmt.Printf("  Affinity: %s\n", vcpuStyle.Render(r.Affinity))
+	if r.Before != nil {
+		fmt.Printf("  On %-8s %s\n", r.Source+":", dimStyle.Render(r.Before.String()))
+	}
+	fmt.Printf("  On %-8s %s\n", r.Target+":", dimStyle.Render(r.After.String()))
+	fmt.Println()
+
+	switch {
+	case !r.Compatible():
+		fmt.Println(lipgloss.NewStyle().Foreground(errorColor).Render("  ✗ The affinity does not fit " + r.Target))
+	case !r.KeepsLocality():
+		fmt.Println(highlightStyle.Render("  ⚠ The affinity works on " + r.Target + " but loses its locality"))
+	default:
+		fmt.Println(coreStyle.Render("  ✓ The affinity fits " + r.Target + " with the same locality"))
+	}
+	for _, problem := range r.Problems {
+		fmt.Printf("      %s\n", dimStyle.Render(problem.String()))
+	}
+	fmt.Println()
+
+	if r.Proposed == "" {
+		fmt.Println(dimStyle.Render("  No equivalent affinity is available on " + r.Target))
+	} else if r.Proposed != r.Affinity {
+		source := "translated"
+		if r.ProposedStrategy != "" {
+			source = string(r.ProposedStrategy)
+		} else if len(r.ProposedRelaxed) > 0 {
+			source += ", without " + strings.Join(r.ProposedRelaxed, ", ")
+		}
+		fmt.Printf("  Proposed for %s: %s  %s\n", r.Target, vcpuStyle.Render(r.Proposed), dimStyle.Render("("+source+")"))
+		fmt.Printf("  %s\n", dimStyle.Render(r.ProposedLocality.String()))
+		fmt.Printf("  %s\n", dimStyle.Render(fmt.Sprintf("After migrating: qm set %d --affinity %s", r.VMID, r.Proposed)))
+	}
+	fmt.Println()
+}
+
+// PrintTranslation shows an affinity and its translation with the locality
+// of each on its own topology.
+func PrintTranslation(from string, t *affinity.Translation, before, after affinity.Locality) {
+	fmt.Println(subtitleStyle.Render("Translated affinity"))
+	fmt.Println()
+	fmt.Printf("  From: %s  %s\n", vcpuStyle.Render(from), dimStyle.Render(before.String()))
+	fmt.Printf("  To:   %s  %s\n", vcpuStyle.Render(t.AffinityStr), dimStyle.Render(after.String()))
+	if len(t.Relaxed) > 0 {
+		fmt.Println()
+		fmt.Println(highlightStyle.Render("  ⚠ The destination cannot keep: " + strings.Join(t.Relaxed, ", ")))
+	}
+	fmt.Println()
+}
+
 func PrintError(err error) {
 	content := fmt.Sprintf("✗ Error: %v", err)
 	fmt.Fprintln(os.Stderr)
@@ -136,14 +428,169 @@ func PrintError(err error) {
 	fmt.Fprintln(os.Stderr)
 }
 
-func PrintDryRun(vmid int, affinityStr string) {
-	content := fmt.Sprintf("DRY RUN - Would apply:\n\n  VM: %d\n  Affinity: %s\n  Command: qm set %d --affinity %s",
-		vmid, affinityStr, vmid, affinityStr)
+func PrintWarning(message string) {
+	fmt.Fprintln(os.Stderr, highlightStyle.Render("⚠ "+message))
+}
+
+// PrintDryRun shows the commands applying option would run.
+func PrintDryRun(vmid int, option *affinity.Option, commands []string) {
+	content := fmt.Sprintf("DRY RUN - Would apply:\n\n  VM: %d\n  Affinity: %s", vmid, option.AffinityStr)
+	if len(option.EmulatorCPUs) > 0 {
+		content += "\n  Emulator/IO: " + affinity.FormatCPUs(option.EmulatorCPUs)
+	}
+	for _, command := range commands {
+		content += "\n  Command: " + command
+	}
+	if overlap := formatOverlap(option); overlap != "" {
+		content += "\n\n  " + overlap
+	}
 	fmt.Println()
 	fmt.Println(boxStyle.Render(content))
 	fmt.Println()
 }
 
+// formatOverlap describes which pinned VMs share CPUs with the option, or
+// returns "" if none do.
+func formatOverlap(option *affinity.Option) string {
+	if option.Overlap == 0 {
+		return ""
+	}
+	vmids := make([]string, len(option.OverlapVMs))
+	for i, vmid := range option.OverlapVMs {
+		vmids[i] = strconv.Itoa(vmid)
+	}
+	return fmt.Sprintf("⚠ %d of %d CPUs already pinned by VM %s",
+		option.Overlap, len(option.ProcessCPUs()), strings.Join(vmids, ", "))
+}
+
+func PrintCaptured(path string, snap *topology.Snapshot) {
+	host := snap.Hostname
+	if host == "" {
+		host = "unknown host"
+	}
+	content := fmt.Sprintf("✓ Captured topology of %s\n\n  Files: %d\n  Snapshot: %s\n\n  Replay with: --from-snapshot %s",
+		host, len(snap.Files), path, path)
+	fmt.Println()
+	fmt.Println(successBoxStyle.Render(content))
+	fmt.Println()
+}
+
+// PrintKernelCmdline shows the isolation parameters next to the running
+// kernel's values, and where they would be written. boot may be nil.
+func PrintKernelCmdline(iso *host.Isolation, changes []host.ParamChange, boot *host.Bootloader) {
+	fmt.Println(subtitleStyle.Render("Kernel isolation"))
+	fmt.Println()
+	fmt.Printf("  Isolated (VMs): %s\n", vcpuStyle.Render(affinity.FormatCPUs(iso.Isolated)))
+	fmt.Printf("  Housekeeping:   %s\n", vcpuStyle.Render(affinity.FormatCPUs(iso.Housekeeping)))
+	fmt.Println()
+	fmt.Println(dimStyle.Render("  Against /proc/cmdline:"))
+	for _, change := range changes {
+		switch {
+		case !change.Changed():
+			fmt.Printf("    %s\n", coreStyle.Render("  "+change.String()))
+		case change.Current == "":
+			fmt.Printf("    %s\n", highlightStyle.Render("+ "+change.String()))
+		default:
+			fmt.Printf("    %s\n", dimStyle.Render("- "+change.Key+"="+change.Current))
+			fmt.Printf("    %s\n", highlightStyle.Render("+ "+change.String()))
+		}
+	}
+	fmt.Println()
+	fmt.Println(dimStyle.Render("  Isolated CPUs are not load-balanced: give every vCPU its own CPU"))
+	fmt.Println(dimStyle.Render("  (--pin-vcpus with --hookscript) and plan new VMs with --pool isolated-only."))
+	if boot != nil {
+		fmt.Println(dimStyle.Render(fmt.Sprintf("  Boot configuration: %s (%s), update with --write", boot.Path, boot.Name)))
+	}
+	fmt.Println()
+}
+
+// PrintBootUpdate reports the command line written to the boot
+// configuration, or that would be with dryRun.
+func PrintBootUpdate(boot *host.Bootloader, cmdline string, dryRun bool) {
+	refresh := strings.Join(boot.Refresh, " ")
+	if dryRun {
+		content := fmt.Sprintf("DRY RUN - Would update %s:\n\n  %s\n\n  Command: %s", boot.Path, cmdline, refresh)
+		fmt.Println(boxStyle.Render(content))
+		fmt.Println()
+		return
+	}
+	content := fmt.Sprintf("✓ Updated %s (backup in %s.bak)\n\n  %s\n\n  Ran %s. Reboot to apply.", boot.Path, boot.Path, cmdline, refresh)
+	fmt.Println(successBoxStyle.Render(content))
+	fmt.Println()
+}
+
+// PrintSliceDropIns shows the systemd drop-ins written, or that would be
+// written with dryRun.
+func PrintSliceDropIns(paths []string, content string, dryRun bool) {
+	header := "✓ Wrote slice drop-ins"
+	style := successBoxStyle
+	if dryRun {
+		header = "DRY RUN - Would write slice drop-ins"
+		style = boxStyle
+	}
+	body := header + "\n"
+	for _, path := range paths {
+		body += "\n  " + path
+	}
+	body += "\n\n" + strings.TrimRight(content, "\n")
+	if !dryRun {
+		body += "\n\n  Activate with: systemctl daemon-reload"
+	}
+	fmt.Println()
+	fmt.Println(style.Render(body))
+	fmt.Println()
+}
+
+// formatGroupTags renders the L3, V-Cache and NUMA tags shown after a core
+// group's name.
+func formatGroupTags(cg topology.CoreGroup) string {
+	tags := ""
+	if cg.L3CacheID >= 0 {
+		l3 := fmt.Sprintf("L3#%d", cg.L3CacheID)
+		if cg.L3SizeKB > 0 {
+			l3 += fmt.Sprintf(" %dM", cg.L3SizeKB/1024)
+		}
+		tags += dimStyle.Render(" [" + l3 + "]")
+	}
+	if cg.VCache {
+		tags += " " + highlightStyle.Render("V-Cache")
+	}
+	if cg.NUMANodeID >= 0 {
+		tags += dimStyle.Render(fmt.Sprintf(" [N%d]", cg.NUMANodeID))
+	}
+	return tags
+}
+
+// formatCPUStates renders a line listing offline, isolated and nohz_full
+// CPUs, or nothing when the host has none of them.
+func formatCPUStates(topo *topology.CPUTopology) string {
+	var parts []string
+	if len(topo.OfflineCPUs) > 0 {
+		parts = append(parts, dimStyle.Render("Offline:")+" "+highlightStyle.Render(affinity.FormatCPUs(topo.OfflineCPUs)))
+	}
+	if len(topo.IsolatedCPUs) > 0 {
+		parts = append(parts, dimStyle.Render("Isolated:")+" "+highlightStyle.Render(affinity.FormatCPUs(topo.IsolatedCPUs)))
+	}
+	if len(topo.NoHZFullCPUs) > 0 {
+		parts = append(parts, dimStyle.Render("nohz_full:")+" "+highlightStyle.Render(affinity.FormatCPUs(topo.NoHZFullCPUs)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  " + strings.Join(parts, "    ") + "\n"
+}
+
+func formatNUMAMemory(node topology.NUMANode) string {
+	if node.MemTotalKB == 0 {
+		return "(memory unknown)"
+	}
+	return fmt.Sprintf("(%s, %s free)", formatKB(node.MemTotalKB), formatKB(node.MemFreeKB))
+}
+
+func formatKB(kb int64) string {
+	return fmt.Sprintf("%.1f GiB", float64(kb)/(1024*1024))
+}
+
 func formatBoolDisplay(b bool) string {
 	if b {
 		return coreStyle.Render("Yes")
@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/topology"
+)
+
+const coresPerTreeLine = 8
+
+// renderHierarchy draws the package → die → L3 → core → thread tree. Cores
+// are listed on their L3 line as thread groups such as "0+64".
+func renderHierarchy(domains []topology.Domain) string {
+	var b strings.Builder
+	for _, pkg := range domains {
+		b.WriteString(fmt.Sprintf("  %s %d  %s\n",
+			packageStyle.Render("Package"), pkg.ID,
+			dimStyle.Render(fmt.Sprintf("(%d threads)", len(pkg.CPUs)))))
+		for i, die := range pkg.Children {
+			dieLast := i == len(pkg.Children)-1
+			b.WriteString(fmt.Sprintf("  %s %s %d  %s\n",
+				treeBranch(dieLast), ccdStyle.Render("Die"), die.ID,
+				vcpuStyle.Render(affinity.FormatCPUs(die.CPUs))))
+			for j, l3 := range die.Children {
+				l3Last := j == len(die.Children)-1
+				label := "L3"
+				if l3.ID >= 0 {
+					label = fmt.Sprintf("L3#%d", l3.ID)
+				}
+				b.WriteString(fmt.Sprintf("  %s  %s %s  %s\n",
+					treeStem(dieLast), treeBranch(l3Last), ccdStyle.Render(label),
+					dimStyle.Render(fmt.Sprintf("(%d cores)", len(l3.Children)))))
+				for _, line := range coreLines(l3.Children) {
+					b.WriteString(fmt.Sprintf("  %s  %s     %s\n", treeStem(dieLast), treeStem(l3Last), line))
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+func coreLines(cores []topology.Domain) []string {
+	var lines []string
+	var tokens []string
+	for _, core := range cores {
+		threads := make([]string, len(core.Children))
+		for i, thread := range core.Children {
+			threads[i] = fmt.Sprintf("%d", thread.ID)
+		}
+		tokens = append(tokens, coreStyle.Render(threads[0])+vcpuStyle.Render(strings.Join(append([]string{""}, threads[1:]...), "+")))
+		if len(tokens) == coresPerTreeLine {
+			lines = append(lines, strings.Join(tokens, " "))
+			tokens = nil
+		}
+	}
+	if len(tokens) > 0 {
+		lines = append(lines, strings.Join(tokens, " "))
+	}
+	return lines
+}
+
+func treeBranch(last bool) string {
+	if last {
+		return "└─"
+	}
+	return "├─"
+}
+
+func treeStem(last bool) string {
+	if last {
+		return "  "
+	}
+	return "│ "
+}
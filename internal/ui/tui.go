@@ -10,6 +10,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/journal"
 	"epyc-pve/internal/pve"
 	"epyc-pve/internal/topology"
 )
@@ -29,8 +30,44 @@ const (
 	stepError
 )
 
+// confirmChoice is one answer offered at the confirmation step.
+type confirmChoice int
+
+const (
+	confirmApply confirmChoice = iota
+	confirmApplyLive
+	confirmApplyPinned
+	confirmApplyFixVCPUs
+	confirmApplyAnyway
+	confirmClear
+	confirmClearLive
+	confirmCancel
+)
+
+// Choices offered at the action step.
+const (
+	actionCopy = iota
+	actionApply
+	actionClear
+)
+
+var actionLabels = []string{"Copy and exit", "Apply to a VM", "Clear affinity of a VM"}
+
+// Options carries command-line settings into the interactive mode.
+type Options struct {
+	Pool              affinity.CPUPool
+	Occupancy         affinity.Occupancy
+	Reserved          affinity.Reservation
+	EmulatorCPUs      int
+	EmulatorPlacement affinity.EmulatorPlacement
+	// Journal is where applied changes are recorded.
+	Journal string
+}
+
 type Model struct {
 	topo          *topology.CPUTopology
+	usable        *topology.CPUTopology
+	opts          Options
 	step          step
 	usePhysical   bool
 	coresNeeded   int
@@ -42,12 +79,22 @@ type Model struct {
 	selectedVM    int
 	textInput     textinput.Model
 	affinityStr   string
+	strategy      affinity.Strategy
+	cpus          []int
+	emulatorCPUs  []int
+	numaConfig    []string
+	guestNUMA     []affinity.GuestNUMANode
+	vmConfig      *pve.VM
+	vcpuMismatch  error
+	clearing      bool
+	showTree      bool
 	err           error
+	warning       string
 	width         int
 	height        int
 }
 
-func NewModel(topo *topology.CPUTopology) Model {
+func NewModel(topo *topology.CPUTopology, opts Options) Model {
 	ti := textinput.New()
 	ti.Placeholder = "Enter number..."
 	ti.Focus()
@@ -57,8 +104,12 @@ func NewModel(topo *topology.CPUTopology) Model {
 	ti.PromptStyle = lipgloss.NewStyle().Foreground(secondaryColor)
 	ti.Cursor.Style = lipgloss.NewStyle().Foreground(primaryColor)
 
+	usable := (&affinity.Request{Pool: opts.Pool, Reserved: opts.Reserved, Topology: topo}).UsableTopology()
+
 	return Model{
 		topo:         topo,
+		usable:       usable,
+		opts:         opts,
 		step:         stepCoreType,
 		textInput:    ti,
 		selectedCCDs: make([]bool, len(topo.CoreGroups)),
@@ -85,6 +136,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else {
 			m.step = stepDone
 		}
+		m.warning = msg.warning
 		return m, nil
 
 	case tea.KeyMsg:
@@ -103,6 +155,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.selectedCCDs[m.selectedOpt] = !m.selectedCCDs[m.selectedOpt]
 			}
 
+		case "t":
+			if m.step != stepCoreCount {
+				m.showTree = !m.showTree
+				return m, nil
+			}
+
 		case "enter":
 			return m.handleEnter()
 
@@ -151,7 +209,7 @@ func (m Model) moveCursor(delta int) Model {
 			m.selectedOpt = 0
 		}
 	case stepAction:
-		m.selectedOpt = (m.selectedOpt + 1) % 2
+		m.selectedOpt = (m.selectedOpt + delta + len(actionLabels)) % len(actionLabels)
 	case stepSelectVM:
 		m.selectedVM += delta
 		if m.selectedVM < 0 {
@@ -161,7 +219,8 @@ func (m Model) moveCursor(delta int) Model {
 			m.selectedVM = 0
 		}
 	case stepConfirm:
-		m.selectedOpt = (m.selectedOpt + 1) % 2
+		choices := m.confirmChoices()
+		m.selectedOpt = (m.selectedOpt + delta + len(choices)) % len(choices)
 	}
 	return m
 }
@@ -176,21 +235,16 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stepCoreCount:
 		val, err := strconv.Atoi(m.textInput.Value())
-		maxCores := m.topo.TotalCores
+		maxCores := m.usable.TotalCores
 		if !m.usePhysical {
-			maxCores = m.topo.TotalCPUs
+			maxCores = m.usable.TotalCPUs
 		}
 		if err != nil || val < 1 || val > maxCores {
 			return m, nil
 		}
 		m.coresNeeded = val
 
-		req := &affinity.Request{
-			CoresNeeded: m.coresNeeded,
-			IncludeSMT:  !m.usePhysical,
-			Topology:    m.topo,
-		}
-		options, err := affinity.Generate(req)
+		options, err := affinity.Generate(m.request())
 		if err != nil {
 			m.err = err
 			m.step = stepError
@@ -199,11 +253,7 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 		m.options = options
 		m.selectedOpt = 0
 
-		physicalCoresNeeded := m.coresNeeded
-		if !m.usePhysical && m.topo.HasSMT {
-			physicalCoresNeeded = (m.coresNeeded + 1) / 2
-		}
-		m.minCCDsNeeded = affinity.MinCCDsNeeded(m.topo, physicalCoresNeeded)
+		m.minCCDsNeeded = m.usableRequest().MinCCDsNeeded()
 
 		m.step = stepStrategy
 		return m, nil
@@ -221,7 +271,12 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 		if len(selected.CPUs) == 0 {
 			return m, nil
 		}
-		m.affinityStr = selected.AffinityStr
+		m.affinityStr = selected.ProcessAffinity()
+		m.strategy = selected.Strategy
+		m.cpus = selected.CPUs
+		m.emulatorCPUs = selected.EmulatorCPUs
+		m.numaConfig = selected.NUMAConfig()
+		m.guestNUMA = selected.GuestNUMA
 		m.selectedOpt = 0
 		m.step = stepAction
 		return m, nil
@@ -234,31 +289,32 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 			}
 		}
 
-		if len(selectedIndices) < m.minCCDsNeeded {
+		if m.selectedCPUs() < m.coresNeeded {
 			return m, nil
 		}
 
-		req := &affinity.Request{
-			CoresNeeded: m.coresNeeded,
-			IncludeSMT:  !m.usePhysical,
-			Topology:    m.topo,
-		}
-		opt, err := affinity.GenerateManual(req, selectedIndices)
+		opt, err := affinity.GenerateManual(m.request(), selectedIndices)
 		if err != nil {
 			m.err = err
 			m.step = stepError
 			return m, nil
 		}
-		m.affinityStr = opt.AffinityStr
+		m.affinityStr = opt.ProcessAffinity()
+		m.strategy = opt.Strategy
+		m.cpus = opt.CPUs
+		m.emulatorCPUs = opt.EmulatorCPUs
+		m.numaConfig = nil
+		m.guestNUMA = nil
 		m.selectedOpt = 0
 		m.step = stepAction
 		return m, nil
 
 	case stepAction:
-		if m.selectedOpt == 0 {
+		if m.selectedOpt == actionCopy {
 			m.step = stepDone
 			return m, nil
 		}
+		m.clearing = m.selectedOpt == actionClear
 		vms, err := pve.ListVMs()
 		if err != nil {
 			m.err = err
@@ -274,16 +330,46 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 		if len(m.vms) == 0 {
 			return m, nil
 		}
+		vm, err := pve.LoadVM(m.vms[m.selectedVM].VMID)
+		if err != nil {
+			m.err = err
+			m.step = stepError
+			return m, nil
+		}
+		m.vmConfig = vm
+		m.vcpuMismatch = nil
+		if !m.clearing {
+			m.vcpuMismatch = pve.CheckVCPUs(vm, len(m.cpus))
+			// numaN memory= must add up to the VM's memory.
+			m.guestNUMA = affinity.SplitGuestMemory(m.guestNUMA, vm.NextStartMemory())
+		}
 		m.selectedOpt = 0
 		m.step = stepConfirm
 		return m, nil
 
 	case stepConfirm:
-		if m.selectedOpt == 1 {
+		switch m.confirmChoices()[m.selectedOpt] {
+		case confirmCancel:
 			return m, tea.Quit
+		case confirmApplyFixVCPUs:
+			m.step = stepApplying
+			return m, m.applyAffinity(true, liveNone)
+		case confirmApplyLive:
+			m.step = stepApplying
+			return m, m.applyAffinity(false, liveProcess)
+		case confirmApplyPinned:
+			m.step = stepApplying
+			return m, m.applyAffinity(false, liveVCPUs)
+		case confirmClear:
+			m.step = stepApplying
+			return m, m.clearAffinity(false)
+		case confirmClearLive:
+			m.step = stepApplying
+			return m, m.clearAffinity(true)
+		default:
+			m.step = stepApplying
+			return m, m.applyAffinity(false, liveNone)
 		}
-		m.step = stepApplying
-		return m, m.applyAffinity()
 
 	case stepDone, stepError:
 		return m, tea.Quit
@@ -291,18 +377,187 @@ func (m Model) handleEnter() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m Model) request() *affinity.Request {
+	return &affinity.Request{
+		CoresNeeded: m.coresNeeded,
+		IncludeSMT:  !m.usePhysical,
+		Pool:        m.opts.Pool,
+		Occupancy:   m.opts.Occupancy,
+		Reserved:    m.opts.Reserved,
+		Topology:    m.topo,
+
+		EmulatorCPUs:      m.opts.EmulatorCPUs,
+		EmulatorPlacement: m.opts.EmulatorPlacement,
+	}
+}
+
+// usableRequest returns the request restricted to the usable topology the
+// manual CCD step lists.
+func (m Model) usableRequest() *affinity.Request {
+	req := m.request()
+	req.Topology = m.usable
+	return req
+}
+
+// applyResultMsg reports an apply or clear; warning describes a problem
+// that did not stop it.
 type applyResultMsg struct {
-	err error
+	err     error
+	warning string
+}
+
+// confirmChoices lists the answers for the confirmation step. When the
+// pinned CPU count differs from the VM's vCPUs, applying as-is must be
+// chosen explicitly and fixing sockets/cores is offered first.
+func (m Model) confirmChoices() []confirmChoice {
+	// Live pinning needs the VM's process, so only on the local node.
+	running := m.vms[m.selectedVM].Status == "running" && pve.IsLocal()
+	if m.clearing {
+		if running {
+			return []confirmChoice{confirmClear, confirmClearLive, confirmCancel}
+		}
+		return []confirmChoice{confirmClear, confirmCancel}
+	}
+	if m.vcpuMismatch != nil {
+		return []confirmChoice{confirmApplyFixVCPUs, confirmApplyAnyway, confirmCancel}
+	}
+	if running {
+		return []confirmChoice{confirmApply, confirmApplyLive, confirmApplyPinned, confirmCancel}
+	}
+	return []confirmChoice{confirmApply, confirmCancel}
+}
+
+func (m Model) confirmLabel(choice confirmChoice) string {
+	switch choice {
+	case confirmApplyFixVCPUs:
+		sockets, cores := pve.MatchingVCPUs(m.vmConfig, len(m.cpus))
+		return fmt.Sprintf("Apply and set sockets=%d cores=%d", sockets, cores)
+	case confirmApplyLive:
+		return "Yes, apply and re-pin the running VM now"
+	case confirmApplyPinned:
+		return "Yes, apply and pin each running vCPU to its own CPU"
+	case confirmApplyAnyway:
+		return "Apply anyway"
+	case confirmClear:
+		return "Yes, clear affinity"
+	case confirmClearLive:
+		return "Yes, clear and unpin the running VM now"
+	case confirmCancel:
+		return "No, cancel"
+	default:
+		return "Yes, apply"
+	}
 }
 
-func (m Model) applyAffinity() tea.Cmd {
+// liveMode selects how applyAffinity treats the running VM.
+type liveMode int
+
+const (
+	liveNone liveMode = iota
+	liveProcess
+	liveVCPUs
+)
+
+func (m Model) applyAffinity(fixVCPUs bool, live liveMode) tea.Cmd {
 	return func() tea.Msg {
 		vmid := m.vms[m.selectedVM].VMID
-		err := pve.SetAffinity(vmid, m.affinityStr, false)
-		return applyResultMsg{err: err}
+		var config map[string]string
+		if fixVCPUs {
+			sockets, cores := pve.MatchingVCPUs(m.vmConfig, len(m.cpus))
+			config = pve.VCPUSettings(m.vmConfig, sockets, cores)
+		}
+		settings := m.settings(config)
+		if err := pve.ChangeConfig(vmid, settings); err != nil {
+			return applyResultMsg{err: err}
+		}
+		delete(settings, "affinity")
+		entry := journal.NewEntry(vmid, m.vmConfig.Raw, m.affinityStr, settings, string(m.strategy))
+		warning := m.record(entry)
+		hookPlan := &pve.HookPlan{
+			VMID:       vmid,
+			VCPUs:      affinity.FormatCPUs(m.cpus),
+			Emulator:   affinity.FormatCPUs(m.emulatorCPUs),
+			PCIDevices: pve.PCIDevices(m.vmConfig),
+		}
+		if live == liveVCPUs {
+			hookPlan.VCPUMap = m.vcpuMap()
+		}
+		if _, err := pve.RefreshHookscript(m.vmConfig, hookPlan, false); err != nil {
+			return applyResultMsg{err: err, warning: warning}
+		}
+
+		if live == liveNone {
+			return applyResultMsg{warning: warning}
+		}
+		return applyResultMsg{err: m.pinLive(vmid, live == liveVCPUs), warning: warning}
 	}
 }
 
+// clearAffinity removes the VM's affinity and, with live, lets the running
+// VM's threads use every online CPU again.
+func (m Model) clearAffinity(live bool) tea.Cmd {
+	return func() tea.Msg {
+		if err := pve.ClearAffinity(m.vmConfig, false); err != nil {
+			return applyResultMsg{err: err}
+		}
+		warning := m.record(journal.NewEntry(m.vmConfig.VMID, m.vmConfig.Raw, "", nil, journal.StrategyReset))
+		if !live {
+			return applyResultMsg{warning: warning}
+		}
+		_, err := pve.ApplyLive(m.vmConfig.VMID, m.topo.OnlineCPUs(), false)
+		return applyResultMsg{err: err, warning: warning}
+	}
+}
+
+// settings returns what applying the selection sets on the VM: the
+// affinity, the guest NUMA nodes if any, and config.
+func (m Model) settings(config map[string]string) map[string]string {
+	settings := map[string]string{"affinity": m.affinityStr}
+	if len(m.guestNUMA) > 0 {
+		for key, value := range pve.NUMASettings(m.guestNUMAValues()) {
+			settings[key] = value
+		}
+	}
+	for key, value := range config {
+		settings[key] = value
+	}
+	return settings
+}
+
+// record journals an applied change. A failure does not undo the change,
+// so it comes back as a warning.
+func (m Model) record(entry journal.Entry) string {
+	if err := journal.Record(m.opts.Journal, entry, m.topo); err != nil {
+		return fmt.Sprintf("recording change in journal: %v; rollback will not know about it", err)
+	}
+	return ""
+}
+
+// vcpuMap gives each vCPU of the selection its own host CPU.
+func (m Model) vcpuMap() []int {
+	option := affinity.Option{CPUs: m.cpus, GuestNUMA: m.guestNUMA}
+	return option.VCPUMap(m.topo)
+}
+
+// pinLive re-pins the running VM's threads: vCPUs to the vCPU set (or one
+// CPU each with pinVCPUs) and the rest to the emulator CPUs, if reserved.
+func (m Model) pinLive(vmid int, pinVCPUs bool) error {
+	pid, err := pve.ReadPID(vmid)
+	if err != nil {
+		return err
+	}
+	plan := pve.PinPlan{VCPUs: m.cpus, Emulator: m.emulatorCPUs}
+	if pinVCPUs {
+		plan.VCPUMap = m.vcpuMap()
+	}
+	pins, err := pve.PlanThreadPins(pid, plan)
+	if err != nil {
+		return err
+	}
+	_, err = pve.ApplyPins(pins)
+	return err
+}
+
 func (m Model) View() string {
 	var b strings.Builder
 
@@ -352,6 +607,9 @@ func (m Model) renderHelp() string {
 		parts = append(parts, keyStyle.Render("enter")+sepStyle.Render(" select"))
 	}
 
+	if m.step != stepCoreCount {
+		parts = append(parts, keyStyle.Render("t")+sepStyle.Render(" tree"))
+	}
 	parts = append(parts, keyStyle.Render("esc")+sepStyle.Render(" back"))
 	parts = append(parts, keyStyle.Render("q")+sepStyle.Render(" quit"))
 
@@ -371,13 +629,20 @@ func (m Model) renderTopology() string {
 		archLabel = "AMD"
 	}
 
-	b.WriteString(fmt.Sprintf("  %s %s    %s %d    %s %d    %s %s\n",
+	b.WriteString(fmt.Sprintf("  %s %s    %s %d    %s %d    %s %s    %s %d\n",
 		dimStyle.Render("Arch:"), highlightStyle.Render(archLabel),
 		packageStyle.Render("Cores:"), m.topo.TotalCores,
 		vcpuStyle.Render("vCPUs:"), m.topo.TotalCPUs,
-		dimStyle.Render("SMT:"), formatBool(m.topo.HasSMT)))
+		dimStyle.Render("SMT:"), formatBool(m.topo.HasSMT),
+		dimStyle.Render("NUMA:"), len(m.topo.NUMANodes)))
+	b.WriteString(formatCPUStates(m.topo))
 	b.WriteString("\n")
 
+	if m.showTree {
+		b.WriteString(renderHierarchy(m.topo.Hierarchy))
+		return b.String()
+	}
+
 	for _, pkg := range m.topo.Packages {
 		pkgCores := 0
 		pkgThreads := 0
@@ -395,10 +660,7 @@ func (m Model) renderTopology() string {
 			if i == len(pkg.CoreGroups)-1 {
 				prefix = "└─"
 			}
-			l3Info := ""
-			if cg.L3CacheID >= 0 {
-				l3Info = dimStyle.Render(fmt.Sprintf(" [L3#%d]", cg.L3CacheID))
-			}
+			l3Info := formatGroupTags(cg)
 			label := cg.Name
 			if label == "" {
 				label = fmt.Sprintf("CCD %d", cg.ID)
@@ -411,6 +673,15 @@ func (m Model) renderTopology() string {
 		}
 	}
 
+	if len(m.topo.NUMANodes) > 1 {
+		for _, node := range m.topo.NUMANodes {
+			b.WriteString(fmt.Sprintf("  %s %d  %s  %s\n",
+				packageStyle.Render("NUMA"), node.ID,
+				vcpuStyle.Render(affinity.FormatCPUs(node.CPUs)),
+				dimStyle.Render(formatNUMAMemory(node))))
+		}
+	}
+
 	return b.String()
 }
 
@@ -419,9 +690,9 @@ func (m Model) renderCoreTypeSelection() string {
 	b.WriteString(subtitleStyle.Render("? What type of CPU allocation?"))
 	b.WriteString("\n\n")
 
-	physicalLabel := fmt.Sprintf("Physical Cores (%d available)", m.topo.TotalCores)
+	physicalLabel := fmt.Sprintf("Physical Cores (%d available)", m.usable.TotalCores)
 	physicalDesc := "One vCPU per physical core"
-	vcpuLabel := fmt.Sprintf("vCPUs/Threads (%d available)", m.topo.TotalCPUs)
+	vcpuLabel := fmt.Sprintf("vCPUs/Threads (%d available)", m.usable.TotalCPUs)
 	vcpuDesc := "Include SMT siblings"
 
 	if m.usePhysical {
@@ -453,10 +724,10 @@ func (m Model) renderCoreCountInput() string {
 	var b strings.Builder
 
 	coreType := "vCPUs"
-	maxCores := m.topo.TotalCPUs
+	maxCores := m.usable.TotalCPUs
 	if m.usePhysical {
 		coreType = "physical cores"
-		maxCores = m.topo.TotalCores
+		maxCores = m.usable.TotalCores
 	}
 
 	b.WriteString(subtitleStyle.Render(fmt.Sprintf("? How many %s?", coreType)))
@@ -505,6 +776,18 @@ func (m Model) renderStrategySelection() string {
 				vcpuStyle.Render(opt.AffinityStr),
 				opt.CCDsUsed))
 			b.WriteString("\n")
+			if len(opt.EmulatorCPUs) > 0 {
+				b.WriteString("      Emulator/IO: " + vcpuStyle.Render(affinity.FormatCPUs(opt.EmulatorCPUs)))
+				b.WriteString("\n")
+			}
+			if overlap := formatOverlap(&opt); overlap != "" {
+				b.WriteString("      " + highlightStyle.Render(overlap))
+				b.WriteString("\n")
+			}
+			for _, line := range opt.NUMAConfig() {
+				b.WriteString("      " + dimStyle.Render(line))
+				b.WriteString("\n")
+			}
 		}
 		b.WriteString("\n")
 	}
@@ -515,12 +798,7 @@ func (m Model) renderStrategySelection() string {
 func (m Model) renderManualCCDSelection() string {
 	var b strings.Builder
 
-	selectedCount := 0
-	for _, selected := range m.selectedCCDs {
-		if selected {
-			selectedCount++
-		}
-	}
+	selectedCPUs := m.selectedCPUs()
 
 	groupName := "CCDs"
 	if m.topo.Architecture == topology.ArchIntelHybrid {
@@ -529,10 +807,10 @@ func (m Model) renderManualCCDSelection() string {
 
 	b.WriteString(subtitleStyle.Render(fmt.Sprintf("? Select %d %s", m.minCCDsNeeded, groupName)))
 	b.WriteString("\n")
-	b.WriteString(dimStyle.Render(fmt.Sprintf("  Selected: %d / %d required", selectedCount, m.minCCDsNeeded)))
+	b.WriteString(dimStyle.Render(fmt.Sprintf("  Selected: %d / %d CPUs required", selectedCPUs, m.coresNeeded)))
 	b.WriteString("\n\n")
 
-	for i, cg := range m.topo.CoreGroups {
+	for i, cg := range m.usable.CoreGroups {
 		checkbox := "[ ]"
 		if m.selectedCCDs[i] {
 			checkbox = coreStyle.Render("[✓]")
@@ -556,14 +834,27 @@ func (m Model) renderManualCCDSelection() string {
 		b.WriteString("\n")
 	}
 
-	if selectedCount < m.minCCDsNeeded {
+	if selectedCPUs < m.coresNeeded {
 		b.WriteString("\n")
-		b.WriteString(highlightStyle.Render(fmt.Sprintf("  Need %d more", m.minCCDsNeeded-selectedCount)))
+		b.WriteString(highlightStyle.Render(fmt.Sprintf("  Need %d more CPUs", m.coresNeeded-selectedCPUs)))
 	}
 
 	return b.String()
 }
 
+// selectedCPUs counts the usable CPUs in the selected groups; groups differ
+// in size and threads per core, so the selection is judged by CPUs, not
+// groups.
+func (m Model) selectedCPUs() int {
+	var groups []topology.CoreGroup
+	for i, selected := range m.selectedCCDs {
+		if selected && i < len(m.usable.CoreGroups) {
+			groups = append(groups, m.usable.CoreGroups[i])
+		}
+	}
+	return m.usableRequest().GroupCPUs(groups...)
+}
+
 func (m Model) renderActionSelection() string {
 	var b strings.Builder
 
@@ -571,21 +862,23 @@ func (m Model) renderActionSelection() string {
 	b.WriteString("\n\n")
 	b.WriteString("  ")
 	b.WriteString(vcpuStyle.Render(m.affinityStr))
-	b.WriteString("\n\n")
+	b.WriteString("\n")
+	b.WriteString(m.renderNUMAConfig())
+	b.WriteString("\n")
 
 	b.WriteString(subtitleStyle.Render("? What next?"))
 	b.WriteString("\n\n")
 
-	if m.selectedOpt == 0 {
-		b.WriteString(cursorStyle.Render("  ▸ "))
-		b.WriteString(selectedStyle.Render("Copy and exit"))
-		b.WriteString("\n")
-		b.WriteString("    Apply to a VM")
-	} else {
-		b.WriteString("    Copy and exit")
-		b.WriteString("\n")
-		b.WriteString(cursorStyle.Render("  ▸ "))
-		b.WriteString(selectedStyle.Render("Apply to a VM"))
+	for i, label := range actionLabels {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if i == m.selectedOpt {
+			b.WriteString(cursorStyle.Render("  ▸ "))
+			b.WriteString(selectedStyle.Render(label))
+		} else {
+			b.WriteString("    " + label)
+		}
 	}
 
 	return b.String()
@@ -634,33 +927,58 @@ func (m Model) renderConfirmation() string {
 	b.WriteString(subtitleStyle.Render("? Confirm"))
 	b.WriteString("\n\n")
 	b.WriteString(fmt.Sprintf("  VM:       %s (%d)\n", highlightStyle.Render(vm.Name), vm.VMID))
-	b.WriteString(fmt.Sprintf("  Affinity: %s\n", vcpuStyle.Render(m.affinityStr)))
-	b.WriteString(fmt.Sprintf("  Command:  %s\n", dimStyle.Render(fmt.Sprintf("qm set %d --affinity %s", vm.VMID, m.affinityStr))))
-	b.WriteString("\n")
-
-	if m.selectedOpt == 0 {
-		b.WriteString(cursorStyle.Render("  ▸ "))
-		b.WriteString(selectedStyle.Render("Yes, apply"))
-		b.WriteString("\n")
-		b.WriteString("    No, cancel")
+	if m.clearing {
+		current := m.vmConfig.Affinity
+		if current == "" {
+			current = "none"
+		}
+		b.WriteString(fmt.Sprintf("  Affinity: %s\n", vcpuStyle.Render(current)))
+		b.WriteString(fmt.Sprintf("  Command:  %s\n", dimStyle.Render(pve.QMSetCommand(vm.VMID, pve.ClearAffinityArgs(m.vmConfig)...))))
 	} else {
-		b.WriteString("    Yes, apply")
+		b.WriteString(fmt.Sprintf("  Affinity: %s\n", vcpuStyle.Render(m.affinityStr)))
+		b.WriteString(fmt.Sprintf("  Command:  %s\n", dimStyle.Render(pve.QMSetCommand(vm.VMID, pve.ConfigArgs(m.settings(nil))...))))
+		for _, command := range pve.RefreshHookscriptCommands(m.vmConfig) {
+			b.WriteString(fmt.Sprintf("            %s\n", dimStyle.Render(command)))
+		}
+	}
+	if m.vcpuMismatch != nil {
 		b.WriteString("\n")
-		b.WriteString(cursorStyle.Render("  ▸ "))
-		b.WriteString(selectedStyle.Render("No, cancel"))
+		b.WriteString(highlightStyle.Render("  ⚠ " + m.vcpuMismatch.Error()))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	for i, choice := range m.confirmChoices() {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if i == m.selectedOpt {
+			b.WriteString(cursorStyle.Render("  ▸ "))
+			b.WriteString(selectedStyle.Render(m.confirmLabel(choice)))
+		} else {
+			b.WriteString("    " + m.confirmLabel(choice))
+		}
 	}
 
 	return b.String()
 }
 
 func (m Model) renderApplying() string {
+	if m.clearing {
+		return "  Clearing affinity..."
+	}
 	return "  Applying affinity configuration..."
 }
 
 func (m Model) renderSuccess() string {
 	var b strings.Builder
 
-	if len(m.vms) > 0 && m.selectedVM < len(m.vms) {
+	if m.clearing && len(m.vms) > 0 && m.selectedVM < len(m.vms) {
+		vm := m.vms[m.selectedVM]
+		b.WriteString(coreStyle.Render("✓ Cleared affinity"))
+		b.WriteString(fmt.Sprintf(" of VM %d (%s)\n\n", vm.VMID, vm.Name))
+		b.WriteString(dimStyle.Render("  QEMU may use every host CPU from the next start"))
+	} else if len(m.vms) > 0 && m.selectedVM < len(m.vms) {
 		vm := m.vms[m.selectedVM]
 		b.WriteString(coreStyle.Render("✓ Applied"))
 		b.WriteString(fmt.Sprintf(" to VM %d (%s)\n\n", vm.VMID, vm.Name))
@@ -671,13 +989,37 @@ func (m Model) renderSuccess() string {
 		b.WriteString("\n\n")
 		b.WriteString("  ")
 		b.WriteString(vcpuStyle.Render(m.affinityStr))
-		b.WriteString("\n\n")
+		b.WriteString("\n")
+		b.WriteString(m.renderNUMAConfig())
+		b.WriteString("\n")
 		b.WriteString(dimStyle.Render(fmt.Sprintf("  Use: qm set <vmid> --affinity %s", m.affinityStr)))
 	}
+	if m.warning != "" {
+		b.WriteString("\n\n")
+		b.WriteString(highlightStyle.Render("  ⚠ " + m.warning))
+	}
 
 	return b.String()
 }
 
+func (m Model) guestNUMAValues() []string {
+	values := make([]string, len(m.guestNUMA))
+	for i, node := range m.guestNUMA {
+		values[i] = node.Value()
+	}
+	return values
+}
+
+func (m Model) renderNUMAConfig() string {
+	var b strings.Builder
+	for _, line := range m.numaConfig {
+		b.WriteString("  ")
+		b.WriteString(dimStyle.Render(line))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 func (m Model) renderError() string {
 	return lipgloss.NewStyle().Foreground(errorColor).Render(fmt.Sprintf("✗ Error: %v", m.err))
 }
@@ -689,8 +1031,8 @@ func formatBool(b bool) string {
 	return lipgloss.NewStyle().Foreground(errorColor).Render("No")
 }
 
-func Run(topo *topology.CPUTopology) error {
-	model := NewModel(topo)
+func Run(topo *topology.CPUTopology, opts Options) error {
+	model := NewModel(topo, opts)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 	finalModel, err := p.Run()
 	if err != nil {
@@ -5,19 +5,62 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"epyc-pve/cmd"
 	"epyc-pve/internal/affinity"
+	"epyc-pve/internal/cluster"
+	"epyc-pve/internal/host"
+	"epyc-pve/internal/journal"
 	"epyc-pve/internal/pve"
 	"epyc-pve/internal/topology"
 	"epyc-pve/internal/ui"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			if err := runHistory(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		case "cluster":
+			if err := runCluster(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		case "check-migration":
+			if err := runCheckMigration(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		case "rollback":
+			if err := runRollback(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		case "translate":
+			if err := runTranslate(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		case "kernel-cmdline":
+			if err := runKernelCmdline(os.Args[2:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		}
+	}
+
 	opts := cmd.ParseFlags()
+	if opts.FromSnapshot == "" && opts.Sysroot == "" {
+		opts.FromSnapshot = publishedSnapshot(opts)
+	}
 
-	topo, err := topology.Detect()
+	topo, err := detectTopology(opts)
 	if err != nil {
 		exitWithError(err)
 	}
@@ -26,6 +69,24 @@ func main() {
 		exitWithError(err)
 	}
 
+	if err := useAPI(opts.APIOptions); err != nil {
+		exitWithError(err)
+	}
+
+	if opts.Capture != "" {
+		if err := runCapture(opts); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	if opts.EmitSlices != "" {
+		if err := runEmitSlices(opts, topo); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
 	if opts.ShowTopology {
 		if opts.JSON {
 			encoder := json.NewEncoder(os.Stdout)
@@ -39,6 +100,13 @@ func main() {
 		return
 	}
 
+	if opts.Reset {
+		if err := runReset(opts, topo); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
 	if opts.Apply {
 		if err := runCLIMode(opts, topo); err != nil {
 			exitWithError(err)
@@ -46,16 +114,166 @@ func main() {
 		return
 	}
 
-	if err := ui.Run(topo); err != nil {
+	occupancy := loadOccupancy(opts, 0)
+	uiOpts := ui.Options{
+		Pool:              affinity.CPUPool(opts.Pool),
+		Occupancy:         occupancy,
+		Reserved:          opts.Reservation,
+		EmulatorCPUs:      opts.EmulatorCPUs,
+		EmulatorPlacement: affinity.EmulatorPlacement(opts.EmulatorAt),
+		Journal:           opts.Journal,
+	}
+	if err := ui.Run(topo, uiOpts); err != nil {
 		exitWithError(err)
 	}
 }
 
+// useAPI switches the pve package to the REST API when --api-url is set.
+func useAPI(opts cmd.APIOptions) error {
+	if opts.APIURL == "" {
+		return nil
+	}
+	backend, err := pve.NewAPIBackend(opts.APIURL, opts.APIToken, opts.APINode, opts.APIInsecure)
+	if err != nil {
+		return err
+	}
+	pve.UseBackend(backend)
+	return nil
+}
+
+func detectTopology(opts *cmd.Options) (*topology.CPUTopology, error) {
+	if opts.FromSnapshot != "" {
+		snap, err := topology.LoadSnapshot(opts.FromSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		return snap.Detect()
+	}
+	if opts.Sysroot != "" {
+		return topology.DetectRoot(opts.Sysroot)
+	}
+	return topology.Detect()
+}
+
+// publishedSnapshot returns the topology snapshot "cluster publish" stored
+// for the node of the VM --apply targets through the API, or "" when there
+// is none or the tool works on the local node.
+func publishedSnapshot(opts *cmd.Options) string {
+	if opts.APIURL == "" || !opts.Apply || opts.VMID <= 0 {
+		return ""
+	}
+	node := opts.APINode
+	if node == "" {
+		token := opts.APIToken
+		if token == "" {
+			token = os.Getenv(pve.APITokenEnv)
+		}
+		backend, err := pve.NewAPIBackend(opts.APIURL, token, "", opts.APIInsecure)
+		if err != nil {
+			return ""
+		}
+		vm, err := backend.LoadVM(opts.VMID)
+		if err != nil {
+			return ""
+		}
+		node = vm.Node
+	}
+	path := cluster.SnapshotPath(cluster.Dir, node)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// loadOccupancy reads which host CPUs other VMs on the same node are pinned
+// to. It is empty when planning for another host (--sysroot,
+// --from-snapshot) without the API, or when the VM configs cannot be read.
+// Occupancy only steers the choice, so a VM whose affinity cannot be parsed
+// is skipped with a warning.
+func loadOccupancy(opts *cmd.Options, exclude int) affinity.Occupancy {
+	if (opts.Sysroot != "" || opts.FromSnapshot != "") && opts.APIURL == "" {
+		return nil
+	}
+	affinities, err := pve.Affinities(exclude)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) {
+			ui.PrintWarning(fmt.Sprintf("ignoring other VMs' pinning: %v", err))
+		}
+		return nil
+	}
+
+	vmids := make([]int, 0, len(affinities))
+	for vmid := range affinities {
+		vmids = append(vmids, vmid)
+	}
+	sort.Ints(vmids)
+	for _, vmid := range vmids {
+		if _, err := topology.ParseList(affinities[vmid]); err != nil {
+			ui.PrintWarning(fmt.Sprintf("VM %d: ignoring invalid affinity %q", vmid, affinities[vmid]))
+			delete(affinities, vmid)
+		}
+	}
+
+	occupancy, err := affinity.NewOccupancy(affinities, exclude)
+	if err != nil {
+		ui.PrintWarning(fmt.Sprintf("ignoring other VMs' pinning: %v", err))
+		return nil
+	}
+	return occupancy
+}
+
+func runCapture(opts *cmd.Options) error {
+	root := opts.Sysroot
+	if root == "" {
+		root = "/"
+	}
+	snap, err := topology.Capture(os.DirFS(root))
+	if err != nil {
+		return err
+	}
+	if err := snap.Save(opts.Capture); err != nil {
+		return err
+	}
+	ui.PrintCaptured(opts.Capture, snap)
+	return nil
+}
+
+// runEmitSlices writes the systemd drop-ins that keep host services on the
+// reserved CPUs, or shows them with --dry-run.
+func runEmitSlices(opts *cmd.Options, topo *topology.CPUTopology) error {
+	reserved := opts.Reservation.Resolve(topo)
+	if len(reserved) == 0 {
+		return fmt.Errorf("%w: %s covers no online CPU", host.ErrNoReservation, opts.Reservation)
+	}
+	if opts.DryRun {
+		paths := make([]string, len(host.Slices))
+		for i, slice := range host.Slices {
+			paths[i] = host.SliceDropInPath(opts.EmitSlices, slice)
+		}
+		ui.PrintSliceDropIns(paths, host.SliceDropIn(reserved), true)
+		return nil
+	}
+	paths, err := host.WriteSliceDropIns(opts.EmitSlices, reserved)
+	if err != nil {
+		return err
+	}
+	ui.PrintSliceDropIns(paths, host.SliceDropIn(reserved), false)
+	return nil
+}
+
 func runCLIMode(opts *cmd.Options, topo *topology.CPUTopology) error {
+	occupancy := loadOccupancy(opts, opts.VMID)
 	req := &affinity.Request{
 		CoresNeeded: opts.Cores,
 		IncludeSMT:  !opts.Physical,
+		MemoryMB:    opts.MemoryMB,
+		Pool:        affinity.CPUPool(opts.Pool),
+		Occupancy:   occupancy,
+		Reserved:    opts.Reservation,
 		Topology:    topo,
+
+		EmulatorCPUs:      opts.EmulatorCPUs,
+		EmulatorPlacement: affinity.EmulatorPlacement(opts.EmulatorAt),
 	}
 	options, err := affinity.Generate(req)
 	if err != nil {
@@ -83,18 +301,560 @@ func runCLIMode(opts *cmd.Options, topo *topology.CPUTopology) error {
 		return fmt.Errorf("%w: VM %d not found. Available VMs: %s", pve.ErrVMNotFound, opts.VMID, formatVMIDs(vms))
 	}
 
+	vm, err := pve.LoadVM(opts.VMID)
+	if err != nil {
+		return err
+	}
+	for _, problem := range vm.Problems {
+		ui.PrintWarning(fmt.Sprintf("VM %d: %s", vm.VMID, problem))
+	}
+	fixVCPUs := false
+	sockets, cores := pve.MatchingVCPUs(vm, len(selected.CPUs))
+	if err := pve.CheckVCPUs(vm, len(selected.CPUs)); err != nil {
+		switch {
+		case opts.FixVCPUs:
+			fixVCPUs = true
+		case opts.Force:
+			ui.PrintWarning(err.Error())
+		default:
+			return fmt.Errorf("%w; use --fix-vcpus to set sockets/cores to match, or --force to apply anyway", err)
+		}
+	}
+
+	live := opts.Live || opts.LiveOnly
+	pid := 0
+	if live {
+		pid, err = pve.ReadPID(opts.VMID)
+		switch {
+		case errors.Is(err, pve.ErrVMNotRunning) && opts.Live:
+			ui.PrintWarning(fmt.Sprintf("VM %d is not running; the affinity takes effect at its next start", opts.VMID))
+			live = false
+		case err != nil:
+			return err
+		}
+	}
+
+	plan := pve.PinPlan{VCPUs: selected.CPUs, Emulator: selected.EmulatorCPUs}
+	if opts.PinVCPUs {
+		plan.VCPUMap = selected.VCPUMap(topo)
+	}
+	var pins []pve.ThreadPin
+	if live {
+		pins, err = pve.PlanThreadPins(pid, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A hookscript this tool installed earlier re-pins the threads at every
+	// start, so its plan has to follow the new affinity.
+	refreshHook := !opts.LiveOnly && opts.Hookscript == "" && pve.HookStorage(vm) != ""
+	var hookPlan *pve.HookPlan
+	if opts.Hookscript != "" || opts.EmitHook != "" || refreshHook {
+		if opts.Hookscript != "" {
+			if err := pve.CheckHookscript(vm); err != nil {
+				return err
+			}
+			if err := pve.CheckSnippetStorage(opts.Hookscript); err != nil {
+				return err
+			}
+		}
+		hookPlan = &pve.HookPlan{
+			VMID:       opts.VMID,
+			VCPUs:      affinity.FormatCPUs(plan.VCPUs),
+			VCPUMap:    plan.VCPUMap,
+			Emulator:   affinity.FormatCPUs(plan.Emulator),
+			PCIDevices: pve.PCIDevices(vm),
+		}
+	}
+
+	if len(selected.GuestNUMA) > 0 && opts.MemoryMB == 0 && !opts.DryRun && !opts.LiveOnly {
+		return fmt.Errorf("%w: --memory is required to bind guest NUMA memory with %s", cmd.ErrInvalidArguments, selected.Strategy)
+	}
+
+	// Settings changed along with the affinity; the journal keeps their old
+	// values so a rollback restores them too.
+	var config map[string]string
+	if fixVCPUs {
+		config = mergeSettings(config, pve.VCPUSettings(vm, sockets, cores))
+	}
+	if len(selected.GuestNUMA) > 0 {
+		config = mergeSettings(config, pve.NUMASettings(guestNUMAValues(selected.GuestNUMA)))
+	}
+	settings := mergeSettings(config, map[string]string{"affinity": selected.ProcessAffinity()})
+
 	if opts.DryRun {
-		ui.PrintDryRun(opts.VMID, selected.AffinityStr)
+		var commands []string
+		if !opts.LiveOnly {
+			commands = append(commands, pve.QMSetCommand(opts.VMID, pve.ConfigArgs(settings)...))
+		}
+		switch {
+		case live && !opts.PinVCPUs && len(selected.EmulatorCPUs) == 0:
+			commands = append(commands, pve.LiveCommand(pid, selected.AffinityStr))
+		case live:
+			for _, pin := range pins {
+				commands = append(commands, pve.PinCommand(pin, affinity.FormatCPUs(pin.CPUs)))
+			}
+		}
+		if opts.EmitHook != "" {
+			commands = append(commands, fmt.Sprintf("write %s and %s to %s", pve.HookScriptName, pve.PlanFileName(opts.VMID), opts.EmitHook))
+		}
+		if opts.Hookscript != "" {
+			commands = append(commands, pve.HookscriptCommands(opts.VMID, opts.Hookscript)...)
+		}
+		if refreshHook {
+			commands = append(commands, pve.RefreshHookscriptCommands(vm)...)
+		}
+		ui.PrintDryRun(opts.VMID, &selected, commands)
 		return nil
 	}
 
-	if err := pve.SetAffinity(opts.VMID, selected.AffinityStr, false); err != nil {
+	if !opts.LiveOnly {
+		if err := pve.ChangeConfig(opts.VMID, settings); err != nil {
+			return err
+		}
+		entry := journal.NewEntry(opts.VMID, vm.Raw, selected.ProcessAffinity(), config, string(selected.Strategy))
+		recordChange(opts.Journal, entry, topo)
+	}
+	threads := 0
+	if live {
+		threads, err = pve.ApplyPins(pins)
+		if err != nil {
+			return err
+		}
+	}
+	hookscript := ""
+	if opts.EmitHook != "" {
+		if err := pve.WriteHookFiles(opts.EmitHook, hookPlan); err != nil {
+			return err
+		}
+		hookscript = filepath.Join(opts.EmitHook, pve.HookScriptName)
+	}
+	if opts.Hookscript != "" {
+		if err := pve.InstallHookscript(vm, opts.Hookscript, hookPlan, false); err != nil {
+			return err
+		}
+		hookscript = pve.SnippetVolume(opts.Hookscript, pve.HookScriptName)
+	}
+	if refreshHook {
+		detached, err := pve.RefreshHookscript(vm, hookPlan, false)
+		if err != nil {
+			return err
+		}
+		if detached {
+			ui.PrintWarning(fmt.Sprintf("detached %s from VM %d: its plan cannot be rewritten through the API; run --hookscript on the node to pin threads again", vm.Hookscript, opts.VMID))
+		} else {
+			hookscript = vm.Hookscript
+		}
+	}
+	ui.PrintSuccess(opts.VMID, &selected, threads, hookscript)
+	return nil
+}
+
+// runReset removes the VM's affinity and, with --live or --live-only, lets
+// its running threads use every online CPU again.
+func runReset(opts *cmd.Options, topo *topology.CPUTopology) error {
+	vms, err := pve.ListVMs()
+	if err != nil {
+		return err
+	}
+	if !vmIDExists(opts.VMID, vms) {
+		return fmt.Errorf("%w: VM %d not found. Available VMs: %s", pve.ErrVMNotFound, opts.VMID, formatVMIDs(vms))
+	}
+	vm, err := pve.LoadVM(opts.VMID)
+	if err != nil {
 		return err
 	}
-	ui.PrintSuccess(opts.VMID, selected.AffinityStr)
+
+	live := opts.Live || opts.LiveOnly
+	pid := 0
+	if live {
+		pid, err = pve.ReadPID(opts.VMID)
+		switch {
+		case errors.Is(err, pve.ErrVMNotRunning) && opts.Live:
+			ui.PrintWarning(fmt.Sprintf("VM %d is not running; the cleared affinity takes effect at its next start", opts.VMID))
+			live = false
+		case err != nil:
+			return err
+		}
+	}
+	online := topo.OnlineCPUs()
+
+	if opts.DryRun {
+		var commands []string
+		if !opts.LiveOnly {
+			commands = append(commands, pve.QMSetCommand(opts.VMID, pve.ClearAffinityArgs(vm)...))
+			commands = append(commands, pve.RemoveHookPlanCommands(vm)...)
+		}
+		if live {
+			commands = append(commands, pve.LiveCommand(pid, affinity.FormatCPUs(online)))
+		}
+		ui.PrintResetDryRun(opts.VMID, commands)
+		return nil
+	}
+
+	if !opts.LiveOnly {
+		if err := pve.ClearAffinity(vm, false); err != nil {
+			return err
+		}
+		recordChange(opts.Journal, journal.NewEntry(opts.VMID, vm.Raw, "", nil, journal.StrategyReset), topo)
+	}
+	threads := 0
+	if live {
+		threads, err = pve.ApplyLive(opts.VMID, online, false)
+		if err != nil {
+			return err
+		}
+	}
+	ui.PrintReset(opts.VMID, !opts.LiveOnly, threads)
 	return nil
 }
 
+// runHistory lists the journal, optionally for one VM.
+func runHistory(args []string) error {
+	opts, err := cmd.ParseHistory(args)
+	if err != nil {
+		return err
+	}
+	entries, err := journal.Read(opts.Journal)
+	if err != nil {
+		return err
+	}
+	ui.PrintHistory(journal.ForVM(entries, opts.VMID))
+	return nil
+}
+
+// runRollback restores an affinity from the journal and records the
+// rollback itself, so it can be undone the same way.
+func runRollback(args []string) error {
+	opts, err := cmd.ParseRollback(args)
+	if err != nil {
+		return err
+	}
+	if err := useAPI(opts.APIOptions); err != nil {
+		return err
+	}
+	entries, err := journal.Read(opts.Journal)
+	if err != nil {
+		return err
+	}
+	target, err := journal.RollbackTarget(entries, opts.VMID, opts.To)
+	if err != nil {
+		return err
+	}
+
+	vm, err := pve.LoadVM(opts.VMID)
+	if err != nil {
+		return err
+	}
+	// Through the API the local topology is not the VM's host, so the
+	// rollback cannot be checked against the entry.
+	var topo *topology.CPUTopology
+	if pve.IsLocal() {
+		if topo, err = topology.Detect(); err != nil {
+			return err
+		}
+		if target.Entry.Topology != "" && target.Entry.Topology != topo.Fingerprint() {
+			ui.PrintWarning(fmt.Sprintf("the CPU topology changed since entry #%d; check the restored affinity still fits", target.Entry.Seq))
+		}
+	}
+
+	// Only settings that differ from the VM's config are restored.
+	config := map[string]string{}
+	var restored []string
+	for key, value := range target.Config {
+		if vm.Raw[key] != value {
+			config[key] = value
+			restored = append(restored, key)
+		}
+	}
+	sort.Strings(restored)
+	settings := mergeSettings(config)
+	if target.Affinity != vm.Affinity {
+		settings["affinity"] = target.Affinity
+	}
+	// The journal does not keep per-thread plans, and the current one pins
+	// threads to the CPUs being replaced.
+	if len(settings) > 0 && pve.HookStorage(vm) != "" {
+		settings["hookscript"] = ""
+	}
+
+	if opts.DryRun {
+		var commands []string
+		if len(settings) > 0 {
+			commands = append(commands, pve.QMSetCommand(opts.VMID, pve.ConfigArgs(settings)...))
+			commands = append(commands, pve.RemoveHookPlanCommands(vm)...)
+		}
+		ui.PrintRollback(opts.VMID, vm.Affinity, target.Affinity, restored, commands, true)
+		return nil
+	}
+	if len(settings) == 0 {
+		ui.PrintRollback(opts.VMID, vm.Affinity, target.Affinity, nil, nil, false)
+		return nil
+	}
+
+	if err := pve.ChangeConfig(opts.VMID, settings); err != nil {
+		return err
+	}
+	if err := pve.RemoveHookPlan(vm); err != nil {
+		return err
+	}
+	if _, detached := settings["hookscript"]; detached && target.Affinity != "" {
+		ui.PrintWarning(fmt.Sprintf("detached %s from VM %d; its plan pinned threads to the replaced CPUs", vm.Hookscript, opts.VMID))
+	}
+	recordChange(opts.Journal, journal.NewEntry(opts.VMID, vm.Raw, target.Affinity, config, journal.StrategyRollback), topo)
+	ui.PrintRollback(opts.VMID, vm.Affinity, target.Affinity, restored, nil, false)
+	return nil
+}
+
+// mergeSettings returns a new map holding the settings of all maps, the
+// later ones winning.
+func mergeSettings(all ...map[string]string) map[string]string {
+	result := make(map[string]string)
+	for _, settings := range all {
+		for key, value := range settings {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// recordChange journals a change that has already been applied. Failing to
+// record it only costs the rollback, so it is reported and not returned.
+func recordChange(path string, entry journal.Entry, topo *topology.CPUTopology) {
+	if err := journal.Record(path, entry, topo); err != nil {
+		ui.PrintWarning(fmt.Sprintf("recording change in journal: %v; rollback will not know about VM %d's change", err, entry.VMID))
+	}
+}
+
+// runCluster publishes the local topology or reports on, validates and plans
+// for the whole cluster.
+func runCluster(args []string) error {
+	opts, err := cmd.ParseCluster(args)
+	if err != nil {
+		return err
+	}
+
+	if opts.Action == cmd.ClusterPublish {
+		snap, err := topology.Capture(os.DirFS("/"))
+		if err != nil {
+			return err
+		}
+		path, err := cluster.Publish(opts.Dir, snap)
+		if err != nil {
+			return err
+		}
+		ui.PrintCaptured(path, snap)
+		return nil
+	}
+
+	// Off a Proxmox node there is no local topology; every node then comes
+	// from its snapshot.
+	local, err := topology.Detect()
+	if err != nil {
+		local = nil
+	}
+	c, err := cluster.Load(opts.Dir, local)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Action {
+	case cmd.ClusterStatus:
+		ui.PrintClusterStatus(c)
+		return nil
+
+	case cmd.ClusterCheck:
+		var findings []cluster.Finding
+		errorsFound := 0
+		for _, finding := range c.Check() {
+			if opts.Node != "" && finding.Node != opts.Node {
+				continue
+			}
+			findings = append(findings, finding)
+			if affinity.HasErrors(finding.Problems) {
+				errorsFound++
+			}
+		}
+		ui.PrintClusterCheck(findings)
+		if errorsFound > 0 {
+			return fmt.Errorf("%w: %d findings with errors", cluster.ErrInvalidPinning, errorsFound)
+		}
+		return nil
+	}
+
+	node, vm, err := c.FindVM(opts.VMID)
+	if err != nil {
+		return err
+	}
+	if node.Topology == nil {
+		return fmt.Errorf("node %s: %w", node.Name, node.TopologyErr)
+	}
+	occupancy, err := node.Occupancy(opts.VMID)
+	if err != nil {
+		return err
+	}
+	options, err := affinity.Generate(&affinity.Request{
+		CoresNeeded: opts.Cores,
+		IncludeSMT:  !opts.Physical,
+		Pool:        affinity.CPUPool(opts.Pool),
+		Occupancy:   occupancy,
+		Reserved:    opts.Reservation,
+		Topology:    node.Topology,
+	})
+	if err != nil {
+		return err
+	}
+	ui.PrintClusterPlan(node, vm)
+	ui.PrintOptions(options, opts.Physical)
+	return nil
+}
+
+// runCheckMigration reports whether a VM's affinity fits a target node and
+// proposes one that does.
+func runCheckMigration(args []string) error {
+	opts, err := cmd.ParseCheckMigration(args)
+	if err != nil {
+		return err
+	}
+	local, err := topology.Detect()
+	if err != nil {
+		local = nil
+	}
+	c, err := cluster.Load(opts.Dir, local)
+	if err != nil {
+		return err
+	}
+	report, err := c.CheckMigration(opts.VMID, opts.Target, affinity.CPUPool(opts.Pool), opts.Reservation)
+	if err != nil {
+		return err
+	}
+	ui.PrintMigrationCheck(report)
+	if !report.Compatible() {
+		return fmt.Errorf("%w: VM %d on %s", cluster.ErrIncompatible, report.VMID, report.Target)
+	}
+	return nil
+}
+
+// runTranslate carries an affinity over to another topology with the same
+// shape.
+func runTranslate(args []string) error {
+	opts, err := cmd.ParseTranslate(args)
+	if err != nil {
+		return err
+	}
+	src, err := loadTopology(opts.From)
+	if err != nil {
+		return err
+	}
+	dst, err := loadTopology(opts.To)
+	if err != nil {
+		return err
+	}
+	t, err := affinity.Translate(opts.Affinity, src, dst)
+	if err != nil {
+		return err
+	}
+	cpus, err := topology.ParseList(opts.Affinity)
+	if err != nil {
+		return err
+	}
+	ui.PrintTranslation(opts.Affinity, t, affinity.DescribeLocality(src, cpus), affinity.DescribeLocality(dst, t.CPUs))
+	return nil
+}
+
+// runKernelCmdline derives isolation parameters from the VMs' affinities and
+// the reservation, compares them with the running kernel's and optionally
+// writes them into the boot configuration.
+func runKernelCmdline(args []string) error {
+	opts, err := cmd.ParseKernelCmdline(args)
+	if err != nil {
+		return err
+	}
+	root := "/"
+	var topo *topology.CPUTopology
+	if opts.Sysroot != "" {
+		root = opts.Sysroot
+		topo, err = topology.DetectRoot(opts.Sysroot)
+	} else {
+		topo, err = topology.Detect()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The VMs of the host being described, so a sysroot is planned with
+	// its own configs rather than this machine's.
+	affinities, err := pve.ReadAffinities(filepath.Join(root, pve.ConfigDir))
+	if err != nil {
+		return err
+	}
+	var pinned []int
+	for vmid, aff := range affinities {
+		cpus, err := topology.ParseList(aff)
+		if err != nil {
+			return fmt.Errorf("VM %d: invalid affinity %q: %w", vmid, aff, err)
+		}
+		pinned = append(pinned, cpus...)
+	}
+	iso, err := host.NewIsolation(topo.OnlineCPUs(), pinned, opts.Reservation.Resolve(topo))
+	if err != nil {
+		return err
+	}
+	params := iso.Params()
+
+	running, err := os.ReadFile(filepath.Join(root, "proc/cmdline"))
+	if err != nil {
+		return err
+	}
+	changes := host.DiffCmdline(string(running), params)
+
+	boot, bootErr := host.DetectBootloader(root)
+	if !opts.Write {
+		ui.PrintKernelCmdline(iso, changes, boot)
+		return nil
+	}
+	if bootErr != nil {
+		return bootErr
+	}
+	cmdline, err := boot.Update(params, opts.DryRun)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		if err := boot.RunRefresh(); err != nil {
+			return err
+		}
+	}
+	ui.PrintKernelCmdline(iso, changes, boot)
+	ui.PrintBootUpdate(boot, cmdline, opts.DryRun)
+	return nil
+}
+
+// loadTopology reads a topology snapshot, or a sysroot if path is a
+// directory, or this host's topology if path is empty.
+func loadTopology(path string) (*topology.CPUTopology, error) {
+	if path == "" {
+		return topology.Detect()
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return topology.DetectRoot(path)
+	}
+	snap, err := topology.LoadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Detect()
+}
+
+func guestNUMAValues(nodes []affinity.GuestNUMANode) []string {
+	values := make([]string, len(nodes))
+	for i, node := range nodes {
+		values[i] = node.Value()
+	}
+	return values
+}
+
 func selectOption(options []affinity.Option, strategy affinity.Strategy) (affinity.Option, bool) {
 	for _, option := range options {
 		if option.Strategy == strategy {
@@ -130,13 +890,16 @@ func exitWithError(err error) {
 	}
 
 	switch {
-	case errors.Is(err, cmd.ErrInvalidArguments):
+	case errors.Is(err, cmd.ErrInvalidArguments) || errors.Is(err, pve.ErrVCPUMismatch):
 		ui.PrintError(err)
 		os.Exit(2)
+	case (errors.Is(err, pve.ErrPermissionDenied) || errors.Is(err, os.ErrPermission)) && !pve.IsLocal():
+		ui.PrintError(fmt.Errorf("%v. Check the API token and its privileges (VM.Audit, VM.Config.CPU).", err))
+		os.Exit(5)
 	case errors.Is(err, pve.ErrPermissionDenied) || errors.Is(err, os.ErrPermission):
 		ui.PrintError(errors.New("Permission denied. Try running with sudo."))
 		os.Exit(5)
-	case errors.Is(err, pve.ErrVMNotFound):
+	case errors.Is(err, pve.ErrVMNotFound) || errors.Is(err, journal.ErrNoEntry):
 		ui.PrintError(err)
 		os.Exit(4)
 	case errors.Is(err, topology.ErrTopologyUnavailable):